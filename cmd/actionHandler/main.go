@@ -3,10 +3,14 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/billglover/bbot/cmd/actionHandler/router"
+	"github.com/billglover/bbot/pkg/report"
 	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
 )
 
 func main() {
@@ -29,6 +33,24 @@ func main() {
 		os.Exit(1)
 	}
 
+	reportActionQ := os.Getenv("SQS_QUEUE_REPORTACTION")
+	if reportActionQ == "" {
+		fmt.Println("ERROR: SQS_QUEUE_REPORTACTION environment variable not set")
+		os.Exit(1)
+	}
+
+	commandQ := os.Getenv("SQS_QUEUE_COMMAND")
+	if commandQ == "" {
+		fmt.Println("ERROR: SQS_QUEUE_COMMAND environment variable not set")
+		os.Exit(1)
+	}
+
+	teamEventQ := os.Getenv("SQS_QUEUE_TEAMEVENT")
+	if teamEventQ == "" {
+		fmt.Println("ERROR: SQS_QUEUE_TEAMEVENT environment variable not set")
+		os.Exit(1)
+	}
+
 	// Retrieve the Slack signing secret from the AWS parameter store. This is
 	// used to ensure incoming requests orginated from Slack. If we can't
 	// retrieve the certificate we terminate the program as there is nothing
@@ -44,21 +66,85 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Requests older than the maximum age are rejected to prevent them being
+	// replayed. We use the default unless one is provided as an environment
+	// variable.
+	opts := []func(*router.Router) error{router.SigningSecret(signingSecret)}
+	if maxAge := os.Getenv("BUDDYBOT_REQUEST_MAX_AGE"); maxAge != "" {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil {
+			fmt.Println("ERROR: unable to parse BUDDYBOT_REQUEST_MAX_AGE:", err)
+			os.Exit(1)
+		}
+		opts = append(opts, router.MaxRequestAge(d))
+	}
+
 	// The router is responsible for validating the signature on requests that
 	// we receive and then identifying the action being requested before placing
 	// the message action onto the appropriate queue for processing. We
 	// configure the router by registering a mapping between actions and queues.
 	// If we are unable to register any routes we terminate the program as
 	// it offers no functionality without route mappings.
-	r, err := router.New(router.SigningSecret(signingSecret))
-	err = r.RegisterRoute("flagMessage", flagMessageQ)
+	r, err := router.New(opts...)
+	if err != nil {
+		fmt.Println("ERROR: unable to create router:", err)
+		os.Exit(1)
+	}
+
+	err = r.RegisterRoute(report.FlagCallbackID, flagMessageQ)
+	if err != nil {
+		fmt.Println("ERROR: unable to register queue:", err)
+		os.Exit(1)
+	}
+
+	err = r.RegisterRoute(report.FlagAnonymouslyCallbackID, flagMessageQ)
 	if err != nil {
 		fmt.Println("ERROR: unable to register queue:", err)
 		os.Exit(1)
 	}
 
-	// We tell AWS Lambda to start routing incoming message actions using our
-	// router. The router is responsible for sending the appropriate responses
-	// to all requests.
+	err = r.RegisterRoute(report.DetailsCallbackID, flagMessageQ)
+	if err != nil {
+		fmt.Println("ERROR: unable to register queue:", err)
+		os.Exit(1)
+	}
+
+	err = r.RegisterRoute(report.CallbackID, reportActionQ)
+	if err != nil {
+		fmt.Println("ERROR: unable to register queue:", err)
+		os.Exit(1)
+	}
+
+	err = r.RegisterRoute(report.NoteCallbackID, reportActionQ)
+	if err != nil {
+		fmt.Println("ERROR: unable to register queue:", err)
+		os.Exit(1)
+	}
+
+	err = r.RegisterCommand("/buddybot", commandQ)
+	if err != nil {
+		fmt.Println("ERROR: unable to register queue:", err)
+		os.Exit(1)
+	}
+
+	// Submissions of the configuration modal opened by `/buddybot config`
+	// are handled alongside the slash commands.
+	err = r.RegisterRoute(settings.CallbackID, commandQ)
+	if err != nil {
+		fmt.Println("ERROR: unable to register queue:", err)
+		os.Exit(1)
+	}
+
+	for _, et := range []string{slack.EventAppUninstalled, slack.EventTokensRevoked} {
+		err = r.RegisterEvent(et, teamEventQ)
+		if err != nil {
+			fmt.Println("ERROR: unable to register queue:", err)
+			os.Exit(1)
+		}
+	}
+
+	// We tell AWS Lambda to start routing incoming message actions, slash
+	// commands and events using our router. The router is responsible for
+	// sending the appropriate responses to all requests.
 	lambda.Start(r.Route)
 }
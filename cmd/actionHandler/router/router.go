@@ -1,7 +1,8 @@
 /*
-Package router provides a service for routing Slack message actions to queues
-for processing. It validates all requests using the Slack signing key to ensure
-that all requests originated from Slack. Invalid requests are rejected.
+Package router provides a service for routing Slack message actions, slash
+commands and events to queues for processing. It validates all requests using
+the Slack signing key to ensure that all requests originated from Slack.
+Invalid requests are rejected.
 
 The router responds to the original request indicating the message has been
 routed successfully (accepted). If it is unable to route the request an
@@ -13,6 +14,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/billglover/bbot/pkg/agw"
 	"github.com/billglover/bbot/pkg/queue"
@@ -20,10 +24,15 @@ import (
 )
 
 // Router requires access to the Slack signing secret and the mapping between
-// message actions and queues.
+// message actions, slash commands, events and queues.
 type Router struct {
 	signingSecret string
+	maxAge        time.Duration
+	validator     *agw.Validator
+	newQueue      func(url string) (queue.Queuer, error)
 	queues        map[string]queue.Queuer
+	commands      map[string]queue.Queuer
+	events        map[string]queue.Queuer
 }
 
 // New returns a new Router. It optionally takes configuration functions to
@@ -31,6 +40,12 @@ type Router struct {
 func New(options ...func(*Router) error) (*Router, error) {
 	r := new(Router)
 	r.queues = make(map[string]queue.Queuer)
+	r.commands = make(map[string]queue.Queuer)
+	r.events = make(map[string]queue.Queuer)
+	r.maxAge = agw.DefaultMaxAge
+	r.newQueue = func(url string) (queue.Queuer, error) {
+		return queue.NewSQSQueue(url)
+	}
 	for _, option := range options {
 		err := option(r)
 		if err != nil {
@@ -38,6 +53,14 @@ func New(options ...func(*Router) error) (*Router, error) {
 		}
 	}
 
+	// Signatures are remembered for as long as requests are accepted so that
+	// a request can't be replayed within the window.
+	v, err := agw.NewValidator(r.signingSecret, agw.MaxAge(r.maxAge), agw.Nonces(agw.NewNonceCache(r.maxAge)))
+	if err != nil {
+		return r, err
+	}
+	r.validator = v
+
 	return r, nil
 }
 
@@ -50,10 +73,28 @@ func SigningSecret(id string) func(*Router) error {
 	}
 }
 
+// MaxRequestAge sets the age beyond which requests are rejected during
+// routing. This prevents captured requests from being replayed.
+func MaxRequestAge(d time.Duration) func(*Router) error {
+	return func(r *Router) error {
+		r.maxAge = d
+		return nil
+	}
+}
+
+// QueueFunc sets the function used to create a queue from the URL provided
+// when registering a route. By default routes are registered to SQS queues.
+func QueueFunc(fn func(url string) (queue.Queuer, error)) func(*Router) error {
+	return func(r *Router) error {
+		r.newQueue = fn
+		return nil
+	}
+}
+
 // RegisterRoute associates a mapping between a message action identifier and
-// an outbound queue.s
+// an outbound queue.
 func (r *Router) RegisterRoute(id, url string) error {
-	q, err := queue.NewSQSQueue(url)
+	q, err := r.newQueue(url)
 	if err != nil {
 		return err
 	}
@@ -61,14 +102,78 @@ func (r *Router) RegisterRoute(id, url string) error {
 	return nil
 }
 
+// RegisterCommand associates a mapping between a slash command and an
+// outbound queue. The command may include a subcommand, e.g. "/buddybot coc",
+// in which case it takes precedence over a mapping for the command alone.
+func (r *Router) RegisterCommand(cmd, url string) error {
+	q, err := r.newQueue(url)
+	if err != nil {
+		return err
+	}
+	r.commands[cmd] = q
+	return nil
+}
+
+// RegisterEvent associates a mapping between an event type, e.g.
+// "app_uninstalled", and an outbound queue.
+func (r *Router) RegisterEvent(eventType, url string) error {
+	q, err := r.newQueue(url)
+	if err != nil {
+		return err
+	}
+	r.events[eventType] = q
+	return nil
+}
+
 // Route takes a context and an inbound request. It routes the request to a queue based
 // on the registered routes. It returns a response and an error.
 func (r *Router) Route(ctx context.Context, req agw.Request) (agw.Response, error) {
-	if req.IsValid(r.signingSecret) == false {
-		fmt.Println("ERROR: invalid request, check request signature")
+	if err := r.validator.Validate(req); err != nil {
+		fmt.Println("ERROR: invalid request:", err)
 		return agw.ErrorResponse("invalid request, check request signature", http.StatusBadRequest)
 	}
 
+	// The body has been validated so we replace it with the decoded body to
+	// save decoding it again when parsing.
+	body, err := req.DecodedBody()
+	if err != nil {
+		fmt.Println("ERROR: unable to decode request body:", err)
+		return agw.ErrorResponse("unable to decode request body", http.StatusBadRequest)
+	}
+	req.Body = body
+	req.IsBase64Encoded = false
+
+	// Events are sent as JSON whereas everything else is form encoded.
+	if strings.HasPrefix(strings.TrimSpace(req.Body), "{") {
+		return r.routeEvent(ctx, req)
+	}
+
+	form, err := url.ParseQuery(req.Body)
+	if err != nil {
+		fmt.Println("ERROR: unable to parse request body:", err)
+		return agw.ErrorResponse("unable to parse request body", http.StatusBadRequest)
+	}
+
+	// Message actions are sent as a JSON payload in a form field whereas
+	// slash commands are sent as plain form fields.
+	if _, ok := form["payload"]; ok == false {
+		return r.routeCommand(ctx, req)
+	}
+	return r.routeAction(ctx, req)
+}
+
+// routeAction places a message action onto the queue registered for its
+// callback ID. Modal submissions are passed on to routeSubmission.
+func (r *Router) routeAction(ctx context.Context, req agw.Request) (agw.Response, error) {
+	it, err := slack.InteractionType(req.Body)
+	if err != nil {
+		fmt.Println("ERROR: unable to parse interaction:", err)
+		return agw.ErrorResponse("unable to parse interaction", http.StatusBadRequest)
+	}
+	if it == slack.InteractionViewSubmission {
+		return r.routeSubmission(ctx, req)
+	}
+
 	action, err := slack.ParseAction(req.Body)
 	if err != nil {
 		fmt.Println("ERROR: unable to parse message action:", err)
@@ -94,3 +199,117 @@ func (r *Router) Route(ctx context.Context, req agw.Request) (agw.Response, erro
 	fmt.Println("INFO: action queued for processing")
 	return agw.SuccessResponse()
 }
+
+// routeSubmission places a modal submission onto the queue registered for the
+// callback ID of the modal.
+func (r *Router) routeSubmission(ctx context.Context, req agw.Request) (agw.Response, error) {
+	vs, err := slack.ParseViewSubmission(req.Body)
+	if err != nil {
+		fmt.Println("ERROR: unable to parse view submission:", err)
+		return agw.ErrorResponse("unable to parse view submission", http.StatusBadRequest)
+	}
+
+	q, ok := r.queues[vs.View.CallbackID]
+	if ok == false {
+		fmt.Println("ERROR: view submission not supported")
+		return agw.ErrorResponse("view submission not supported: "+vs.View.CallbackID, http.StatusNotImplemented)
+	}
+
+	h := queue.Headers{
+		"Team": vs.Team.ID,
+	}
+
+	err = q.Queue(ctx, h, vs)
+	if err != nil {
+		fmt.Println("ERROR: unable to handle view submission:", err)
+		return agw.ErrorResponse("unable to handle view submission", http.StatusInternalServerError)
+	}
+
+	// Slack closes the modal when we respond with an empty response.
+	fmt.Println("INFO: view submission queued for processing")
+	return agw.EmptyResponse()
+}
+
+// routeCommand places a slash command onto the queue registered for the
+// command and subcommand, falling back to the queue registered for the
+// command alone.
+func (r *Router) routeCommand(ctx context.Context, req agw.Request) (agw.Response, error) {
+	cmd, err := slack.ParseSlashCommand(req.Body)
+	if err != nil {
+		fmt.Println("ERROR: unable to parse slash command:", err)
+		return agw.ErrorResponse("unable to parse slash command", http.StatusBadRequest)
+	}
+
+	sub, _ := cmd.Subcommand()
+	q, ok := r.commands[cmd.Command+" "+sub]
+	if ok == false {
+		q, ok = r.commands[cmd.Command]
+	}
+	if ok == false {
+		fmt.Println("ERROR: slash command not supported")
+		return agw.ErrorResponse("slash command not supported: "+cmd.Command, http.StatusNotImplemented)
+	}
+
+	h := queue.Headers{
+		"Team": cmd.TeamID,
+	}
+
+	err = q.Queue(ctx, h, cmd)
+	if err != nil {
+		fmt.Println("ERROR: unable to handle slash command:", err)
+		return agw.ErrorResponse("unable to handle slash command", http.StatusInternalServerError)
+	}
+
+	// Slack displays anything we respond with to the user who invoked the
+	// command so we acknowledge it with an empty response.
+	fmt.Println("INFO: slash command queued for processing")
+	return agw.EmptyResponse()
+}
+
+// routeEvent answers Slack's endpoint verification challenge and places
+// events onto the queue registered for their type. Events without a
+// registered queue are acknowledged and dropped so that Slack doesn't retry
+// them.
+func (r *Router) routeEvent(ctx context.Context, req agw.Request) (agw.Response, error) {
+	ec, err := slack.ParseEvent(req.Body)
+	if err != nil {
+		fmt.Println("ERROR: unable to parse event:", err)
+		return agw.ErrorResponse("unable to parse event", http.StatusBadRequest)
+	}
+
+	if ec.Type == slack.EventTypeURLVerification {
+		fmt.Println("INFO: responding to url verification challenge")
+		return agw.ChallengeResponse(ec.Challenge)
+	}
+
+	if ec.Type != slack.EventTypeCallback {
+		fmt.Println("ERROR: event callback type not supported:", ec.Type)
+		return agw.ErrorResponse("event callback type not supported: "+ec.Type, http.StatusNotImplemented)
+	}
+
+	et, err := ec.EventType()
+	if err != nil {
+		fmt.Println("ERROR: unable to determine event type:", err)
+		return agw.ErrorResponse("unable to determine event type", http.StatusBadRequest)
+	}
+
+	q, ok := r.events[et]
+	if ok == false {
+		fmt.Println("INFO: ignoring event:", et)
+		return agw.EmptyResponse()
+	}
+
+	h := queue.Headers{
+		"Team":  ec.TeamID,
+		"Event": et,
+	}
+
+	err = q.Queue(ctx, h, ec)
+	if err != nil {
+		fmt.Println("ERROR: unable to handle event:", err)
+		return agw.ErrorResponse("unable to handle event", http.StatusInternalServerError)
+	}
+
+	fmt.Println("INFO: event queued for processing")
+	return agw.EmptyResponse()
+}
@@ -1,6 +1,20 @@
 package router
 
-import "testing"
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/billglover/bbot/pkg/agw"
+	"github.com/billglover/bbot/pkg/queue"
+	"github.com/billglover/bbot/pkg/slack"
+)
 
 func TestSigningSecret(t *testing.T) {
 	r, _ := New(SigningSecret("dummy secret"))
@@ -8,3 +22,254 @@ func TestSigningSecret(t *testing.T) {
 		t.Error("unexpected signing secret:", r.signingSecret)
 	}
 }
+
+func TestMaxRequestAge(t *testing.T) {
+	r, err := New(SigningSecret("dummy secret"), MaxRequestAge(time.Minute))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if r.maxAge != time.Minute {
+		t.Error("unexpected max request age:", r.maxAge)
+	}
+
+	_, err = New(SigningSecret("dummy secret"), MaxRequestAge(0))
+	if err == nil {
+		t.Error("expected an error for a zero max request age")
+	}
+}
+
+// recorder is a queue that keeps the headers and bodies placed on it.
+type recorder struct {
+	headers []queue.Headers
+	bodies  []queue.Body
+}
+
+func (r *recorder) Queue(ctx context.Context, h queue.Headers, b queue.Body) error {
+	r.headers = append(r.headers, h)
+	r.bodies = append(r.bodies, b)
+	return nil
+}
+
+// routes returns a Router using the secret "secret" along with a recorder
+// for each queue URL used when registering routes.
+func routes(t *testing.T) (*Router, map[string]*recorder) {
+	qs := make(map[string]*recorder)
+	r, err := New(
+		SigningSecret("secret"),
+		QueueFunc(func(url string) (queue.Queuer, error) {
+			if _, ok := qs[url]; ok == false {
+				qs[url] = &recorder{}
+			}
+			return qs[url], nil
+		}),
+	)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	return r, qs
+}
+
+// signedRequest returns a request signed with the secret at the current time.
+func signedRequest(secret, body string) agw.Request {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	hash := hmac.New(sha256.New, []byte(secret))
+	hash.Write([]byte("v0:" + ts + ":" + body))
+	return agw.Request{
+		HTTPMethod: http.MethodPost,
+		Body:       body,
+		Headers: map[string]string{
+			"X-Slack-Request-Timestamp": ts,
+			"X-Slack-Signature":         "v0=" + hex.EncodeToString(hash.Sum(nil)),
+		},
+	}
+}
+
+func TestRouteSubmission(t *testing.T) {
+	q := &recorder{}
+	r, err := New(
+		SigningSecret("secret"),
+		QueueFunc(func(string) (queue.Queuer, error) { return q, nil }),
+	)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := r.RegisterRoute("config", "commandQ"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	tcs := []struct {
+		name   string
+		id     string
+		status int
+		queued int
+	}{
+		{name: "registered", id: "config", status: http.StatusOK, queued: 1},
+		{name: "unknown", id: "other", status: http.StatusNotImplemented, queued: 0},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			q.bodies = nil
+
+			payload := `{"type":"view_submission","team":{"id":"T1"},"user":{"id":"U1"},"view":{"type":"modal","callback_id":"` + tc.id + `"}}`
+			body := url.Values{"payload": {payload}}.Encode()
+
+			resp, _ := r.Route(context.Background(), signedRequest("secret", body))
+			if resp.StatusCode != tc.status {
+				t.Fatal("unexpected status code:", resp.StatusCode)
+			}
+			if len(q.bodies) != tc.queued {
+				t.Fatal("unexpected number of submissions queued:", len(q.bodies))
+			}
+			if tc.queued == 0 {
+				return
+			}
+
+			vs, ok := q.bodies[0].(slack.ViewSubmission)
+			if ok == false || vs.Team.ID != "T1" || vs.View.CallbackID != tc.id {
+				t.Errorf("unexpected submission queued: %+v", q.bodies[0])
+			}
+		})
+	}
+}
+
+func TestRouteCommand(t *testing.T) {
+	r, qs := routes(t)
+	if err := r.RegisterCommand("/buddybot", "commandQ"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := r.RegisterCommand("/buddybot config", "configQ"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	tcs := []struct {
+		name    string
+		command string
+		text    string
+		status  int
+		queue   string
+	}{
+		{name: "command", command: "/buddybot", text: "report someone was rude", status: http.StatusOK, queue: "commandQ"},
+		{name: "no subcommand", command: "/buddybot", status: http.StatusOK, queue: "commandQ"},
+		{name: "subcommand", command: "/buddybot", text: "Config", status: http.StatusOK, queue: "configQ"},
+		{name: "unregistered", command: "/other", text: "config", status: http.StatusNotImplemented},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, q := range qs {
+				q.headers, q.bodies = nil, nil
+			}
+
+			body := url.Values{"command": {tc.command}, "text": {tc.text}, "team_id": {"T1"}, "user_id": {"U1"}}.Encode()
+			resp, _ := r.Route(context.Background(), signedRequest("secret", body))
+			if resp.StatusCode != tc.status {
+				t.Fatal("unexpected status code:", resp.StatusCode)
+			}
+
+			for url, q := range qs {
+				want := 0
+				if url == tc.queue {
+					want = 1
+				}
+				if len(q.bodies) != want {
+					t.Fatalf("unexpected number of commands queued on %s: %d", url, len(q.bodies))
+				}
+			}
+			if tc.queue == "" {
+				return
+			}
+
+			q := qs[tc.queue]
+			sc, ok := q.bodies[0].(slack.SlashCommand)
+			if ok == false || sc.Command != tc.command || sc.Text != tc.text {
+				t.Errorf("unexpected command queued: %+v", q.bodies[0])
+			}
+			if q.headers[0]["Team"] != "T1" {
+				t.Error("unexpected headers:", q.headers[0])
+			}
+		})
+	}
+}
+
+func TestRouteEvent(t *testing.T) {
+	r, qs := routes(t)
+	for _, et := range []string{slack.EventAppUninstalled, slack.EventTokensRevoked} {
+		if err := r.RegisterEvent(et, "teamEventQ"); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if err := r.RegisterEvent(slack.EventMemberJoinedChannel, "memberQ"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	tcs := []struct {
+		name   string
+		body   string
+		status int
+		queue  string
+	}{
+		{name: "app uninstalled", body: `{"type":"event_callback","team_id":"T1","event":{"type":"app_uninstalled"}}`, status: http.StatusOK, queue: "teamEventQ"},
+		{name: "tokens revoked", body: `{"type":"event_callback","team_id":"T1","event":{"type":"tokens_revoked","tokens":{"bot":["B1"]}}}`, status: http.StatusOK, queue: "teamEventQ"},
+		{name: "member joined channel", body: `{"type":"event_callback","team_id":"T1","event":{"type":"member_joined_channel","user":"U1","channel":"C1"}}`, status: http.StatusOK, queue: "memberQ"},
+		{name: "unregistered", body: `{"type":"event_callback","team_id":"T1","event":{"type":"message","text":"hello"}}`, status: http.StatusOK},
+		{name: "no event", body: `{"type":"event_callback","team_id":"T1"}`, status: http.StatusBadRequest},
+		{name: "unsupported callback type", body: `{"type":"app_rate_limited","team_id":"T1"}`, status: http.StatusNotImplemented},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, q := range qs {
+				q.headers, q.bodies = nil, nil
+			}
+
+			resp, _ := r.Route(context.Background(), signedRequest("secret", tc.body))
+			if resp.StatusCode != tc.status {
+				t.Fatal("unexpected status code:", resp.StatusCode)
+			}
+
+			// Events without a registered queue are dropped.
+			for url, q := range qs {
+				want := 0
+				if url == tc.queue {
+					want = 1
+				}
+				if len(q.bodies) != want {
+					t.Fatalf("unexpected number of events queued on %s: %d", url, len(q.bodies))
+				}
+			}
+			if tc.queue == "" {
+				return
+			}
+
+			q := qs[tc.queue]
+			ec, ok := q.bodies[0].(slack.EventCallback)
+			if ok == false || ec.TeamID != "T1" {
+				t.Errorf("unexpected event queued: %+v", q.bodies[0])
+			}
+			et, _ := ec.EventType()
+			if q.headers[0]["Team"] != "T1" || q.headers[0]["Event"] != et {
+				t.Error("unexpected headers:", q.headers[0])
+			}
+		})
+	}
+}
+
+func TestRouteURLVerification(t *testing.T) {
+	r, qs := routes(t)
+	if err := r.RegisterEvent(slack.EventAppUninstalled, "teamEventQ"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	body := `{"type":"url_verification","token":"token","challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`
+	resp, _ := r.Route(context.Background(), signedRequest("secret", body))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("unexpected status code:", resp.StatusCode)
+	}
+	if resp.Body != `{"challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}` {
+		t.Error("unexpected response:", resp.Body)
+	}
+	if len(qs["teamEventQ"].bodies) != 0 {
+		t.Error("challenge queued as an event")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Package auth completes the OAuth flow used to install BuddyBot in a Slack team
+and stores the access tokens granted.
+*/
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/billglover/bbot/pkg/agw"
+	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/slack"
+)
+
+// Handler exchanges the temporary code Slack provides when BuddyBot is
+// installed for access tokens.
+type Handler struct {
+	clientID     string
+	clientSecret string
+	teams        *slack.Teams
+}
+
+// New takes the Slack app credentials and the teams that have installed
+// BuddyBot, which the tokens granted are added to, and returns a pointer to
+// a Handler.
+func New(clientID, clientSecret string, teams *slack.Teams) *Handler {
+	return &Handler{clientID: clientID, clientSecret: clientSecret, teams: teams}
+}
+
+// Handle takes the request Slack redirects to once a team has authorised
+// BuddyBot and stores the access tokens for the team.
+func (h *Handler) Handle(ctx context.Context, req agw.Request) (agw.Response, error) {
+
+	// change the temporary code for an API access token
+	v := url.Values{}
+	v.Set("code", req.QueryStringParameters["code"])
+	v.Set("name", "https://ro9agrx7m2.execute-api.eu-west-1.amazonaws.com/dev/endpoint/auth")
+
+	r, err := http.NewRequest(http.MethodPost, h.teams.APIURL()+"oauth.access", strings.NewReader(v.Encode()))
+	if err != nil {
+		fmt.Println("ERROR: unable to build auth token request:", err)
+		return agw.ErrorResponse("unable to request auth token", http.StatusInternalServerError)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(h.clientID, h.clientSecret)
+	client := http.DefaultClient
+	resp, err := client.Do(r.WithContext(ctx))
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		fmt.Println("ERROR: unable to request auth token:", err)
+		return agw.ErrorResponse("unable to request auth token", http.StatusInternalServerError)
+	}
+
+	ar := new(slack.AuthResponse)
+	err = json.NewDecoder(resp.Body).Decode(ar)
+	if err != nil {
+		fmt.Println("ERROR: unable to decode auth token:", err)
+		return agw.ErrorResponse("unable to decode auth token", http.StatusInternalServerError)
+	}
+
+	if ar.Ok == false {
+		fmt.Println("ERROR: auth token request rejected:", ar.Error)
+		return agw.ErrorResponse("unable to request auth token", http.StatusInternalServerError)
+	}
+
+	t := secrets.AuthRecord{
+		UID:            ar.TeamID,
+		UserID:         ar.UserID,
+		AccessToken:    ar.AccessToken,
+		Scope:          ar.Scope,
+		TeamName:       ar.TeamName,
+		TeamID:         ar.TeamID,
+		BotUserID:      ar.Bot.BotUserID,
+		BotAccessToken: ar.Bot.BotAccessToken,
+	}
+
+	err = h.teams.Add(t)
+	if err != nil {
+		fmt.Println("ERROR: unable to save auth token:", err)
+		return agw.ErrorResponse("unable to save auth token", http.StatusInternalServerError)
+	}
+
+	fmt.Printf("INFO: authorisation granted for team: %s (%s)\n", t.TeamName, t.TeamID)
+
+	return agw.SuccessResponse()
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/billglover/bbot/pkg/agw"
+	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/slack/slacktest"
+	"github.com/billglover/bbot/pkg/storage"
+)
+
+func TestHandle(t *testing.T) {
+	srv := slacktest.NewServer()
+	defer srv.Close()
+
+	ar := slack.AuthResponse{
+		AccessToken: "xoxp-1",
+		Scope:       "commands,bot",
+		UserID:      "U1",
+		TeamName:    "Team One",
+		TeamID:      "T1",
+	}
+	ar.Bot.BotUserID = "B1"
+	ar.Bot.BotAccessToken = "xoxb-1"
+	srv.SetAuth(ar)
+
+	tokens := storage.NewMemory("uid")
+	teams, err := slack.NewTeams(tokens, slack.APIURL(srv.APIURL()))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	h := New("client", "secret", teams)
+
+	req := agw.Request{QueryStringParameters: map[string]string{"code": "abc"}}
+	resp, err := h.Handle(context.Background(), req)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatal("unexpected status code:", resp.StatusCode)
+	}
+
+	calls := srv.Calls("oauth.access")
+	if len(calls) != 1 || calls[0].Values.Get("code") != "abc" {
+		t.Fatalf("unexpected calls to oauth.access: %v", calls)
+	}
+
+	got, err := secrets.GetTeamTokens(tokens, "T1")
+	if err != nil {
+		t.Fatal("tokens not saved:", err)
+	}
+	if got.AccessToken != "xoxp-1" || got.BotAccessToken != "xoxb-1" || got.BotUserID != "B1" || got.TeamName != "Team One" {
+		t.Errorf("unexpected tokens saved: %+v", got)
+	}
+}
+
+func TestHandleSlackError(t *testing.T) {
+	srv := slacktest.NewServer()
+	defer srv.Close()
+	srv.SetError("oauth.access", "invalid_code")
+
+	tokens := storage.NewMemory("uid")
+	teams, err := slack.NewTeams(tokens, slack.APIURL(srv.APIURL()))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	h := New("client", "secret", teams)
+
+	req := agw.Request{QueryStringParameters: map[string]string{"code": "abc"}}
+	resp, _ := h.Handle(context.Background(), req)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatal("unexpected status code:", resp.StatusCode)
+	}
+
+	var records []secrets.AuthRecord
+	if err := tokens.Query("", "team_id", "", &records); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("unexpected tokens saved: %+v", records)
+	}
+}
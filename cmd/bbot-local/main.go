@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/billglover/bbot/cmd/actionHandler/router"
+	"github.com/billglover/bbot/cmd/authHandler/auth"
+	"github.com/billglover/bbot/cmd/commandRunner/runner"
+	"github.com/billglover/bbot/cmd/msgFlagger/flagger"
+	"github.com/billglover/bbot/cmd/msgSender/sender"
+	"github.com/billglover/bbot/cmd/reportManager/manager"
+	"github.com/billglover/bbot/cmd/teamManager/team"
+	"github.com/billglover/bbot/pkg/agw"
+	"github.com/billglover/bbot/pkg/queue"
+	"github.com/billglover/bbot/pkg/report"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/slack/slacktest"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/billglover/bbot/pkg/templates"
+)
+
+// Names of the in-memory queues that stand in for the SQS queues created by
+// serverless.yml.
+const (
+	flagMessageQ  = "flagMessage"
+	reportActionQ = "reportAction"
+	commandQ      = "command"
+	teamEventQ    = "teamEvent"
+	sendMessageQ  = "sendMessage"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to serve the auth, action and events endpoints on")
+	data := flag.String("data", "", "directory to store tokens, reports and settings in, kept in memory if not set")
+	tmplDir := flag.String("templates", templates.DefaultDir, "directory containing the notification templates")
+	slackAPI := flag.String("slack-api", slack.DefaultAPIURL, "base URL of the Slack Web API, e.g. a fake Slack server")
+	fakeSlack := flag.Bool("fake-slack", false, "run a fake Slack server, with an admins channel, in place of the Slack API")
+	flag.Parse()
+
+	// The fake Slack server records calls rather than delivering messages so
+	// that the whole flow can be followed without a Slack workspace.
+	if *fakeSlack {
+		srv := slacktest.NewServer()
+		defer srv.Close()
+		srv.AddChannel(slacktest.Channel{ID: "GADMINS", Name: "admins", Private: true})
+		ar := slack.AuthResponse{TeamID: "TLOCAL", TeamName: "Local", UserID: "ULOCAL", AccessToken: "xoxp-local"}
+		ar.Bot.BotUserID = "BLOCAL"
+		ar.Bot.BotAccessToken = "xoxb-local"
+		srv.SetAuth(ar)
+		*slackAPI = srv.APIURL()
+		fmt.Println("INFO: fake Slack API running at", *slackAPI)
+	}
+
+	// Secrets that would otherwise come from the AWS parameter store are
+	// read from the environment.
+	signingSecret := os.Getenv("SLACK_SIGNING_SECRET")
+	if signingSecret == "" {
+		fmt.Println("ERROR: SLACK_SIGNING_SECRET environment variable not set")
+		os.Exit(1)
+	}
+	clientID := os.Getenv("SLACK_CLIENT_ID")
+	clientSecret := os.Getenv("SLACK_CLIENT_SECRET")
+
+	tokens, reports, teamData, err := stores(*data)
+	if err != nil {
+		fmt.Println("ERROR: unable to open data store:", err)
+		os.Exit(1)
+	}
+
+	tmpl, err := templates.Load(*tmplDir)
+	if err != nil {
+		fmt.Println("ERROR: unable to load templates:", err)
+		os.Exit(1)
+	}
+
+	// Each queue is consumed by the handler of the Lambda function that
+	// would be subscribed to it once deployed.
+	queues := map[string]*queue.MemoryQueue{}
+	for _, name := range []string{flagMessageQ, reportActionQ, commandQ, teamEventQ, sendMessageQ} {
+		queues[name] = queue.NewMemoryQueue(name, 100)
+	}
+	out := queues[sendMessageQ]
+
+	// Every function talks to the same Slack API, which may be the fake
+	// Slack server.
+	teams, err := slack.NewTeams(tokens, slack.APIURL(*slackAPI))
+	if err != nil {
+		fmt.Println("ERROR: unable to create teams:", err)
+		os.Exit(1)
+	}
+
+	f, err := flagger.New(out, teams, reports, teamData, flagger.Templates(tmpl))
+	if err != nil {
+		fmt.Println("ERROR: unable to create flagger:", err)
+		os.Exit(1)
+	}
+
+	mgr, err := manager.New(out, teams, reports, teamData, manager.Templates(tmpl))
+	if err != nil {
+		fmt.Println("ERROR: unable to create manager:", err)
+		os.Exit(1)
+	}
+
+	rn := runner.New(out, teams, reports, teamData)
+	snd := sender.New(out, teams, teamData)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go queues[flagMessageQ].Consume(ctx, f.Handle)
+	go queues[reportActionQ].Consume(ctx, mgr.Handle)
+	go queues[commandQ].Consume(ctx, rn.Handle)
+	go queues[teamEventQ].Consume(ctx, team.New(tokens).Handle)
+	go queues[sendMessageQ].Consume(ctx, snd.Handle)
+
+	r, err := router.New(
+		router.SigningSecret(signingSecret),
+		router.QueueFunc(func(name string) (queue.Queuer, error) {
+			q, ok := queues[name]
+			if ok == false {
+				return nil, fmt.Errorf("unknown queue: %s", name)
+			}
+			return q, nil
+		}),
+	)
+	if err != nil {
+		fmt.Println("ERROR: unable to create router:", err)
+		os.Exit(1)
+	}
+
+	routes := []error{
+		r.RegisterRoute(report.FlagCallbackID, flagMessageQ),
+		r.RegisterRoute(report.FlagAnonymouslyCallbackID, flagMessageQ),
+		r.RegisterRoute(report.DetailsCallbackID, flagMessageQ),
+		r.RegisterRoute(report.CallbackID, reportActionQ),
+		r.RegisterRoute(report.NoteCallbackID, reportActionQ),
+		r.RegisterCommand("/buddybot", commandQ),
+		r.RegisterRoute(settings.CallbackID, commandQ),
+		r.RegisterEvent(slack.EventAppUninstalled, teamEventQ),
+		r.RegisterEvent(slack.EventTokensRevoked, teamEventQ),
+	}
+	for _, err := range routes {
+		if err != nil {
+			fmt.Println("ERROR: unable to register queue:", err)
+			os.Exit(1)
+		}
+	}
+
+	a := auth.New(clientID, clientSecret, teams)
+
+	mux := http.NewServeMux()
+	mux.Handle("/auth", endpoint(http.MethodGet, a.Handle))
+	mux.Handle("/action", endpoint(http.MethodPost, r.Route))
+	mux.Handle("/events", endpoint(http.MethodPost, r.Route))
+
+	srv := &http.Server{Addr: *addr, Handler: mux}
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt)
+		<-stop
+
+		sCtx, sCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer sCancel()
+		srv.Shutdown(sCtx)
+	}()
+
+	fmt.Println("INFO: serving BuddyBot on", *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		fmt.Println("ERROR: unable to serve:", err)
+		os.Exit(1)
+	}
+}
+
+// stores returns the stores used for team tokens, reports and team data. They
+// are kept in files in the directory provided so that they survive a restart,
+// or in memory if no directory is provided.
+func stores(dir string) (tokens, reports, data storage.Store, err error) {
+	if dir == "" {
+		return storage.NewMemory("uid"), storage.NewMemory("uid"), storage.NewMemory("uid"), nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, nil, err
+	}
+
+	tokens, err = storage.NewFile(filepath.Join(dir, "tokens.json"), "uid")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	reports, err = storage.NewFile(filepath.Join(dir, "reports.json"), "uid")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	data, err = storage.NewFile(filepath.Join(dir, "data.json"), "uid")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return tokens, reports, data, nil
+}
+
+// endpoint adapts a Lambda function handling API Gateway requests so that it
+// can serve HTTP requests directly.
+func endpoint(method string, fn func(context.Context, agw.Request) (agw.Response, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != method {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, "unable to read request body", http.StatusBadRequest)
+			return
+		}
+
+		r := agw.Request{
+			Path:                  req.URL.Path,
+			HTTPMethod:            req.Method,
+			Headers:               map[string]string{},
+			QueryStringParameters: map[string]string{},
+			Body:                  string(body),
+		}
+		for k := range req.Header {
+			r.Headers[k] = req.Header.Get(k)
+		}
+		for k := range req.URL.Query() {
+			r.QueryStringParameters[k] = req.URL.Query().Get(k)
+		}
+
+		resp, err := fn(req.Context(), r)
+		if err != nil {
+			fmt.Println("ERROR: unable to handle request:", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		for k, v := range resp.Headers {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(resp.StatusCode)
+		w.Write([]byte(resp.Body))
+	})
+}
@@ -0,0 +1,332 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/queue"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/templates"
+	"github.com/pkg/errors"
+)
+
+// Block IDs of the fields in the configuration modal. Each notification
+// whose wording can be changed has a field with the ID "message_<kind>".
+const (
+	fieldAdminChannel  = "admin_channel"
+	fieldCoCURL        = "coc_url"
+	fieldAnonymous     = "anonymous"
+	fieldAuthorNotice  = "author_notice"
+	fieldReporterLimit = "reporter_limit"
+	fieldAuthorLimit   = "author_limit"
+	fieldMessage       = "message_"
+)
+
+// messageLabels describes each notification whose wording can be changed.
+var messageLabels = map[string]string{
+	settings.MessageReporter: "Message to people who flag a message",
+	settings.MessageAuthor:   "Message to the author of a flagged message",
+	settings.MessageWarning:  "Warning sent to authors by admins",
+}
+
+// Values of the options for the anonymous field in the configuration modal.
+const (
+	reportersNamed     = "named"
+	reportersAnonymous = "anonymous"
+)
+
+// notAdmin is sent to users who try to configure BuddyBot without being a
+// workspace admin.
+const notAdmin = "Only workspace admins can configure BuddyBot."
+
+// openConfig opens the configuration modal for the user who invoked the
+// slash command. Slack only accepts the trigger for a few seconds so the
+// modal is opened straight away and filled in once we have the settings.
+// Only workspace admins are allowed to configure BuddyBot.
+func (rn *Runner) openConfig(sc slack.SlashCommand) ([]messaging.Envelope, error) {
+	ws, ar, err := rn.teams.Workspace(sc.TeamID)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := ws.OpenView(sc.TriggerID, noticeView("Loading the current settings…"))
+	if err != nil {
+		return nil, err
+	}
+
+	admin, err := ws.IsAdmin(sc.UserID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to check user is an admin")
+	}
+	if admin == false {
+		return nil, ws.UpdateView(id, noticeView(notAdmin))
+	}
+
+	st, err := settings.Load(rn.data, ar)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch team settings")
+	}
+
+	return nil, ws.UpdateView(id, configView(st, sc.ChannelID))
+}
+
+// noticeView returns a configuration modal showing only the text provided.
+// It is shown while the settings are fetched, or in place of them if the user
+// isn't allowed to see them.
+func noticeView(txt string) slack.View {
+	return slack.View{
+		Type:   "modal",
+		Title:  messaging.PlainText("Configure BuddyBot"),
+		Close:  messaging.PlainText("Close"),
+		Blocks: []messaging.Block{messaging.Section(txt)},
+	}
+}
+
+// configView returns the configuration modal showing the current settings.
+// The channel the modal was opened from is kept in the modal so that we can
+// reply there once it is submitted.
+func configView(st settings.Settings, channel string) slack.View {
+	v := slack.View{
+		Type:            "modal",
+		CallbackID:      settings.CallbackID,
+		Title:           messaging.PlainText("Configure BuddyBot"),
+		Submit:          messaging.PlainText("Save"),
+		Close:           messaging.PlainText("Cancel"),
+		PrivateMetadata: channel,
+	}
+
+	admins := messaging.TextInput(fieldAdminChannel, "Admins channel", st.AdminChannel, false)
+	admins.Hint = messaging.PlainText("Name or ID of the channel flagged messages are reported in. Leave empty to use a private channel called \"" + slack.DefaultAdminChannel + "\".")
+	v.Blocks = append(v.Blocks, admins)
+
+	coc := messaging.TextInput(fieldCoCURL, "Code of Conduct link", st.CoCURL, false)
+	v.Blocks = append(v.Blocks, coc)
+
+	reporters := reportersNamed
+	if st.Anonymous {
+		reporters = reportersAnonymous
+	}
+	anon := messaging.SelectInput(fieldAnonymous, "People who flag a message", []messaging.Opt{
+		{Text: messaging.PlainText("Are shown to admins unless they flag it anonymously"), Value: reportersNamed},
+		{Text: messaging.PlainText("Are always hidden from admins"), Value: reportersAnonymous},
+	}, reporters)
+	v.Blocks = append(v.Blocks, anon)
+
+	notice := messaging.SelectInput(fieldAuthorNotice, "Authors of a flagged message are told", []messaging.Opt{
+		{Text: messaging.PlainText("Straight away"), Value: settings.NotifyImmediately},
+		{Text: messaging.PlainText("Once an admin confirms the report"), Value: settings.NotifyOnConfirmation},
+		{Text: messaging.PlainText("Never, unless an admin warns them"), Value: settings.NotifyNever},
+	}, st.NotifyAuthor())
+	v.Blocks = append(v.Blocks, notice)
+
+	reporterLimit := messaging.TextInput(fieldReporterLimit, "Messages one person can flag in an hour", limitValue(st.ReporterLimit), false)
+	reporterLimit.Hint = messaging.PlainText(fmt.Sprintf("Leave empty to allow %d. Use 0 for no limit.", settings.DefaultReporterLimit))
+	v.Blocks = append(v.Blocks, reporterLimit)
+
+	authorLimit := messaging.TextInput(fieldAuthorLimit, "Messages by one person that can be flagged in an hour", limitValue(st.AuthorLimit), false)
+	authorLimit.Hint = messaging.PlainText(fmt.Sprintf("Leave empty to allow %d. Use 0 for no limit.", settings.DefaultAuthorLimit))
+	v.Blocks = append(v.Blocks, authorLimit)
+
+	for _, kind := range settings.Messages {
+		txt, _ := st.Message(kind)
+		msg := messaging.TextInput(fieldMessage+kind, messageLabels[kind], txt, true)
+		msg.Hint = messaging.PlainText("Leave empty to use the default wording. You can include details such as {{.Author}}, {{.Channel}}, {{.Permalink}} or {{.CoCURL}}.")
+		v.Blocks = append(v.Blocks, msg)
+	}
+	return v
+}
+
+// Configure takes a submission of the configuration modal and stores the
+// settings for the team. The user is told once the settings are saved along
+// with any values that couldn't be used.
+func (rn *Runner) Configure(ctx context.Context, vs slack.ViewSubmission) error {
+	if vs.View.CallbackID != settings.CallbackID {
+		return errors.Errorf("view submission not supported: %s", vs.View.CallbackID)
+	}
+
+	ws, _, err := rn.teams.Workspace(vs.Team.ID)
+	if err != nil {
+		return err
+	}
+
+	// The modal is only opened for admins but we check again in case the
+	// user is no longer an admin.
+	admin, err := ws.IsAdmin(vs.User.ID)
+	if err != nil {
+		return errors.Wrap(err, "unable to check user is an admin")
+	}
+	if admin == false {
+		return rn.replyToSubmission(ctx, vs, notAdmin)
+	}
+
+	// The admins channel is resolved once, rather than each time we try to
+	// save the settings.
+	var problems []string
+	channel := strings.TrimSpace(vs.Value(fieldAdminChannel))
+	channelID := ""
+	if channel != "" {
+		channelID, err = ws.AdminChannelID(channel)
+		if err != nil {
+			fmt.Println("ERROR: unable to resolve admins channel:", err)
+			problems = append(problems, fmt.Sprintf("I can't find the channel %s so the admins channel hasn't changed. Please check the name and that I've been invited to the channel.", channel))
+		}
+	}
+
+	// Settings may be changed by someone else while we save them, e.g. the
+	// admins channel being resolved, in which case the submission is applied
+	// to their changes.
+	var rejected []string
+	_, err = settings.Update(rn.data, vs.Team.ID, func(st *settings.Settings) bool {
+		if channel == "" || channelID != "" {
+			st.AdminChannel = channelID
+		}
+		rejected = applySubmission(st, vs)
+		st.UpdatedBy = vs.User.ID
+		st.Updated = time.Now().UTC()
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	problems = append(problems, rejected...)
+	fmt.Printf("INFO: settings updated for team %s by %s\n", vs.Team.ID, vs.User.ID)
+
+	txt := "BuddyBot settings saved."
+	if len(problems) > 0 {
+		txt += "\n" + strings.Join(problems, "\n")
+	}
+	return rn.replyToSubmission(ctx, vs, txt)
+}
+
+// applySubmission takes the settings for a team and changes them to the
+// values submitted in the configuration modal, other than the admins channel.
+// It returns a description of each value that couldn't be used.
+func applySubmission(st *settings.Settings, vs slack.ViewSubmission) []string {
+	var problems []string
+
+	coc := strings.TrimSpace(vs.Value(fieldCoCURL))
+	if coc == "" || validURL(coc) {
+		st.CoCURL = coc
+	} else {
+		problems = append(problems, fmt.Sprintf("%s isn't a web address so the Code of Conduct link hasn't changed.", coc))
+	}
+
+	switch vs.Value(fieldAnonymous) {
+	case reportersNamed:
+		st.Anonymous = false
+	case reportersAnonymous:
+		st.Anonymous = true
+	}
+
+	switch notice := vs.Value(fieldAuthorNotice); notice {
+	case settings.NotifyImmediately, settings.NotifyOnConfirmation, settings.NotifyNever:
+		st.AuthorNotice = notice
+	}
+
+	limits := []struct {
+		field string
+		name  string
+		value *int
+	}{
+		{fieldReporterLimit, "number of messages one person can flag", &st.ReporterLimit},
+		{fieldAuthorLimit, "number of messages by one person that can be flagged", &st.AuthorLimit},
+	}
+	for _, l := range limits {
+		txt := strings.TrimSpace(vs.Value(l.field))
+		n, ok := parseLimit(txt)
+		if ok == false {
+			problems = append(problems, fmt.Sprintf("%s isn't a number so the %s hasn't changed.", txt, l.name))
+			continue
+		}
+		*l.value = n
+	}
+
+	// Wording is used as a template so we check it can be rendered before
+	// replacing what the team had before.
+	msgs := make(map[string]string)
+	for _, kind := range settings.Messages {
+		txt := strings.TrimSpace(vs.Value(fieldMessage + kind))
+		if txt == "" {
+			continue
+		}
+		if err := templates.Check(txt); err != nil {
+			problems = append(problems, fmt.Sprintf("The %s can't be used so it hasn't changed: %v", strings.ToLower(messageLabels[kind]), err))
+			if old, ok := st.Message(kind); ok {
+				msgs[kind] = old
+			}
+			continue
+		}
+		msgs[kind] = txt
+	}
+	st.Messages = msgs
+	return problems
+}
+
+// replyToSubmission sends an ephemeral message to the user who submitted the
+// configuration modal, shown in the channel the modal was opened from.
+func (rn *Runner) replyToSubmission(ctx context.Context, vs slack.ViewSubmission, txt string) error {
+	if vs.View.PrivateMetadata == "" {
+		return nil
+	}
+
+	e := messaging.Envelope{
+		Destination: messaging.Address{
+			TeamID:    vs.Team.ID,
+			ChannelID: vs.View.PrivateMetadata,
+			UserID:    vs.User.ID,
+		},
+		Message:  messaging.Message{Text: txt},
+		Delivery: messaging.DeliveryEphemeral,
+	}
+
+	h := queue.Headers{"Team": e.Destination.TeamID}
+	if err := rn.out.Queue(ctx, h, e); err != nil {
+		return errors.Wrap(err, "unable to queue reply")
+	}
+	return nil
+}
+
+// limitValue returns the value shown in the configuration modal for a limit
+// stored in the team settings. Teams using the default limit are shown an
+// empty field and teams without a limit are shown 0.
+func limitValue(n int) string {
+	switch {
+	case n == 0:
+		return ""
+	case n < 0:
+		return "0"
+	}
+	return strconv.Itoa(n)
+}
+
+// parseLimit takes the value entered for a limit in the configuration modal
+// and returns the limit stored in the team settings. It returns false if the
+// value isn't a number of messages.
+func parseLimit(txt string) (int, bool) {
+	if txt == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(txt)
+	switch {
+	case err != nil || n < 0:
+		return 0, false
+	case n == 0:
+		return settings.NoLimit, true
+	}
+	return n, true
+}
+
+// validURL reports whether s is an absolute web address.
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
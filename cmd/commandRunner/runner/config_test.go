@@ -0,0 +1,320 @@
+package runner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/slack/slacktest"
+	"github.com/billglover/bbot/pkg/storage"
+)
+
+// setup returns a Runner using a fake Slack server with a single team
+// installed, along with the server and outbound queue. U1 is an admin of the
+// team and U2 is not.
+func setup(t *testing.T) (*Runner, *slacktest.Server, *slacktest.Queue) {
+	srv := slacktest.NewServer()
+	srv.AddChannel(slacktest.Channel{ID: "G0ADMINS", Name: "admins", Private: true})
+	srv.AddChannel(slacktest.Channel{ID: "C0MODS01", Name: "moderators"})
+	srv.AddUser(slacktest.User{ID: "U1", Name: "admin", IsAdmin: true})
+	srv.AddUser(slacktest.User{ID: "U2", Name: "member"})
+
+	out := &slacktest.Queue{}
+	rn := New(out, srv.Install("T1"), storage.NewMemory("uid"), storage.NewMemory("uid"))
+	return rn, srv, out
+}
+
+// submission returns a submission of the configuration modal by the user
+// with the values provided, keyed by block ID.
+func submission(user string, values map[string]string) slack.ViewSubmission {
+	state := &slack.ViewState{Values: map[string]map[string]slack.ViewStateValue{}}
+	for id, v := range values {
+		state.Values[id] = map[string]slack.ViewStateValue{id: {Type: "plain_text_input", Value: v}}
+	}
+
+	return slack.ViewSubmission{
+		Type: slack.InteractionViewSubmission,
+		Team: slack.Team{ID: "T1"},
+		User: slack.User{ID: user},
+		View: slack.View{
+			Type:            "modal",
+			CallbackID:      settings.CallbackID,
+			PrivateMetadata: "C1",
+			State:           state,
+		},
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	tcs := []struct {
+		name  string
+		user  string
+		shown string
+	}{
+		{name: "admin", user: "U1", shown: settings.CallbackID},
+		{name: "member", user: "U2", shown: notAdmin},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rn, srv, out := setup(t)
+			defer srv.Close()
+
+			sc := slack.SlashCommand{
+				Command:   "/buddybot",
+				Text:      "config",
+				TeamID:    "T1",
+				ChannelID: "C1",
+				UserID:    tc.user,
+				TriggerID: "trigger",
+			}
+			if err := rn.RunCommand(context.Background(), sc); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			// The trigger expires quickly so the modal is opened before
+			// anything else is asked of Slack, and filled in afterwards.
+			if all := srv.Calls(""); len(all) == 0 || all[0].Method != "views.open" {
+				t.Fatalf("modal not opened first: %+v", all)
+			}
+			updates := srv.Calls("views.update")
+			if len(updates) != 1 {
+				t.Fatal("unexpected number of calls to views.update:", len(updates))
+			}
+			if strings.Contains(string(updates[0].Body), tc.shown) == false {
+				t.Errorf("unexpected view shown: %s", updates[0].Body)
+			}
+
+			if len(out.Envelopes) != 0 {
+				t.Error("unexpected replies:", out.Envelopes)
+			}
+		})
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	rn, srv, out := setup(t)
+	defer srv.Close()
+
+	vs := submission("U1", map[string]string{
+		fieldAdminChannel:                       "#moderators",
+		fieldCoCURL:                             "https://example.com/coc",
+		fieldMessage + settings.MessageReporter: "Thanks for letting us know.",
+		fieldMessage + settings.MessageAuthor:   " ",
+		fieldMessage + settings.MessageWarning:  "",
+		fieldMessage + "unknown":                "ignored",
+	})
+	if err := rn.Configure(context.Background(), vs); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	st, err := settings.Get(rn.data, "T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if st.AdminChannel != "C0MODS01" {
+		t.Error("unexpected admins channel:", st.AdminChannel)
+	}
+	if st.CoCURL != "https://example.com/coc" {
+		t.Error("unexpected Code of Conduct link:", st.CoCURL)
+	}
+	if len(st.Messages) != 1 || st.Messages[settings.MessageReporter] != "Thanks for letting us know." {
+		t.Errorf("unexpected messages: %v", st.Messages)
+	}
+	if st.UpdatedBy != "U1" || st.Updated.IsZero() {
+		t.Errorf("unexpected update: %s at %s", st.UpdatedBy, st.Updated)
+	}
+
+	if len(out.Envelopes) != 1 {
+		t.Fatal("unexpected number of replies:", len(out.Envelopes))
+	}
+	if d := out.Envelopes[0].Destination; d.ChannelID != "C1" || d.UserID != "U1" || out.Envelopes[0].Mode() != messaging.DeliveryEphemeral {
+		t.Errorf("unexpected reply: %+v", out.Envelopes[0])
+	}
+}
+
+func TestConfigureInvalid(t *testing.T) {
+	rn, srv, out := setup(t)
+	defer srv.Close()
+
+	err := settings.Save(rn.data, settings.Settings{
+		TeamID:       "T1",
+		AdminChannel: "G0ADMINS",
+		CoCURL:       "https://example.com/coc",
+		Messages:     map[string]string{settings.MessageReporter: "Thanks {{.Reporter}}"},
+	})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	vs := submission("U1", map[string]string{
+		fieldAdminChannel:                       "missing",
+		fieldCoCURL:                             "not a link",
+		fieldMessage + settings.MessageReporter: "Thanks {{.Nobody}}",
+	})
+	if err := rn.Configure(context.Background(), vs); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// Values that can't be used leave the existing settings in place.
+	st, err := settings.Get(rn.data, "T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if st.AdminChannel != "G0ADMINS" || st.CoCURL != "https://example.com/coc" || st.Messages[settings.MessageReporter] != "Thanks {{.Reporter}}" {
+		t.Errorf("unexpected settings: %+v", st)
+	}
+
+	if len(out.Envelopes) != 1 || strings.Count(out.Envelopes[0].Message.Text, "\n") != 3 {
+		t.Errorf("expected a reply listing every problem: %+v", out.Envelopes)
+	}
+}
+
+func TestConfigureNotAdmin(t *testing.T) {
+	rn, srv, out := setup(t)
+	defer srv.Close()
+
+	vs := submission("U2", map[string]string{fieldCoCURL: "https://example.com/coc"})
+	if err := rn.Configure(context.Background(), vs); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	st, err := settings.Get(rn.data, "T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if st.CoCURL != "" {
+		t.Error("settings changed by a member:", st.CoCURL)
+	}
+	if len(out.Envelopes) != 1 || out.Envelopes[0].Message.Text != notAdmin {
+		t.Errorf("unexpected replies: %+v", out.Envelopes)
+	}
+}
+
+func TestConfigureAnonymous(t *testing.T) {
+	rn, srv, _ := setup(t)
+	defer srv.Close()
+
+	for _, want := range []bool{true, false} {
+		value := reportersNamed
+		if want {
+			value = reportersAnonymous
+		}
+
+		// Select menus send the option chosen rather than a value.
+		vs := submission("U1", nil)
+		vs.View.State.Values[fieldAnonymous] = map[string]slack.ViewStateValue{
+			fieldAnonymous: {Type: "static_select", SelectedOption: &messaging.Opt{Value: value}},
+		}
+		if err := rn.Configure(context.Background(), vs); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+
+		st, err := settings.Get(rn.data, "T1")
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if st.Anonymous != want {
+			t.Errorf("unexpected anonymous setting for %s: %t", value, st.Anonymous)
+		}
+
+		v := configView(st, "C1")
+		for _, b := range v.Blocks {
+			if b.BlockID == fieldAnonymous && (b.Element.InitialOption == nil || b.Element.InitialOption.Value != value) {
+				t.Errorf("unexpected initial option for %s: %+v", value, b.Element.InitialOption)
+			}
+		}
+	}
+}
+
+func TestConfigureAuthorNotice(t *testing.T) {
+	rn, srv, _ := setup(t)
+	defer srv.Close()
+
+	// Teams that haven't chosen tell authors straight away.
+	for _, b := range configView(settings.Settings{}, "C1").Blocks {
+		if b.BlockID == fieldAuthorNotice && (b.Element.InitialOption == nil || b.Element.InitialOption.Value != settings.NotifyImmediately) {
+			t.Errorf("unexpected initial option: %+v", b.Element.InitialOption)
+		}
+	}
+
+	for _, value := range []string{settings.NotifyOnConfirmation, settings.NotifyNever, settings.NotifyImmediately} {
+		vs := submission("U1", nil)
+		vs.View.State.Values[fieldAuthorNotice] = map[string]slack.ViewStateValue{
+			fieldAuthorNotice: {Type: "static_select", SelectedOption: &messaging.Opt{Value: value}},
+		}
+		if err := rn.Configure(context.Background(), vs); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+
+		st, err := settings.Get(rn.data, "T1")
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if st.NotifyAuthor() != value {
+			t.Errorf("unexpected author notice for %s: %s", value, st.NotifyAuthor())
+		}
+
+		v := configView(st, "C1")
+		for _, b := range v.Blocks {
+			if b.BlockID == fieldAuthorNotice && (b.Element.InitialOption == nil || b.Element.InitialOption.Value != value) {
+				t.Errorf("unexpected initial option for %s: %+v", value, b.Element.InitialOption)
+			}
+		}
+	}
+}
+
+func TestConfigureLimits(t *testing.T) {
+	tcs := []struct {
+		value    string
+		want     int
+		shown    string
+		problems bool
+	}{
+		{value: "", want: 0, shown: ""},
+		{value: "5", want: 5, shown: "5"},
+		{value: "0", want: settings.NoLimit, shown: "0"},
+		{value: "lots", want: 3, shown: "3", problems: true},
+		{value: "-2", want: 3, shown: "3", problems: true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.value, func(t *testing.T) {
+			rn, srv, out := setup(t)
+			defer srv.Close()
+
+			if err := settings.Save(rn.data, settings.Settings{TeamID: "T1", ReporterLimit: 3, AuthorLimit: 3}); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			vs := submission("U1", map[string]string{fieldReporterLimit: tc.value, fieldAuthorLimit: tc.value})
+			if err := rn.Configure(context.Background(), vs); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			st, err := settings.Get(rn.data, "T1")
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if st.ReporterLimit != tc.want || st.AuthorLimit != tc.want {
+				t.Errorf("unexpected limits: %d, %d", st.ReporterLimit, st.AuthorLimit)
+			}
+
+			for _, b := range configView(st, "C1").Blocks {
+				if (b.BlockID == fieldReporterLimit || b.BlockID == fieldAuthorLimit) && b.Element.InitialValue != tc.shown {
+					t.Errorf("unexpected value shown for %s: %q", b.BlockID, b.Element.InitialValue)
+				}
+			}
+
+			if len(out.Envelopes) != 1 {
+				t.Fatal("unexpected number of replies:", len(out.Envelopes))
+			}
+			if problems := strings.Contains(out.Envelopes[0].Message.Text, "isn't a number"); problems != tc.problems {
+				t.Error("unexpected reply:", out.Envelopes[0].Message.Text)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+/*
+Package runner runs the subcommands of the /buddybot slash command and replies
+to the user who invoked them.
+*/
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/queue"
+	"github.com/billglover/bbot/pkg/ratelimit"
+	"github.com/billglover/bbot/pkg/report"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// usage is sent in response to unknown subcommands.
+const usage = "Usage:\n" +
+	"`/buddybot report <description>` report a concern to the admins\n" +
+	"`/buddybot coc` show a link to the Code of Conduct\n" +
+	"`/buddybot status` check that BuddyBot is set up for this workspace\n" +
+	"`/buddybot config` configure BuddyBot for this workspace (admins only)"
+
+// throttledReporter is sent to people who report a concern after flagging or
+// reporting too many things recently. Concerns count against the same limit
+// as flagged messages.
+const throttledReporter = "Thank you for looking out for everyone. You've reported a lot of things recently so I've paused reports from you for a little while. Please try again later, or contact one of the admins directly if something needs their attention now."
+
+// Runner handles slash commands. Replies are placed on the outbound queue
+// rather than being sent directly.
+type Runner struct {
+	out     queue.Queuer
+	teams   *slack.Teams
+	reports storage.Store
+	data    storage.Store
+}
+
+// New takes the outbound message queue, the teams that have installed
+// BuddyBot and the stores holding reports and team data such as settings and
+// returns a pointer to a Runner.
+func New(out queue.Queuer, teams *slack.Teams, reports, data storage.Store) *Runner {
+	return &Runner{out: out, teams: teams, reports: reports, data: data}
+}
+
+// Handle unmarshals slash commands taken off the command queue and passes
+// them to RunCommand. Submissions of the configuration modal share the queue
+// and are passed to Configure.
+//
+// If an error is returned the message remains on the queue for future
+// processing. For now, we don't return errors opting to log them instead.
+func (rn *Runner) Handle(ctx context.Context, evt queue.SQSEvent) error {
+	for _, msg := range evt.Records {
+		// Slash commands have no type so anything with one is a submission.
+		vs := slack.ViewSubmission{}
+		err := json.Unmarshal([]byte(msg.Body), &vs)
+		if err == nil && vs.Type == slack.InteractionViewSubmission {
+			if err := rn.Configure(ctx, vs); err != nil {
+				fmt.Println("ERROR: unable to configure team:", err)
+			}
+			continue
+		}
+
+		sc := slack.SlashCommand{}
+		err = json.Unmarshal([]byte(msg.Body), &sc)
+		if err != nil {
+			fmt.Println("ERROR: unable to parse slash command:", err)
+			continue
+		}
+
+		if err := rn.RunCommand(ctx, sc); err != nil {
+			fmt.Println("ERROR: unable to run slash command:", err)
+		}
+	}
+	return nil
+}
+
+// RunCommand takes a slash command and runs the requested subcommand. Each
+// subcommand replies to the user who invoked it.
+func (rn *Runner) RunCommand(ctx context.Context, sc slack.SlashCommand) error {
+	var msgs []messaging.Envelope
+	var err error
+
+	sub, txt := sc.Subcommand()
+	switch sub {
+	case "report":
+		msgs, err = rn.reportConcern(sc, txt)
+	case "coc":
+		msgs, err = rn.codeOfConduct(sc)
+	case "status":
+		msgs, err = rn.status(sc)
+	case "config":
+		msgs, err = rn.openConfig(sc)
+	default:
+		msgs = []messaging.Envelope{reply(sc, usage)}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to run %s %s", sc.Command, sub)
+	}
+
+	for _, msg := range msgs {
+		h := queue.Headers{"Team": msg.Destination.TeamID}
+		if err := rn.out.Queue(ctx, h, msg); err != nil {
+			return errors.Wrap(err, "unable to queue reply")
+		}
+	}
+	return nil
+}
+
+// reportConcern records a report describing a concern raised by the user
+// and notifies the admins channel. The user is thanked for the report.
+func (rn *Runner) reportConcern(sc slack.SlashCommand, description string) ([]messaging.Envelope, error) {
+	if description == "" {
+		return []messaging.Envelope{reply(sc, "Please describe your concern, e.g. `"+sc.Command+" report <description>`")}, nil
+	}
+
+	ws, ar, err := rn.teams.Workspace(sc.TeamID)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := settings.Load(rn.data, ar)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch team settings")
+	}
+
+	// People reporting a lot of concerns are asked to wait so that the admins
+	// channel isn't flooded. If we can't check the limit we allow the report
+	// rather than risk losing a genuine one.
+	limit := ratelimit.Limit{Count: st.FlagsPerReporter(), Period: settings.LimitPeriod}
+	now := time.Now().UTC()
+	ok, err := ratelimit.Allow(rn.data, sc.TeamID, "reporter:"+sc.UserID, limit, now)
+	if err != nil {
+		fmt.Println("ERROR: unable to check report limit:", err)
+	}
+	if err == nil && ok == false {
+		fmt.Printf("INFO: report by %s throttled for team %s\n", sc.UserID, sc.TeamID)
+		return []messaging.Envelope{reply(sc, throttledReporter)}, nil
+	}
+
+	adminChan, err := settings.AdminChannelID(rn.data, st, ws)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to locate admins channel")
+	}
+
+	// Reporters are hidden from the admins if the team hides everyone who
+	// reports a concern, as it does for flagged messages.
+	r := report.FromCommand(sc, description, st.Anonymous, now)
+	if err := report.Save(rn.reports, r); err != nil {
+		fmt.Println("ERROR: unable to save report:", err)
+	}
+
+	admins := messaging.Envelope{
+		Destination: messaging.Address{
+			TeamID:    ar.TeamID,
+			ChannelID: adminChan,
+		},
+		Message:  report.AdminMessage(r, ""),
+		Delivery: messaging.DeliveryChannel,
+	}
+
+	txt := "Thank you for raising your concern. We've notified the admins who will have a look at the report. One of them may be in touch to understand more about the report."
+	if r.Anonymous() {
+		txt = "Thank you for raising your concern. We've notified the admins who will have a look at the report. Your name hasn't been shared with them."
+	}
+	thanks := reply(sc, txt)
+	return []messaging.Envelope{admins, thanks}, nil
+}
+
+// codeOfConduct replies with a link to the Code of Conduct for the team.
+func (rn *Runner) codeOfConduct(sc slack.SlashCommand) ([]messaging.Envelope, error) {
+	_, ar, err := rn.teams.Workspace(sc.TeamID)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := settings.Load(rn.data, ar)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch team settings")
+	}
+
+	if st.CoCURL == "" {
+		return []messaging.Envelope{reply(sc, "No link to the Code of Conduct has been set up for this workspace. Please ask one of the admins.")}, nil
+	}
+	return []messaging.Envelope{reply(sc, "You can read the Code of Conduct here: "+st.CoCURL)}, nil
+}
+
+// status replies with a summary of how BuddyBot is set up for the team.
+// Workspace admins are also told how many reports are still to be closed.
+func (rn *Runner) status(sc slack.SlashCommand) ([]messaging.Envelope, error) {
+	ws, ar, err := rn.teams.Workspace(sc.TeamID)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := settings.Load(rn.data, ar)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch team settings")
+	}
+
+	txt := fmt.Sprintf("BuddyBot is installed in %s.", ar.TeamName)
+	adminChan, err := settings.AdminChannelID(rn.data, st, ws)
+	if err != nil {
+		txt += " I'm unable to find the admins channel so flagged messages can't be reported. Please ask an admin to invite me to it."
+	} else {
+		txt += fmt.Sprintf(" Flagged messages are reported in <#%s>.", adminChan)
+	}
+
+	// Only workspace admins are told how many reports there are.
+	admin, err := ws.IsAdmin(sc.UserID)
+	if err != nil {
+		fmt.Println("ERROR: unable to check user is an admin:", err)
+	}
+	if admin == false {
+		return []messaging.Envelope{reply(sc, txt)}, nil
+	}
+
+	rs, err := report.List(rn.reports, sc.TeamID)
+	if err != nil {
+		fmt.Println("ERROR: unable to list reports:", err)
+		return []messaging.Envelope{reply(sc, txt)}, nil
+	}
+
+	open := 0
+	for _, r := range rs {
+		if r.Status.Closed() == false {
+			open++
+		}
+	}
+	txt += fmt.Sprintf(" There are %d reports still to be closed.", open)
+	return []messaging.Envelope{reply(sc, txt)}, nil
+}
+
+// reply constructs an ephemeral message to the user who invoked the slash
+// command, shown in the channel it was invoked from.
+func reply(sc slack.SlashCommand, txt string) messaging.Envelope {
+	e := messaging.Envelope{
+		Destination: messaging.Address{
+			TeamID:    sc.TeamID,
+			ChannelID: sc.ChannelID,
+			UserID:    sc.UserID,
+		},
+		Message:  messaging.Message{Text: txt},
+		Delivery: messaging.DeliveryEphemeral,
+	}
+	return e
+}
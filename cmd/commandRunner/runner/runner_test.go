@@ -0,0 +1,216 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/report"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+)
+
+// command returns the slash command for the subcommand provided, invoked by
+// the user in channel C1 of team T1.
+func command(user, text string) slack.SlashCommand {
+	return slack.SlashCommand{
+		Command:   "/buddybot",
+		Text:      text,
+		TeamID:    "T1",
+		ChannelID: "C1",
+		UserID:    user,
+		UserName:  "member",
+		TriggerID: "trigger",
+	}
+}
+
+func TestReportConcern(t *testing.T) {
+	tcs := []struct {
+		name      string
+		anonymous bool
+	}{
+		{name: "named"},
+		{name: "anonymous", anonymous: true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rn, srv, out := setup(t)
+			defer srv.Close()
+
+			st := settings.Settings{TeamID: "T1", AdminChannel: "G0ADMINS", Anonymous: tc.anonymous}
+			if err := settings.Save(rn.data, st); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			if err := rn.RunCommand(context.Background(), command("U2", "report someone was rude")); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			rs, err := report.List(rn.reports, "T1")
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if len(rs) != 1 || rs[0].MessageText != "someone was rude" || rs[0].ReporterID != "U2" || rs[0].Anonymous() != tc.anonymous {
+				t.Fatalf("unexpected reports: %+v", rs)
+			}
+
+			if len(out.Envelopes) != 2 {
+				t.Fatal("unexpected number of messages queued:", len(out.Envelopes))
+			}
+
+			// The reporter is hidden from the admins if the team hides
+			// everyone who reports a concern, and told their name was withheld.
+			want := "member"
+			if tc.anonymous {
+				want = report.AnonymousReporter
+			}
+			admins := out.Envelopes[0]
+			if admins.Destination.ChannelID != "G0ADMINS" {
+				t.Error("admins not notified:", admins.Destination)
+			}
+			for _, f := range admins.Message.Attachments[0].Fields {
+				if f.Name == "reporter" && f.Value != want {
+					t.Errorf("unexpected reporter shown to admins: %s", f.Value)
+				}
+			}
+
+			thanks := out.Envelopes[1].Message.Text
+			if withheld := strings.Contains(thanks, "hasn't been shared"); withheld != tc.anonymous {
+				t.Errorf("unexpected reply: %s", thanks)
+			}
+		})
+	}
+}
+
+func TestReportConcernThrottled(t *testing.T) {
+	rn, srv, out := setup(t)
+	defer srv.Close()
+
+	if err := settings.Save(rn.data, settings.Settings{TeamID: "T1", AdminChannel: "G0ADMINS", ReporterLimit: 1}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// Concerns count against the same limit as flagged messages.
+	for i, want := range []int{2, 1} {
+		out.Reset()
+		if err := rn.RunCommand(context.Background(), command("U2", "report someone was rude")); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if len(out.Envelopes) != want {
+			t.Fatalf("report %d: unexpected number of messages queued: %d", i, len(out.Envelopes))
+		}
+	}
+	if out.Envelopes[0].Message.Text != throttledReporter {
+		t.Error("unexpected reply:", out.Envelopes[0].Message.Text)
+	}
+
+	rs, err := report.List(rn.reports, "T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(rs) != 1 {
+		t.Error("unexpected number of reports:", len(rs))
+	}
+}
+
+func TestCodeOfConduct(t *testing.T) {
+	tcs := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{name: "link", url: "https://example.com/coc", want: "You can read the Code of Conduct here: https://example.com/coc"},
+		{name: "no link", want: "No link to the Code of Conduct has been set up for this workspace. Please ask one of the admins."},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rn, srv, out := setup(t)
+			defer srv.Close()
+
+			if err := settings.Save(rn.data, settings.Settings{TeamID: "T1", CoCURL: tc.url}); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			if err := rn.RunCommand(context.Background(), command("U2", "coc")); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if len(out.Envelopes) != 1 {
+				t.Fatal("unexpected number of messages queued:", len(out.Envelopes))
+			}
+			e := out.Envelopes[0]
+			if e.Mode() != messaging.DeliveryEphemeral || e.Destination.UserID != "U2" || e.Destination.ChannelID != "C1" {
+				t.Errorf("not a reply to the user: %+v", e.Destination)
+			}
+			if e.Message.Text != tc.want {
+				t.Error("unexpected reply:", e.Message.Text)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tcs := []struct {
+		name    string
+		user    string
+		channel string
+		want    string
+		counted bool
+	}{
+		{name: "admins channel", user: "U1", channel: "admins", want: "Flagged messages are reported in <#G0ADMINS>.", counted: true},
+		{name: "no admins channel", user: "U1", channel: "nowhere", want: "I'm unable to find the admins channel", counted: true},
+		{name: "member", user: "U2", channel: "admins", want: "Flagged messages are reported in <#G0ADMINS>."},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rn, srv, out := setup(t)
+			defer srv.Close()
+
+			if err := settings.Save(rn.data, settings.Settings{TeamID: "T1", AdminChannel: tc.channel}); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			// Only reports that are still to be closed are counted, and only
+			// admins are told how many there are.
+			for i, s := range []report.Status{report.StatusOpen, report.StatusAcknowledged, report.StatusDismissed, report.StatusResolved} {
+				r := report.Report{UID: report.ID("T1", "C1", "100.0", fmt.Sprintf("20%d.0", i)), TeamID: "T1", Status: s}
+				if err := report.Save(rn.reports, r); err != nil {
+					t.Fatal("unexpected error:", err)
+				}
+			}
+
+			if err := rn.RunCommand(context.Background(), command(tc.user, "status")); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if len(out.Envelopes) != 1 {
+				t.Fatal("unexpected number of messages queued:", len(out.Envelopes))
+			}
+			txt := out.Envelopes[0].Message.Text
+			if strings.Contains(txt, tc.want) == false {
+				t.Error("unexpected reply:", txt)
+			}
+			if counted := strings.Contains(txt, "There are 2 reports still to be closed."); counted != tc.counted {
+				t.Error("unexpected reply:", txt)
+			}
+		})
+	}
+}
+
+func TestUnknownSubcommand(t *testing.T) {
+	rn, srv, out := setup(t)
+	defer srv.Close()
+
+	for _, txt := range []string{"", "help"} {
+		out.Reset()
+		if err := rn.RunCommand(context.Background(), command("U2", txt)); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if len(out.Envelopes) != 1 || out.Envelopes[0].Message.Text != usage {
+			t.Errorf("unexpected reply to %q: %+v", txt, out.Envelopes)
+		}
+	}
+}
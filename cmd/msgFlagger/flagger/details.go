@@ -0,0 +1,185 @@
+package flagger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/queue"
+	"github.com/billglover/bbot/pkg/report"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/pkg/errors"
+)
+
+// Block IDs of the fields in the details modal.
+const (
+	fieldCategory = "category"
+	fieldSeverity = "severity"
+	fieldNote     = "note"
+)
+
+// Shown in the details modal in place of the details when they can't be
+// added to the flag.
+const (
+	notFlagged = "Sorry, I'm unable to flag the message right now. Please try again later, or contact one of the admins directly."
+	noDetails  = "The admins have been told about the message but I'm unable to take any more details right now."
+)
+
+// detailsMetadata is kept in the details modal so that we know which message
+// the details are about once it is submitted.
+type detailsMetadata struct {
+	ChannelID string `json:"channel"`
+	MessageTs string `json:"ts"`
+}
+
+// openDetails opens the modal allowing the reporter to add details to their
+// flag and returns its ID, which is empty if there is no trigger. Slack only
+// accepts the trigger for a few seconds after the message was flagged so the
+// modal is opened before anything else is done. It only shows that the
+// message is being flagged until the flag has been recorded.
+func (f *Flagger) openDetails(ws *slack.Workspace, m slack.MessageAction) (string, error) {
+	if m.TriggerID == "" {
+		return "", nil
+	}
+	return ws.OpenView(m.TriggerID, noticeView("Flagging the message…"))
+}
+
+// showDetails asks the reporter for details of their flag in the modal opened
+// by openDetails, once the flag has been recorded.
+func showDetails(ws *slack.Workspace, view string, m slack.MessageAction) {
+	md, err := json.Marshal(detailsMetadata{ChannelID: m.Channel.ID, MessageTs: string(m.MessageTs)})
+	if err != nil {
+		fmt.Println("ERROR: unable to encode details metadata:", err)
+		updateDetails(ws, view, noticeView(noDetails))
+		return
+	}
+	updateDetails(ws, view, detailsView(string(md)))
+}
+
+// updateDetails replaces the modal opened by openDetails with the view
+// provided. The flag stands without the details so failures are only logged.
+func updateDetails(ws *slack.Workspace, view string, v slack.View) {
+	if view == "" {
+		return
+	}
+	if err := ws.UpdateView(view, v); err != nil {
+		fmt.Println("ERROR: unable to update details modal:", err)
+	}
+}
+
+// noticeView returns a details modal showing only the text provided. It is
+// shown while the message is flagged, or in place of the details if they
+// can't be added.
+func noticeView(txt string) slack.View {
+	return slack.View{
+		Type:   "modal",
+		Title:  messaging.PlainText("Flag message"),
+		Close:  messaging.PlainText("Close"),
+		Blocks: []messaging.Block{messaging.Section(txt)},
+	}
+}
+
+// detailsView takes the metadata identifying the flagged message and returns
+// the modal allowing a reporter to add details to their flag.
+func detailsView(md string) slack.View {
+	v := slack.View{
+		Type:            "modal",
+		CallbackID:      report.DetailsCallbackID,
+		Title:           messaging.PlainText("Message flagged"),
+		Submit:          messaging.PlainText("Add details"),
+		Close:           messaging.PlainText("Skip"),
+		PrivateMetadata: md,
+	}
+
+	v.Blocks = append(v.Blocks, messaging.SelectInput(fieldCategory, "What is the problem?", options(report.Categories), ""))
+	v.Blocks = append(v.Blocks, messaging.SelectInput(fieldSeverity, "How serious is it?", options(report.Severities), ""))
+
+	note := messaging.TextInput(fieldNote, "Anything else the admins should know?", "", true)
+	note.Hint = messaging.PlainText("The message has already been flagged. These details help the admins decide what to do.")
+	v.Blocks = append(v.Blocks, note)
+	return v
+}
+
+// options returns select menu options for each of the values provided.
+func options(values []string) []messaging.Opt {
+	os := make([]messaging.Opt, len(values))
+	for i, v := range values {
+		os[i] = messaging.Opt{Text: messaging.PlainText(v), Value: v}
+	}
+	return os
+}
+
+// AddDetails takes a submission of the details modal and adds the details to
+// the flag made by the user who submitted it. The admin message for the
+// report is updated to show the details and a note added in its thread.
+func (f *Flagger) AddDetails(ctx context.Context, vs slack.ViewSubmission) error {
+	if vs.View.CallbackID != report.DetailsCallbackID {
+		return errors.Errorf("view submission not supported: %s", vs.View.CallbackID)
+	}
+
+	md := detailsMetadata{}
+	if err := json.Unmarshal([]byte(vs.View.PrivateMetadata), &md); err != nil {
+		return errors.Wrap(err, "unable to decode details metadata")
+	}
+
+	// The details belong to the flag the reporter made, which was added to
+	// the open report of the message.
+	r, ok, err := report.FindOpen(f.reports, vs.Team.ID, md.ChannelID, md.MessageTs)
+	if err != nil {
+		return err
+	}
+	if ok == false {
+		return errors.Errorf("message %s has no open report", md.MessageTs)
+	}
+
+	category := vs.Value(fieldCategory)
+	severity := vs.Value(fieldSeverity)
+	note := strings.TrimSpace(vs.Value(fieldNote))
+	now := time.Now().UTC()
+	r, err = report.Update(f.reports, r.UID, func(r *report.Report) error {
+		return r.AddDetails(vs.User.ID, category, severity, note, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	_, ar, err := f.teams.Workspace(r.TeamID)
+	if err != nil {
+		return err
+	}
+
+	st, err := settings.Load(f.data, ar)
+	if err != nil {
+		return errors.Wrap(err, "unable to fetch team settings")
+	}
+
+	ch, err := f.adminChannel(r.TeamID, st)
+	if err != nil {
+		return err
+	}
+
+	update := f.msgForAdmins(ctx, r, ch, report.TemplateData(r, ar.TeamName, st.CoCURL))
+	update.Operation = messaging.OperationUpdate
+
+	who := fmt.Sprintf("<@%s>", vs.User.ID)
+	for _, fl := range r.Flags {
+		if fl.ReporterID == vs.User.ID && fl.Anonymous {
+			who = "An anonymous reporter"
+		}
+	}
+	reply := report.ThreadReply(r, ch, who+" added details to their flag.")
+
+	for _, msg := range []messaging.Envelope{update, reply} {
+		h := queue.Headers{"Team": msg.Destination.TeamID}
+		if err := f.out.Queue(ctx, h, msg); err != nil {
+			return errors.Wrap(err, "unable to notify admins")
+		}
+	}
+
+	fmt.Printf("INFO: details added to report %s\n", r.UID)
+	return nil
+}
@@ -0,0 +1,215 @@
+package flagger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/report"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+)
+
+// details returns a submission of the details modal opened when the message
+// was flagged, made by the user provided.
+func details(m slack.MessageAction, user, category, severity, note string) slack.ViewSubmission {
+	state := &slack.ViewState{Values: map[string]map[string]slack.ViewStateValue{
+		fieldCategory: {fieldCategory: {Type: "static_select", SelectedOption: &messaging.Opt{Value: category}}},
+		fieldSeverity: {fieldSeverity: {Type: "static_select", SelectedOption: &messaging.Opt{Value: severity}}},
+		fieldNote:     {fieldNote: {Type: "plain_text_input", Value: note}},
+	}}
+
+	return slack.ViewSubmission{
+		Type: slack.InteractionViewSubmission,
+		Team: slack.Team{ID: "T1"},
+		User: slack.User{ID: user},
+		View: slack.View{
+			Type:            "modal",
+			CallbackID:      report.DetailsCallbackID,
+			PrivateMetadata: fmt.Sprintf(`{"channel":%q,"ts":%q}`, m.Channel.ID, m.MessageTs),
+			State:           state,
+		},
+	}
+}
+
+func TestFlagMessageOpensDetails(t *testing.T) {
+	tcs := []struct {
+		name    string
+		trigger string
+		opens   int
+	}{
+		{name: "trigger", trigger: "1234.5678", opens: 1},
+		{name: "no trigger", opens: 0},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			f, srv, out, _ := setup(t)
+			defer srv.Close()
+
+			m := action("T1")
+			m.TriggerID = tc.trigger
+			if err := f.FlagMessage(context.Background(), m); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			calls := srv.Calls("views.open")
+			if len(calls) != tc.opens {
+				t.Fatal("unexpected number of calls to views.open:", len(calls))
+			}
+			if tc.opens > 0 {
+				// The modal only asks for details once the flag is recorded.
+				updates := srv.Calls("views.update")
+				if len(updates) != 1 {
+					t.Fatal("unexpected number of calls to views.update:", len(updates))
+				}
+				if strings.Contains(string(updates[0].Body), report.DetailsCallbackID) == false || strings.Contains(string(updates[0].Body), string(m.MessageTs)) == false {
+					t.Errorf("unexpected view shown: %s", updates[0].Body)
+				}
+
+				// The trigger expires quickly so the modal is opened before
+				// anything else is asked of Slack.
+				if all := srv.Calls(""); all[0].Method != "views.open" {
+					t.Error("modal opened after calling", all[0].Method)
+				}
+			}
+
+			// The flag doesn't wait for the details.
+			if len(out.Envelopes) != 3 {
+				t.Error("unexpected number of messages queued:", len(out.Envelopes))
+			}
+		})
+	}
+}
+
+func TestFlagMessageDetailsFailure(t *testing.T) {
+	f, srv, out, _ := setup(t)
+	defer srv.Close()
+	srv.SetError("views.open", "expired_trigger_id")
+
+	m := action("T1")
+	m.TriggerID = "1234.5678"
+	if err := f.FlagMessage(context.Background(), m); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(out.Envelopes) != 3 {
+		t.Error("unexpected number of messages queued:", len(out.Envelopes))
+	}
+}
+
+func TestFlagMessageDetailsRejected(t *testing.T) {
+	f, srv, out, _ := setup(t)
+	defer srv.Close()
+
+	if err := settings.Save(f.data, settings.Settings{TeamID: "T1", ReporterLimit: 1}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		m := action("T1")
+		m.TriggerID = "1234.5678"
+		m.MessageTs = json.Number(fmt.Sprintf("15000000%02d.000001", i))
+		if err := f.FlagMessage(context.Background(), m); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+
+	// The throttled reporter is shown why their flag wasn't recorded rather
+	// than being asked for details they can't add.
+	updates := srv.Calls("views.update")
+	if len(updates) != 2 {
+		t.Fatal("unexpected number of calls to views.update:", len(updates))
+	}
+	body := string(updates[1].Body)
+	if strings.Contains(body, report.DetailsCallbackID) || strings.Contains(body, "already been flagged") {
+		t.Errorf("reporter asked for details: %s", body)
+	}
+	if strings.Contains(body, "paused flagging for you") == false {
+		t.Errorf("reporter not told they are throttled: %s", body)
+	}
+	if last := out.Envelopes[len(out.Envelopes)-1]; last.Message.Text != throttledReporter {
+		t.Error("unexpected reply:", last.Message.Text)
+	}
+}
+
+func TestAddDetails(t *testing.T) {
+	f, srv, out, reports := setup(t)
+	defer srv.Close()
+
+	m := action("T1")
+	if err := f.FlagMessage(context.Background(), m); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	out.Reset()
+
+	id := report.ID("T1", "C1", string(m.MessageTs), string(m.ActionTs))
+	vs := details(m, "U1", "Harassment", "High", " Not the first time. ")
+	if err := f.AddDetails(context.Background(), vs); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	r, err := report.Get(reports, id)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	fl := r.Flags[0]
+	if fl.Category != "Harassment" || fl.Severity != "High" || fl.Note != "Not the first time." {
+		t.Errorf("unexpected details: %+v", fl)
+	}
+
+	if len(out.Envelopes) != 2 {
+		t.Fatal("unexpected number of messages queued:", len(out.Envelopes))
+	}
+
+	update := out.Envelopes[0]
+	if update.Operation != messaging.OperationUpdate || update.Key != report.AdminMessageKey(id) {
+		t.Errorf("unexpected update: %s, %s", update.Operation, update.Key)
+	}
+	shown := map[string]string{}
+	for _, field := range update.Message.Attachments[0].Fields {
+		shown[field.Name] = field.Value
+	}
+	if shown["category"] != "Harassment" || shown["severity"] != "High" || strings.Contains(shown["notes"], "Not the first time.") == false {
+		t.Errorf("details not shown to admins: %v", shown)
+	}
+
+	reply := out.Envelopes[1]
+	if reply.Mode() != messaging.DeliveryThread || strings.Contains(reply.Message.Text, "<@U1>") == false {
+		t.Errorf("unexpected reply: %s, %s", reply.Mode(), reply.Message.Text)
+	}
+}
+
+func TestAddDetailsErrors(t *testing.T) {
+	f, srv, out, _ := setup(t)
+	defer srv.Close()
+
+	m := action("T1")
+	if err := f.FlagMessage(context.Background(), m); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	out.Reset()
+	other := m
+	other.MessageTs = "1500000000.000009"
+
+	tcs := []struct {
+		name string
+		vs   slack.ViewSubmission
+	}{
+		{name: "unknown report", vs: details(other, "U1", "Spam", "Low", "")},
+		{name: "not a reporter", vs: details(m, "U3", "Spam", "Low", "")},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := f.AddDetails(context.Background(), tc.vs); err == nil {
+				t.Error("expected an error")
+			}
+			if len(out.Envelopes) != 0 {
+				t.Error("unexpected messages queued:", out.Envelopes)
+			}
+		})
+	}
+}
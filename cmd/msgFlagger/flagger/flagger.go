@@ -0,0 +1,432 @@
+/*
+Package flagger records messages that have been flagged for a potential Code
+of Conduct violation and notifies the reporter, the author and the admins.
+*/
+package flagger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/queue"
+	"github.com/billglover/bbot/pkg/report"
+	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/billglover/bbot/pkg/templates"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Flagger handles flagged messages. Messages for Slack are placed on the
+// outbound queue rather than being sent directly.
+type Flagger struct {
+	out     queue.Queuer
+	teams   *slack.Teams
+	reports storage.Store
+	data    storage.Store
+	tmpl    *templates.Set
+}
+
+// New takes the outbound message queue, the teams that have installed
+// BuddyBot and the stores holding reports and team data such as settings and
+// returns a pointer to a Flagger. It returns an error if any of the options
+// provided can't be applied.
+func New(out queue.Queuer, teams *slack.Teams, reports, data storage.Store, options ...func(*Flagger) error) (*Flagger, error) {
+	f := &Flagger{out: out, teams: teams, reports: reports, data: data, tmpl: &templates.Set{}}
+	for _, option := range options {
+		if err := option(f); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Templates sets the templates used to render notifications. Without them
+// notifications use built in wording.
+func Templates(t *templates.Set) func(*Flagger) error {
+	return func(f *Flagger) error {
+		if t == nil {
+			return errors.New("templates must be provided")
+		}
+		f.tmpl = t
+		return nil
+	}
+}
+
+// Handle unmarshals message actions taken off the flagMessage queue and
+// passes them to FlagMessage. Submissions of the details modal are passed to
+// AddDetails.
+//
+// If an error is returned the message remains on the queue for future
+// processing. Without additional error handling configuration on the
+// queues this can lead to infinite loops. For now, we don't return errors
+// opting to log them instead.
+func (f *Flagger) Handle(ctx context.Context, evt queue.SQSEvent) error {
+	for _, msg := range evt.Records {
+		vs := slack.ViewSubmission{}
+		err := json.Unmarshal([]byte(msg.Body), &vs)
+		if err == nil && vs.Type == slack.InteractionViewSubmission {
+			if err := f.AddDetails(ctx, vs); err != nil {
+				fmt.Println("ERROR: unable to add details to flag:", err)
+			}
+			continue
+		}
+
+		m := slack.MessageAction{}
+		err = json.Unmarshal([]byte(msg.Body), &m)
+		if err != nil {
+			fmt.Println("ERROR: unable to parse message action:", err)
+			continue
+		}
+
+		if err := f.FlagMessage(ctx, m); err != nil {
+			fmt.Println("ERROR: unable to flag message:", err)
+		}
+	}
+	return nil
+}
+
+// FlagMessage takes a message action and flags the associated message for a
+// potential Code of conduct violation. It records a report and notifies the
+// reporter, author of the original message and the admins channel. The author
+// is notified according to the team's policy, which may defer the
+// notification until an admin confirms the report. If the message has an
+// open report already the flag is added to that report, the admins are
+// notified in its thread and the author isn't notified again.
+//
+// Each of these actions are triggered independently. However, if one or more
+// actions generates an error, an error is returned to the caller.
+func (f *Flagger) FlagMessage(ctx context.Context, m slack.MessageAction) error {
+	spanCtx, span := trace.StartSpan(ctx, "msgFlagger/flagMessage")
+	defer span.End()
+
+	// If the team has removed BuddyBot since the message was flagged there is
+	// nobody we can notify so we drop the flag.
+	ws, ar, err := f.teams.Workspace(m.Team.ID)
+	if errors.Cause(err) == secrets.ErrUnknownTeam {
+		fmt.Println("INFO: dropping flag for team without BuddyBot installed:", m.Team.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Open the modal asking the reporter for more details while the trigger
+	// is still valid. It only asks for them once the flag has been recorded.
+	// The flag stands without them so we carry on if the modal can't be
+	// opened.
+	view, err := f.openDetails(ws, m)
+	if err != nil {
+		fmt.Println("ERROR: unable to ask reporter for details:", err)
+	}
+
+	// Notifications use the team's wording and link to its Code of Conduct.
+	st, err := settings.Load(f.data, ar)
+	if err != nil {
+		updateDetails(ws, view, noticeView(notFlagged))
+		return errors.Wrap(err, "unable to fetch team settings")
+	}
+
+	// People flagging a lot of messages, or a lot of messages posted by the
+	// same person, are asked to wait so that the admins channel isn't flooded.
+	if txt := f.throttled(m, st); txt != "" {
+		updateDetails(ws, view, noticeView(txt))
+		msg := msgForThrottled(m, txt)
+		h := queue.Headers{"Team": msg.Destination.TeamID}
+		if err := f.out.Queue(spanCtx, h, msg); err != nil {
+			return errors.Wrap(err, "unable to notify throttled reporter")
+		}
+		return nil
+	}
+
+	// Reporters are hidden from the admins if they chose to flag the message
+	// anonymously, or if the team hides everyone who flags a message.
+	anonymous := m.CallbackID == report.FlagAnonymouslyCallbackID || st.Anonymous
+
+	// If the message has already been flagged and the admins are still
+	// dealing with it, the flag is added to the earlier report rather than
+	// reported again, even if it was flagged at the same time.
+	//
+	// Tell the author one of their messages has been flagged, unless the team
+	// waits for an admin to confirm the report first, in which case the
+	// message is kept with the report, or never tells them. They were told
+	// when it was first flagged so aren't told again.
+	//
+	// Record the report before notifying anyone. If we can't store it we
+	// still notify everyone as the admins can follow up by hand.
+	now := time.Now().UTC()
+	policy := st.NotifyAuthor()
+	var author []messaging.Envelope
+	bCtx, bSpan := trace.StartSpan(spanCtx, "msgFlagger/b")
+	create := func() report.Report {
+		r := f.newReport(spanCtx, m, anonymous)
+		if policy != settings.NotifyNever {
+			msg := f.msgForAuthor(bCtx, m, st, report.TemplateData(r, ar.TeamName, st.CoCURL))
+			if policy == settings.NotifyOnConfirmation {
+				r.AuthorNotice = &msg
+			} else {
+				author = append(author, msg)
+			}
+		}
+		return r
+	}
+	add := func(r *report.Report) {
+		r.AddFlag(m, anonymous, now)
+	}
+	r, flagged, err := report.File(f.reports, m.Team.ID, m.Channel.ID, string(m.MessageTs), create, add)
+	if err != nil {
+		fmt.Println("ERROR: unable to save report:", err)
+		updateDetails(ws, view, noticeView(noDetails))
+	} else {
+		showDetails(ws, view, m)
+	}
+	if flagged {
+		author = nil
+	}
+	bSpan.End()
+
+	d := report.TemplateData(r, ar.TeamName, st.CoCURL)
+
+	// Send a message to the reporter to let them know their request has
+	// been received. Only they see it so it can name them even if they
+	// flagged the message anonymously, in which case it tells them the admins
+	// haven't been given their name. Don't immediately return on error.
+	aCtx, aSpan := trace.StartSpan(spanCtx, "msgFlagger/a")
+	dr := d
+	dr.Reporter, dr.ReporterID, dr.Anonymous = m.User.Name, m.User.ID, anonymous
+	msg := f.msgForReporter(aCtx, m, st, dr)
+	h := queue.Headers{"Team": msg.Destination.TeamID}
+	errReporter := f.out.Queue(aCtx, h, msg)
+	if errReporter != nil {
+		fmt.Println("ERROR: unable to notify reporting user:", errReporter)
+	}
+	aSpan.End()
+
+	// Send the message to the author, if there is one to send now. Don't
+	// immediately return on error.
+	for _, msg := range author {
+		h = queue.Headers{"Team": msg.Destination.TeamID}
+		if err := f.out.Queue(spanCtx, h, msg); err != nil {
+			fmt.Println("ERROR: unable to notify author:", err)
+		}
+	}
+
+	// Query slack to find the admins channel so that we can notify the admins
+	// that a message has been flagged.
+	cCtx, cSpan := trace.StartSpan(spanCtx, "msgFlagger/c")
+	defer cSpan.End()
+	adminChan, errAdmin := f.adminChannel(m.Team.ID, st)
+	if errAdmin != nil {
+		fmt.Println("ERROR: unable to notify admins:", errAdmin)
+		return errors.New("there were issues notifying all parties")
+	}
+
+	// A message flagged again updates the existing admin message to show
+	// everyone who has flagged it, and the new flag is noted in its thread.
+	msgs := []messaging.Envelope{f.msgForAdmins(cCtx, r, adminChan, d)}
+	if flagged {
+		msgs[0].Operation = messaging.OperationUpdate
+		txt := fmt.Sprintf("<@%s> has also flagged this message.", m.User.ID)
+		if anonymous {
+			txt = "This message has been flagged again anonymously."
+		}
+		msgs = append(msgs, report.ThreadReply(r, adminChan, txt))
+	}
+	for _, msg := range msgs {
+		h = queue.Headers{"Team": msg.Destination.TeamID}
+		if err := f.out.Queue(cCtx, h, msg); err != nil {
+			fmt.Println("ERROR: unable to notify admins:", err)
+			errAdmin = err
+		}
+	}
+	if errAdmin != nil {
+		return errors.New("there were issues notifying all parties")
+	}
+
+	return nil
+}
+
+// msgForReporter takes a message action, the team settings and the data for
+// the templates and constructs a message that will be sent to the user who
+// reported the message, in their own language if we have a template for it.
+func (f *Flagger) msgForReporter(ctx context.Context, m slack.MessageAction, st settings.Settings, d templates.Data) messaging.Envelope {
+	_, span := trace.StartSpan(ctx, "msgFlagger/msgForReporter")
+	defer span.End()
+
+	override, _ := st.Message(settings.MessageReporter)
+	txt, err := f.tmpl.Render(templates.Reporter, f.userLocale(m.Team.ID, m.User.ID), override, d)
+	if err != nil {
+		fmt.Println("ERROR: unable to render message for reporter:", err)
+		txt = "Thank you for flagging the potential Code of Conduct violation. We will investigate."
+		if d.Anonymous {
+			txt += " Your name hasn't been shared with the admins."
+		}
+		if st.CoCURL != "" {
+			txt += " You can find the Code of Conduct here: " + st.CoCURL
+		}
+	}
+
+	e := messaging.Envelope{
+		Destination: messaging.Address{
+			TeamID:    m.Team.ID,
+			ChannelID: m.Channel.ID,
+			UserID:    m.User.ID,
+		},
+		Message:  messaging.Message{Text: txt},
+		Delivery: messaging.DeliveryEphemeral,
+	}
+	return e
+}
+
+// msgForAuthor takes a message action, the team settings and the data for the
+// templates and constructs a direct message that will be sent to the user who
+// originally authored the message, in their own language if we have a
+// template for it.
+func (f *Flagger) msgForAuthor(ctx context.Context, m slack.MessageAction, st settings.Settings, d templates.Data) messaging.Envelope {
+	_, span := trace.StartSpan(ctx, "msgFlagger/msgForAuthor")
+	defer span.End()
+
+	// Authors are never told who flagged their message, even by wording the
+	// team has chosen.
+	d.Reporter, d.ReporterID, d.Anonymous = "", "", false
+
+	override, _ := st.Message(settings.MessageAuthor)
+	txt, err := f.tmpl.Render(templates.Author, f.userLocale(m.Team.ID, m.Message.UserID), override, d)
+	if err != nil {
+		fmt.Println("ERROR: unable to render message for author:", err)
+		txt = "One of your recent messages has been flagged as it may not comply with the Code of Conduct. One of our admins will investigate the context, but consider an empathetic review of your recent messages in the meantime."
+		if st.CoCURL != "" {
+			txt += " You can find the Code of Conduct here: " + st.CoCURL
+		}
+	}
+
+	// The author is sent a direct message rather than an ephemeral one so that
+	// it is still there for them to read after Slack is reloaded.
+	e := messaging.Envelope{
+		Destination: messaging.Address{
+			TeamID: m.Team.ID,
+			UserID: m.Message.UserID,
+		},
+		Message:  messaging.Message{Text: txt},
+		Delivery: messaging.DeliveryDirect,
+	}
+	return e
+}
+
+// newReport takes a message action and constructs the report that is stored
+// for it, hiding the reporter from admins if anonymous is true. It looks up
+// the author name and permalink as these are not part of the message action.
+func (f *Flagger) newReport(ctx context.Context, m slack.MessageAction, anonymous bool) report.Report {
+	_, span := trace.StartSpan(ctx, "msgFlagger/newReport")
+	defer span.End()
+
+	r := report.New(m, anonymous, time.Now().UTC())
+
+	author, err := f.userName(m.Team.ID, m.Message.UserID)
+	if err != nil {
+		fmt.Println("ERROR: unable to get author name")
+		author = "unknown"
+	}
+	r.AuthorName = author
+
+	permalink, err := f.permalink(m.Team.ID, m.Channel.ID, string(m.MessageTs))
+	if err != nil {
+		fmt.Println("ERROR: unable to get permalink to message")
+	}
+	r.Permalink = permalink
+
+	return r
+}
+
+// msgForAdmins takes a report and the data for the templates and constructs a
+// message that will be sent to the admins channel to allow admins to
+// investigate and act on the report.
+func (f *Flagger) msgForAdmins(ctx context.Context, r report.Report, channel string, d templates.Data) messaging.Envelope {
+	_, span := trace.StartSpan(ctx, "msgFlagger/msgForAdmins")
+	defer span.End()
+
+	desc, err := f.tmpl.Render(templates.Admins, "", "", d)
+	if err != nil {
+		fmt.Println("ERROR: unable to render message for admins:", err)
+		desc = report.DefaultDescription
+	}
+
+	e := messaging.Envelope{
+		Destination: messaging.Address{
+			TeamID:    r.TeamID,
+			ChannelID: channel,
+		},
+		Message:  report.AdminMessage(r, desc),
+		Delivery: messaging.DeliveryChannel,
+		Key:      report.AdminMessageKey(r.UID),
+	}
+	return e
+}
+
+// adminChannel takes a Slack Team ID and the team settings and returns the ID
+// of the admins channel configured for the team. It returns an error if not
+// found. It uses the access tokens from the data store to query Slack for a
+// list of channels unless the channel is already known.
+func (f *Flagger) adminChannel(t string, st settings.Settings) (string, error) {
+	ws, _, err := f.teams.Workspace(t)
+	if err != nil {
+		return "", err
+	}
+
+	adminChan, err := settings.AdminChannelID(f.data, st, ws)
+	if err != nil {
+		return adminChan, errors.Wrap(err, "unable to locate admins channel")
+	}
+
+	return adminChan, nil
+}
+
+func (f *Flagger) userName(t, id string) (string, error) {
+	ws, _, err := f.teams.Workspace(t)
+	if err != nil {
+		return "", err
+	}
+
+	userName, err := ws.UserName(id)
+	if err != nil {
+		return userName, errors.Wrap(err, "unable to get user name")
+	}
+
+	return userName, nil
+}
+
+// userLocale takes a Slack Team ID and User ID and returns the locale of the
+// user. If we can't look it up we return an empty locale so that the default
+// templates are used.
+func (f *Flagger) userLocale(t, id string) string {
+	ws, _, err := f.teams.Workspace(t)
+	if err != nil {
+		fmt.Println("ERROR: unable to get user locale:", err)
+		return ""
+	}
+
+	locale, err := ws.UserLocale(id)
+	if err != nil {
+		fmt.Println("ERROR: unable to get user locale:", err)
+		return ""
+	}
+	return locale
+}
+
+func (f *Flagger) permalink(t, ch, ts string) (string, error) {
+	ws, _, err := f.teams.Workspace(t)
+	if err != nil {
+		return "", err
+	}
+
+	permalink, err := ws.Permalink(ch, ts)
+	if err != nil {
+		return permalink, errors.Wrap(err, "unable to get message permalink")
+	}
+	return permalink, nil
+}
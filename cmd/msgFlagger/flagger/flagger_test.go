@@ -0,0 +1,433 @@
+package flagger
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/report"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/slack/slacktest"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/billglover/bbot/pkg/templates"
+)
+
+// setup returns a Flagger using a fake Slack server with a single team
+// installed, along with the server, outbound queue and report store.
+func setup(t *testing.T) (*Flagger, *slacktest.Server, *slacktest.Queue, storage.Store) {
+	srv := slacktest.NewServer()
+	srv.AddChannel(slacktest.Channel{ID: "G0ADMINS", Name: "admins", Private: true})
+	srv.AddUser(slacktest.User{ID: "U2", Name: "author"})
+
+	out := &slacktest.Queue{}
+	reports := storage.NewMemory("uid")
+	f, err := New(out, srv.Install("T1"), reports, storage.NewMemory("uid"))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	return f, srv, out, reports
+}
+
+func action(team string) slack.MessageAction {
+	return slack.MessageAction{
+		CallbackID: "flagMessage",
+		Team:       slack.Team{ID: team},
+		Channel:    slack.Channel{ID: "C1", Name: "general"},
+		User:       slack.User{ID: "U1", Name: "reporter"},
+		ActionTs:   "1500000001.000001",
+		MessageTs:  "1500000000.000001",
+		Message:    slack.Message{UserID: "U2", Text: "flagged"},
+	}
+}
+
+func TestFlagMessage(t *testing.T) {
+	f, srv, out, reports := setup(t)
+	defer srv.Close()
+
+	m := action("T1")
+	if err := f.FlagMessage(context.Background(), m); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	r, err := report.Get(reports, report.ID("T1", "C1", string(m.MessageTs), string(m.ActionTs)))
+	if err != nil {
+		t.Fatal("report not saved:", err)
+	}
+	if r.AuthorName != "author" {
+		t.Error("unexpected author name:", r.AuthorName)
+	}
+	if r.Permalink == "" {
+		t.Error("permalink not recorded")
+	}
+
+	if len(out.Envelopes) != 3 {
+		t.Fatal("unexpected number of messages:", len(out.Envelopes))
+	}
+	if d := out.Envelopes[0].Destination; d.UserID != "U1" || out.Envelopes[0].Mode() != messaging.DeliveryEphemeral {
+		t.Errorf("unexpected message for reporter: %+v", out.Envelopes[0])
+	}
+	if d := out.Envelopes[1].Destination; d.UserID != "U2" || out.Envelopes[1].Mode() != messaging.DeliveryDirect {
+		t.Errorf("unexpected message for author: %+v", out.Envelopes[1])
+	}
+	if d := out.Envelopes[2].Destination; d.ChannelID != "G0ADMINS" || d.UserID != "" || out.Envelopes[2].Key != report.AdminMessageKey(r.UID) {
+		t.Errorf("unexpected message for admins: %+v", out.Envelopes[2])
+	}
+
+	for _, c := range srv.Calls("") {
+		if c.Values.Get("token") != "xoxb-T1" {
+			t.Errorf("%s called with unexpected token: %s", c.Method, c.Values.Get("token"))
+		}
+	}
+}
+
+func TestFlagMessageUnknownTeam(t *testing.T) {
+	f, srv, out, _ := setup(t)
+	defer srv.Close()
+
+	if err := f.FlagMessage(context.Background(), action("T2")); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(out.Envelopes) != 0 {
+		t.Error("unexpected messages:", out.Envelopes)
+	}
+	if calls := srv.Calls(""); len(calls) != 0 {
+		t.Error("unexpected calls to Slack:", calls)
+	}
+}
+
+// unreadable is a store that can be written to but not read from.
+type unreadable struct {
+	storage.Store
+}
+
+func (unreadable) Retrieve(k, id string, v interface{}) error {
+	return errors.New("service unavailable")
+}
+
+func TestFlagMessageSettingsError(t *testing.T) {
+	f, srv, out, reports := setup(t)
+	defer srv.Close()
+
+	data := f.data
+	f.data = unreadable{data}
+
+	if err := f.FlagMessage(context.Background(), action("T1")); err == nil {
+		t.Fatal("expected an error when the settings can't be read")
+	}
+	if len(out.Envelopes) != 0 {
+		t.Error("unexpected messages:", out.Envelopes)
+	}
+
+	// Nothing is saved on behalf of a team whose settings we couldn't read.
+	if rs, _ := report.List(reports, "T1"); len(rs) != 0 {
+		t.Error("unexpected report saved:", rs)
+	}
+	st := settings.Settings{}
+	if err := data.Retrieve("uid", settings.ID("T1"), &st); err == nil {
+		t.Errorf("unexpected settings saved: %+v", st)
+	}
+}
+
+func TestFlagMessageSlackErrors(t *testing.T) {
+	f, srv, out, reports := setup(t)
+	defer srv.Close()
+
+	srv.SetRateLimit("users.info", 30*time.Second)
+	srv.SetError("conversations.list", "missing_scope")
+
+	m := action("T1")
+	if err := f.FlagMessage(context.Background(), m); err == nil {
+		t.Fatal("expected an error when the admins channel can't be found")
+	}
+
+	r, err := report.Get(reports, report.ID("T1", "C1", string(m.MessageTs), string(m.ActionTs)))
+	if err != nil {
+		t.Fatal("report not saved:", err)
+	}
+	if r.AuthorName != "unknown" {
+		t.Error("unexpected author name:", r.AuthorName)
+	}
+
+	// The reporter and author are still notified.
+	if len(out.Envelopes) != 2 {
+		t.Fatal("unexpected number of messages:", len(out.Envelopes))
+	}
+}
+
+func TestFlagMessageAdminChannel(t *testing.T) {
+	f, srv, out, _ := setup(t)
+	defer srv.Close()
+	srv.AddChannel(slacktest.Channel{ID: "C0MODS01", Name: "moderators"})
+
+	st := settings.Settings{TeamID: "T1", AdminChannel: "moderators"}
+	if err := settings.Save(f.data, st); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := f.FlagMessage(context.Background(), action("T1")); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if d := out.Envelopes[len(out.Envelopes)-1].Destination; d.ChannelID != "C0MODS01" {
+			t.Errorf("unexpected message for admins: %+v", d)
+		}
+	}
+
+	// The resolved ID is stored so that we only look for the channel once.
+	if calls := srv.Calls("conversations.list"); len(calls) != 1 {
+		t.Error("unexpected number of calls to conversations.list:", len(calls))
+	}
+	st, err := settings.Get(f.data, "T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if st.AdminChannel != "C0MODS01" {
+		t.Error("admins channel not stored:", st.AdminChannel)
+	}
+}
+
+func TestFlagMessageTemplates(t *testing.T) {
+	f, srv, out, _ := setup(t)
+	defer srv.Close()
+	srv.AddUser(slacktest.User{ID: "U1", Name: "reporter", Locale: "fr-FR"})
+	srv.AddUser(slacktest.User{ID: "U2", Name: "author", Locale: "en-GB"})
+
+	tmpl, err := templates.Load("../../../templates")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := Templates(tmpl)(f); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	tcs := []struct {
+		name     string
+		url      string
+		override string
+		reporter string
+		author   string
+	}{
+		{name: "default", reporter: "Merci", author: "One of your recent messages in <#C1>"},
+		{name: "link", url: "https://example.com/coc", reporter: "https://example.com/coc", author: "https://example.com/coc"},
+		{name: "team wording", override: "Thanks {{.Reporter}}, {{.Author}} has been told.", reporter: "Thanks reporter, author has been told.", author: "One of your recent messages"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			out.Reset()
+
+			// Each case flags the same message, which would otherwise be
+			// reported in the thread under the first report, and starts
+			// from the default settings.
+			f.reports = storage.NewMemory("uid")
+			f.data = storage.NewMemory("uid")
+
+			st := settings.Settings{TeamID: "T1", CoCURL: tc.url}
+			if tc.override != "" {
+				st.Messages = map[string]string{settings.MessageReporter: tc.override}
+			}
+			if err := settings.Save(f.data, st); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			if err := f.FlagMessage(context.Background(), action("T1")); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if len(out.Envelopes) != 3 {
+				t.Fatal("unexpected number of messages:", len(out.Envelopes))
+			}
+
+			if txt := out.Envelopes[0].Message.Text; strings.Contains(txt, tc.reporter) == false {
+				t.Errorf("unexpected message for reporter: %q", txt)
+			}
+			if txt := out.Envelopes[1].Message.Text; strings.Contains(txt, tc.author) == false {
+				t.Errorf("unexpected message for author: %q", txt)
+			}
+			if desc := out.Envelopes[2].Message.Attachments[0].Description; strings.Contains(desc, tc.url) == false {
+				t.Errorf("unexpected message for admins: %q", desc)
+			}
+		})
+	}
+}
+
+func TestFlagMessageAuthorNotToldReporter(t *testing.T) {
+	f, srv, out, _ := setup(t)
+	defer srv.Close()
+
+	// Even wording chosen by the team can't tell the author who flagged their
+	// message.
+	st := settings.Settings{TeamID: "T1", Messages: map[string]string{settings.MessageAuthor: "Flagged by {{.Reporter}}{{.ReporterID}}."}}
+	if err := settings.Save(f.data, st); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if err := f.FlagMessage(context.Background(), action("T1")); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(out.Envelopes) != 3 {
+		t.Fatal("unexpected number of messages:", len(out.Envelopes))
+	}
+	if txt := out.Envelopes[1].Message.Text; txt != "Flagged by ." {
+		t.Errorf("reporter shown to author: %q", txt)
+	}
+}
+
+func TestFlagMessageAgain(t *testing.T) {
+	f, srv, out, reports := setup(t)
+	defer srv.Close()
+
+	first := action("T1")
+	if err := f.FlagMessage(context.Background(), first); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	again := action("T1")
+	again.User = slack.User{ID: "U3", Name: "another"}
+	again.ActionTs = "1500000002.000001"
+	out.Reset()
+	if err := f.FlagMessage(context.Background(), again); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// The second flag is added to the first report.
+	id := report.ID("T1", "C1", string(first.MessageTs), string(first.ActionTs))
+	r, err := report.Get(reports, id)
+	if err != nil {
+		t.Fatal("report not saved:", err)
+	}
+	if r.Count() != 2 || strings.Join(r.Reporters(), ",") != "reporter,another" {
+		t.Errorf("flags not merged: %+v", r.Flags)
+	}
+	if _, err := report.Get(reports, report.ID("T1", "C1", string(again.MessageTs), string(again.ActionTs))); err == nil {
+		t.Error("unexpected second report")
+	}
+
+	// The reporter is thanked, the author isn't told again and the admin
+	// message is updated with a note in its thread.
+	if len(out.Envelopes) != 3 {
+		t.Fatal("unexpected number of messages:", len(out.Envelopes))
+	}
+	if d := out.Envelopes[0].Destination; d.UserID != "U3" {
+		t.Errorf("unexpected message for reporter: %+v", out.Envelopes[0])
+	}
+	if e := out.Envelopes[1]; e.Operation != messaging.OperationUpdate || e.Key != report.AdminMessageKey(id) {
+		t.Errorf("unexpected update for admins: %+v", e)
+	}
+	e := out.Envelopes[2]
+	if e.Mode() != messaging.DeliveryThread || e.Destination.ThreadKey != report.AdminMessageKey(id) {
+		t.Errorf("unexpected message for admins: %+v", e)
+	}
+	if strings.Contains(e.Message.Text, "<@U3>") == false {
+		t.Error("reporter not mentioned:", e.Message.Text)
+	}
+}
+
+func TestFlagMessageAnonymously(t *testing.T) {
+	tcs := []struct {
+		name      string
+		callback  string
+		anonymous bool
+	}{
+		{name: "named", callback: report.FlagCallbackID},
+		{name: "action", callback: report.FlagAnonymouslyCallbackID, anonymous: true},
+		{name: "team default", callback: report.FlagCallbackID, anonymous: true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			f, srv, out, reports := setup(t)
+			defer srv.Close()
+
+			if tc.name == "team default" {
+				if err := settings.Save(f.data, settings.Settings{TeamID: "T1", Anonymous: true}); err != nil {
+					t.Fatal("unexpected error:", err)
+				}
+			}
+
+			m := action("T1")
+			m.CallbackID = tc.callback
+			if err := f.FlagMessage(context.Background(), m); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			// The reporter is always recorded.
+			r, err := report.Get(reports, report.ID("T1", "C1", string(m.MessageTs), string(m.ActionTs)))
+			if err != nil {
+				t.Fatal("report not saved:", err)
+			}
+			if r.ReporterID != "U1" || r.Anonymous() != tc.anonymous {
+				t.Errorf("unexpected reporter: %s, %t", r.ReporterID, r.Anonymous())
+			}
+
+			// Only the reporter sees their name, and they are told it was
+			// withheld from the admins.
+			reply := out.Envelopes[0].Message.Text
+			if withheld := strings.Contains(reply, "hasn't been shared"); withheld != tc.anonymous {
+				t.Errorf("unexpected reply to reporter: %s", reply)
+			}
+
+			want := "reporter"
+			if tc.anonymous {
+				want = report.AnonymousReporter
+			}
+			for _, field := range out.Envelopes[2].Message.Attachments[0].Fields {
+				if field.Name == "reporter" && field.Value != want {
+					t.Errorf("unexpected reporter shown to admins: %s", field.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestFlagMessageAuthorNotice(t *testing.T) {
+	tcs := []struct {
+		policy   string
+		notified bool
+		deferred bool
+	}{
+		{policy: settings.NotifyImmediately, notified: true},
+		{policy: settings.NotifyOnConfirmation, deferred: true},
+		{policy: settings.NotifyNever},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.policy, func(t *testing.T) {
+			f, srv, out, reports := setup(t)
+			defer srv.Close()
+
+			if err := settings.Save(f.data, settings.Settings{TeamID: "T1", AuthorNotice: tc.policy}); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			m := action("T1")
+			if err := f.FlagMessage(context.Background(), m); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			notified := false
+			for _, e := range out.Envelopes {
+				if e.Destination.UserID == "U2" {
+					notified = true
+				}
+			}
+			if notified != tc.notified {
+				t.Errorf("unexpected author notification: %t", notified)
+			}
+
+			r, err := report.Get(reports, report.ID("T1", "C1", string(m.MessageTs), string(m.ActionTs)))
+			if err != nil {
+				t.Fatal("report not saved:", err)
+			}
+			if (r.AuthorNotice != nil) != tc.deferred {
+				t.Errorf("unexpected deferred notification: %+v", r.AuthorNotice)
+			}
+			if tc.deferred && (r.AuthorNotice.Destination.UserID != "U2" || r.AuthorNotice.Mode() != messaging.DeliveryDirect) {
+				t.Errorf("unexpected deferred notification: %+v", r.AuthorNotice)
+			}
+		})
+	}
+}
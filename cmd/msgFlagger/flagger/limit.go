@@ -0,0 +1,82 @@
+package flagger
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/ratelimit"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+)
+
+// Replies to people whose flag isn't accepted because too many messages have
+// been flagged recently.
+const (
+	throttledReporter = "Thank you for looking out for everyone. You've flagged a lot of messages recently so I've paused flagging for you for a little while. Please try again later, or contact one of the admins directly if something needs their attention now."
+	throttledAuthor   = "Thank you for flagging the message. The admins have received a lot of flags about messages from this person recently and are already looking into them. If something needs their attention now, please contact one of the admins directly."
+)
+
+// limit is a limit a flag is counted against, along with the reply for the
+// reporter if the limit has been reached.
+type limit struct {
+	key   string
+	count int
+	reply string
+}
+
+// throttled takes a message action and the team settings and counts the flag
+// against the limits for the reporter and the author of the message. It
+// returns the reply for the reporter if either limit has been reached, or an
+// empty string if the flag is allowed. Flags that aren't allowed don't count
+// against either limit. If we can't check a limit we allow the flag rather
+// than risk losing a genuine report.
+func (f *Flagger) throttled(m slack.MessageAction, st settings.Settings) string {
+	limits := []limit{{key: "reporter:" + m.User.ID, count: st.FlagsPerReporter(), reply: throttledReporter}}
+	if m.Message.UserID != "" {
+		limits = append(limits, limit{key: "author:" + m.Message.UserID, count: st.FlagsPerAuthor(), reply: throttledAuthor})
+	}
+
+	now := time.Now().UTC()
+	var counted []limit
+	for _, l := range limits {
+		ok, err := ratelimit.Allow(f.data, m.Team.ID, l.key, l.limit(), now)
+		if err != nil {
+			fmt.Println("ERROR: unable to check flag limit:", err)
+			continue
+		}
+		if ok {
+			counted = append(counted, l)
+			continue
+		}
+
+		fmt.Printf("INFO: flag by %s throttled for team %s: %s\n", m.User.ID, m.Team.ID, l.key)
+		for _, c := range counted {
+			if err := ratelimit.Refund(f.data, m.Team.ID, c.key, c.limit(), now); err != nil {
+				fmt.Println("ERROR: unable to refund flag limit:", err)
+			}
+		}
+		return l.reply
+	}
+	return ""
+}
+
+// limit returns the limit in the form counted by the ratelimit package.
+func (l limit) limit() ratelimit.Limit {
+	return ratelimit.Limit{Count: l.count, Period: settings.LimitPeriod}
+}
+
+// msgForThrottled takes a message action that wasn't accepted and the reply
+// for the reporter and constructs a message that will be sent to them.
+func msgForThrottled(m slack.MessageAction, txt string) messaging.Envelope {
+	e := messaging.Envelope{
+		Destination: messaging.Address{
+			TeamID:    m.Team.ID,
+			ChannelID: m.Channel.ID,
+			UserID:    m.User.ID,
+		},
+		Message:  messaging.Message{Text: txt},
+		Delivery: messaging.DeliveryEphemeral,
+	}
+	return e
+}
@@ -0,0 +1,132 @@
+package flagger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/report"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+)
+
+func TestFlagMessageThrottled(t *testing.T) {
+	tcs := []struct {
+		name   string
+		st     settings.Settings
+		user   func(i int) slack.User
+		author func(i int) string
+		reply  string
+	}{
+		{
+			name:   "reporter",
+			st:     settings.Settings{TeamID: "T1", ReporterLimit: 2},
+			user:   func(i int) slack.User { return slack.User{ID: "U1", Name: "reporter"} },
+			author: func(i int) string { return fmt.Sprintf("U%d", 10+i) },
+			reply:  throttledReporter,
+		},
+		{
+			name:   "author",
+			st:     settings.Settings{TeamID: "T1", AuthorLimit: 2},
+			user:   func(i int) slack.User { return slack.User{ID: fmt.Sprintf("U%d", 10+i)} },
+			author: func(i int) string { return "U2" },
+			reply:  throttledAuthor,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			f, srv, out, reports := setup(t)
+			defer srv.Close()
+
+			if err := settings.Save(f.data, tc.st); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			// Each flag is of a different message so that each is reported.
+			for i := 0; i < 3; i++ {
+				out.Reset()
+				m := action("T1")
+				m.User = tc.user(i)
+				m.Message.UserID = tc.author(i)
+				m.MessageTs = json.Number(fmt.Sprintf("15000000%02d.000001", i))
+				if err := f.FlagMessage(context.Background(), m); err != nil {
+					t.Fatal("unexpected error:", err)
+				}
+
+				_, reported, err := report.FindOpen(reports, "T1", "C1", string(m.MessageTs))
+				if err != nil {
+					t.Fatal("unexpected error:", err)
+				}
+				if i < 2 {
+					if reported == false || len(out.Envelopes) != 3 {
+						t.Errorf("flag %d not reported: %d messages", i, len(out.Envelopes))
+					}
+					continue
+				}
+
+				if reported {
+					t.Errorf("flag %d reported", i)
+				}
+				if len(out.Envelopes) != 1 {
+					t.Fatal("unexpected number of messages queued:", len(out.Envelopes))
+				}
+				e := out.Envelopes[0]
+				if e.Mode() != messaging.DeliveryEphemeral || e.Destination.UserID != m.User.ID || e.Message.Text != tc.reply {
+					t.Errorf("unexpected reply: %s, %s, %s", e.Mode(), e.Destination.UserID, e.Message.Text)
+				}
+			}
+		})
+	}
+}
+
+func TestFlagMessageThrottledNotCounted(t *testing.T) {
+	f, srv, out, _ := setup(t)
+	defer srv.Close()
+
+	if err := settings.Save(f.data, settings.Settings{TeamID: "T1", ReporterLimit: 2, AuthorLimit: 1}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// The second flag is of another message by the same author so is
+	// throttled by the author limit. It doesn't count against the reporter's
+	// limit so their next flag, of a message by someone else, is reported.
+	authors := []string{"U2", "U2", "U3"}
+	replies := []int{3, 1, 3}
+	for i, author := range authors {
+		out.Reset()
+		m := action("T1")
+		m.Message.UserID = author
+		m.MessageTs = json.Number(fmt.Sprintf("15000000%02d.000001", i))
+		if err := f.FlagMessage(context.Background(), m); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if len(out.Envelopes) != replies[i] {
+			t.Errorf("flag %d: unexpected number of messages queued: %d", i, len(out.Envelopes))
+		}
+	}
+}
+
+func TestFlagMessageWithoutLimit(t *testing.T) {
+	f, srv, out, _ := setup(t)
+	defer srv.Close()
+
+	if err := settings.Save(f.data, settings.Settings{TeamID: "T1", ReporterLimit: settings.NoLimit}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for i := 0; i < settings.DefaultReporterLimit+1; i++ {
+		out.Reset()
+		m := action("T1")
+		m.Message.UserID = fmt.Sprintf("U%d", 10+i)
+		m.MessageTs = json.Number(fmt.Sprintf("15000000%02d.000001", i))
+		if err := f.FlagMessage(context.Background(), m); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if len(out.Envelopes) != 3 {
+			t.Fatalf("flag %d not reported: %d messages", i, len(out.Envelopes))
+		}
+	}
+}
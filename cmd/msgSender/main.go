@@ -1,102 +1,61 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
 	"os"
 
-	"github.com/billglover/bbot/pkg/slack"
-
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/cmd/msgSender/sender"
 	"github.com/billglover/bbot/pkg/queue"
-	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/slack"
 	"github.com/billglover/bbot/pkg/storage"
 )
 
-var (
-	clientID     string
-	clientSecret string
-	region       string
-	authTable    string
-)
-
 func main() {
-	region = os.Getenv("BUDDYBOT_REGION")
+	// Messages that can't be sent yet are placed back on the queue so we
+	// need to know where it is.
+	sendMessageQ := os.Getenv("SQS_QUEUE_SENDMESSAGE")
+	if sendMessageQ == "" {
+		fmt.Println("ERROR: SQS_QUEUE_SENDMESSAGE environment variable not set")
+		os.Exit(1)
+	}
+
+	region := os.Getenv("BUDDYBOT_REGION")
 	if region == "" {
 		region = os.Getenv("BUDDYBOT_REGION")
 		fmt.Println("ERROR: BUDDYBOT_REGION environment variable not set")
 		os.Exit(1)
 	}
 
-	authTable = os.Getenv("BUDDYBOT_AUTH_TABLE")
+	authTable := os.Getenv("BUDDYBOT_AUTH_TABLE")
 	if authTable == "" {
 		fmt.Println("ERROR: BUDDYBOT_AUTH_TABLE environment variable not set")
 		os.Exit(1)
 	}
 
-	stage := os.Getenv("BUDDYBOT_STAGE")
-	if stage == "" {
-		fmt.Println("ERROR: BUDDYBOT_STAGE environment variable not set")
+	dataTable := os.Getenv("BUDDYBOT_DATA_TABLE")
+	if dataTable == "" {
+		fmt.Println("ERROR: BUDDYBOT_DATA_TABLE environment variable not set")
 		os.Exit(1)
 	}
 
-	// retrieve secrets from the AWS parameter store
-	s, err := secrets.GetSecrets([]string{
-		"/bbot/" + stage + "/SLACK_CLIENT_ID",
-		"/bbot/" + stage + "/SLACK_CLIENT_SECRET",
-	})
+	q, err := queue.NewSQSQueue(sendMessageQ)
 	if err != nil {
-		fmt.Println("ERROR: unable to retrieve secrets from parameter store:", err)
+		fmt.Println("ERROR: unable to determine sendMessage queue:", err)
 		os.Exit(1)
 	}
-	clientID = s["/bbot/"+stage+"/SLACK_CLIENT_ID"]
-	clientSecret = s["/bbot/"+stage+"/SLACK_CLIENT_SECRET"]
 
-	lambda.Start(handler)
-}
-
-func handler(ctx context.Context, evt queue.SQSEvent) error {
-
-	for _, msg := range evt.Records {
-		e := messaging.Envelope{}
-		err := json.Unmarshal([]byte(msg.Body), &e)
-		if err != nil {
-			// If we return an error from the handler, the message remains on the
-			// queue for future processing. Without separate error handline (e.g.
-			// a dead-letter queue) this can result in an infinite (expensive) loop.
-			fmt.Println("ERROR: unable to parse envelope:", err)
-			return nil
-		}
-
-		db := storage.DynamoDB{
-			Region: region,
-			Table:  authTable,
-		}
-		ar, err := secrets.GetTeamTokens(&db, e.Destination.TeamID)
-		if err != nil {
-			fmt.Println("ERROR: unable to fetch team tokens:", err)
-			//return errors.Wrap(err, "unable to fetch team tokens")
-			return nil
-		}
+	tokens := &storage.DynamoDB{Region: region, Table: authTable}
+	data := &storage.DynamoDB{Region: region, Table: dataTable}
 
-		ws, err := slack.New(ar.BotAccessToken, ar.AccessToken, ar.BotUserID)
-		if err != nil {
-			fmt.Println("ERROR: unable to establish Slack workspace:", err)
-			//return errors.Wrap(err, "unable to establish Slack workspace")
-			return nil
-		}
-
-		err = ws.SendMessage(e)
-		if err != nil {
-			fmt.Println("ERROR: unable to send message to Slack:", err)
-			//return errors.Wrap(err, "unable to send message to Slack")
-			return nil
-		}
-
-		//fmt.Printf("%+v\n", e)
+	// We tell AWS Lambda to start handling outbound messages using our
+	// Sender.
+	teams, err := slack.NewTeams(tokens)
+	if err != nil {
+		fmt.Println("ERROR: unable to create teams:", err)
+		os.Exit(1)
 	}
 
-	return nil
+	s := sender.New(q, teams, data)
+	lambda.Start(s.Handle)
 }
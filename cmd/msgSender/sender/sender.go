@@ -0,0 +1,234 @@
+/*
+Package sender delivers messages that have been placed on the sendMessage
+queue to Slack.
+*/
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/queue"
+	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// ErrNotSentYet is the cause of errors replying to, updating or deleting a
+// message we haven't finished sending. Envelopes are placed on the queue in
+// order but may be taken off it in any order, so the envelope can be sent
+// once the message it refers to has been.
+var ErrNotSentYet = errors.New("message has not been sent yet")
+
+// maxAttempts is the number of times we try to send an envelope that refers
+// to a message we haven't finished sending before giving up waiting for it.
+const maxAttempts = 3
+
+// retryDelay is how long we wait before placing an envelope back on the queue
+// to give the message it refers to time to be sent.
+const retryDelay = 2 * time.Second
+
+// Sender sends messages to Slack using the tokens held for each team.
+// Envelopes that can't be sent yet are placed back on the outbound queue.
+type Sender struct {
+	out   queue.Queuer
+	teams *slack.Teams
+	data  storage.Store
+	delay time.Duration
+}
+
+// New takes the outbound message queue, the teams that have installed
+// BuddyBot and the store holding team data, where the messages we send are
+// recorded, and returns a pointer to a Sender.
+func New(out queue.Queuer, teams *slack.Teams, data storage.Store) *Sender {
+	return &Sender{out: out, teams: teams, data: data, delay: retryDelay}
+}
+
+// Handle unmarshals envelopes taken off the sendMessage queue and sends each
+// message to Slack.
+//
+// If we return an error from the handler, the message remains on the queue
+// for future processing. Without separate error handling (e.g. a dead-letter
+// queue) this can result in an infinite (expensive) loop. For now, we don't
+// return errors opting to log them instead. Envelopes that refer to a message
+// we haven't finished sending are placed back on the queue by later.
+func (s *Sender) Handle(ctx context.Context, evt queue.SQSEvent) error {
+	for _, msg := range evt.Records {
+		e := messaging.Envelope{}
+		err := json.Unmarshal([]byte(msg.Body), &e)
+		if err != nil {
+			fmt.Println("ERROR: unable to parse envelope:", err)
+			continue
+		}
+
+		err = s.Send(e)
+		if errors.Cause(err) == ErrNotSentYet {
+			err = s.later(ctx, e, err)
+		}
+		if err != nil {
+			fmt.Println("ERROR: unable to send message to Slack:", err)
+		}
+	}
+	return nil
+}
+
+// later takes an envelope that refers to a message we haven't finished
+// sending and places it back on the queue, after a short wait, to be sent once
+// the message has been. Once we have tried maxAttempts times we stop waiting:
+// replies are posted in the channel without a thread and other envelopes are
+// dropped, returning the cause provided.
+func (s *Sender) later(ctx context.Context, e messaging.Envelope, cause error) error {
+	e.Attempts++
+	if e.Attempts < maxAttempts {
+		fmt.Println("INFO: placing message back on the queue to send later:", cause)
+		time.Sleep(s.delay)
+		h := queue.Headers{"Team": e.Destination.TeamID}
+		if err := s.out.Queue(ctx, h, e); err != nil {
+			return errors.Wrap(err, "unable to queue message to send later")
+		}
+		return nil
+	}
+
+	// Updates and deletes can't be carried out without the message itself.
+	if e.Operation == "" || e.Operation == messaging.OperationPost {
+		fmt.Println("INFO: posting reply without a thread:", cause)
+		e.Destination.ThreadKey = ""
+		e.Delivery = messaging.DeliveryChannel
+		return s.Send(e)
+	}
+	return cause
+}
+
+// Send takes an envelope and carries out the operation it describes: sending
+// the message it contains to Slack, or updating or deleting a message sent
+// previously. Messages for teams without BuddyBot installed are dropped. If
+// Slack tells us our tokens have been revoked the tokens are deleted and the
+// message dropped.
+func (s *Sender) Send(e messaging.Envelope) error {
+	ws, _, err := s.teams.Workspace(e.Destination.TeamID)
+	if errors.Cause(err) == secrets.ErrUnknownTeam {
+		fmt.Println("INFO: dropping message for team without BuddyBot installed:", e.Destination.TeamID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch e.Operation {
+	case "", messaging.OperationPost:
+		err = s.post(ws, e)
+	case messaging.OperationUpdate:
+		err = s.update(ws, e)
+	case messaging.OperationDelete:
+		err = s.delete(ws, e)
+	default:
+		return errors.Errorf("operation not supported: %s", e.Operation)
+	}
+
+	if slack.IsRevoked(err) {
+		// Our tokens are no longer valid so there is no point holding on
+		// to them. Removing them means we drop any further messages for
+		// the team without calling Slack.
+		fmt.Println("INFO: dropping message for team with revoked tokens:", e.Destination.TeamID)
+		if err := s.teams.Remove(e.Destination.TeamID); err != nil {
+			return errors.Wrap(err, "unable to delete team tokens")
+		}
+		return nil
+	}
+	return err
+}
+
+// post sends a new message, using the response URL if one is provided. If the
+// envelope has a Key, where the message was posted is recorded against it.
+// Ephemeral messages can't be changed later so are never recorded.
+func (s *Sender) post(ws *slack.Workspace, e messaging.Envelope) error {
+	if e.Destination.ResponseURL != "" {
+		return ws.Respond(e.Destination.ResponseURL, e.Message, false)
+	}
+
+	// Replies to a message we sent earlier may only know the key it was sent
+	// with, in which case we look up where it was posted.
+	d := e.Destination
+	if e.Mode() == messaging.DeliveryThread && d.ThreadTs == "" && d.ThreadKey != "" {
+		sent, err := messaging.GetSent(s.data, d.TeamID, d.ThreadKey)
+		if errors.Cause(err) == storage.ErrNotFound {
+			return errors.Wrapf(ErrNotSentYet, "unable to find thread with key %s", d.ThreadKey)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "unable to find thread with key %s", d.ThreadKey)
+		}
+		e.Destination.ChannelID = sent.ChannelID
+		e.Destination.ThreadTs = sent.Ts
+	}
+
+	ch, ts, err := ws.SendMessage(e)
+	if err != nil || e.Key == "" || e.Mode() == messaging.DeliveryEphemeral {
+		return err
+	}
+
+	sent := messaging.Sent{
+		TeamID:    e.Destination.TeamID,
+		Key:       e.Key,
+		ChannelID: ch,
+		Ts:        ts,
+		Sent:      time.Now().UTC(),
+	}
+	if err := messaging.SaveSent(s.data, sent); err != nil {
+		// The message has been sent so we don't want it sent again. It
+		// just can't be changed later.
+		fmt.Println("ERROR: unable to record message sent:", err)
+	}
+	return nil
+}
+
+// update replaces the content of a message sent previously.
+func (s *Sender) update(ws *slack.Workspace, e messaging.Envelope) error {
+	if e.Destination.ResponseURL != "" {
+		return ws.Respond(e.Destination.ResponseURL, e.Message, true)
+	}
+
+	ch, ts, err := s.target(e)
+	if err != nil {
+		return err
+	}
+	return ws.UpdateMessage(ch, ts, e.Message)
+}
+
+// delete deletes a message sent previously.
+func (s *Sender) delete(ws *slack.Workspace, e messaging.Envelope) error {
+	if e.Destination.ResponseURL != "" {
+		return ws.DeleteResponse(e.Destination.ResponseURL)
+	}
+
+	ch, ts, err := s.target(e)
+	if err != nil {
+		return err
+	}
+	return ws.DeleteMessage(ch, ts)
+}
+
+// target returns the channel and timestamp of the message an envelope refers
+// to. These are taken from the Destination if provided, otherwise they are
+// looked up using the Key of the envelope.
+func (s *Sender) target(e messaging.Envelope) (string, string, error) {
+	d := e.Destination
+	if d.ChannelID != "" && d.Ts != "" {
+		return d.ChannelID, d.Ts, nil
+	}
+	if e.Key == "" {
+		return "", "", errors.New("a channel and timestamp or a key must be provided")
+	}
+
+	sent, err := messaging.GetSent(s.data, d.TeamID, e.Key)
+	if errors.Cause(err) == storage.ErrNotFound {
+		return "", "", errors.Wrapf(ErrNotSentYet, "unable to find message sent with key %s", e.Key)
+	}
+	if err != nil {
+		return "", "", errors.Wrapf(err, "unable to find message sent with key %s", e.Key)
+	}
+	return sent.ChannelID, sent.Ts, nil
+}
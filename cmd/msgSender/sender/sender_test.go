@@ -0,0 +1,436 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/queue"
+	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/slack/slacktest"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// setup returns a Sender using a fake Slack server with a single team
+// installed, along with the server and the teams. Envelopes placed back on
+// the queue are kept by a slacktest.Queue and aren't delayed.
+func setup(t *testing.T) (*Sender, *slacktest.Server, *slack.Teams) {
+	srv := slacktest.NewServer()
+	teams := srv.Install("T1")
+	s := New(&slacktest.Queue{}, teams, storage.NewMemory("uid"))
+	s.delay = 0
+	return s, srv, teams
+}
+
+func TestSend(t *testing.T) {
+	s, srv, _ := setup(t)
+	defer srv.Close()
+
+	tcs := []struct {
+		name   string
+		e      messaging.Envelope
+		method string
+	}{
+		{
+			name: "ephemeral",
+			e: messaging.Envelope{
+				Destination: messaging.Address{TeamID: "T1", ChannelID: "C1", UserID: "U1"},
+				Message:     messaging.Message{Text: "hello"},
+				Delivery:    messaging.DeliveryEphemeral,
+			},
+			method: "chat.postEphemeral",
+		},
+		{
+			name: "channel",
+			e: messaging.Envelope{
+				Destination: messaging.Address{TeamID: "T1", ChannelID: "G1"},
+				Message: messaging.Message{
+					Text:        "hello",
+					Attachments: []messaging.Attachment{{Title: "Message Flagged"}},
+				},
+			},
+			method: "chat.postMessage",
+		},
+		{
+			name: "thread",
+			e: messaging.Envelope{
+				Destination: messaging.Address{TeamID: "T1", ChannelID: "G1", ThreadTs: "1.000001"},
+				Message:     messaging.Message{Text: "hello"},
+				Delivery:    messaging.DeliveryThread,
+			},
+			method: "chat.postMessage",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			srv.Reset()
+
+			if err := s.Send(tc.e); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			calls := srv.Calls(tc.method)
+			if len(calls) != 1 {
+				t.Fatalf("unexpected number of calls to %s: %d", tc.method, len(calls))
+			}
+			v := calls[0].Values
+			if v.Get("channel") != tc.e.Destination.ChannelID || v.Get("text") != "hello" {
+				t.Errorf("unexpected call: %v", v)
+			}
+			if v.Get("token") != "xoxb-T1" {
+				t.Error("unexpected token:", v.Get("token"))
+			}
+			if v.Get("thread_ts") != tc.e.Destination.ThreadTs {
+				t.Error("unexpected thread:", v.Get("thread_ts"))
+			}
+		})
+	}
+}
+
+func TestSendDirect(t *testing.T) {
+	s, srv, _ := setup(t)
+	defer srv.Close()
+
+	e := messaging.Envelope{
+		Destination: messaging.Address{TeamID: "T1", UserID: "U1"},
+		Message:     messaging.Message{Text: "hello"},
+		Delivery:    messaging.DeliveryDirect,
+	}
+	if err := s.Send(e); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if calls := srv.Calls("conversations.open"); len(calls) != 1 || calls[0].Values.Get("users") != "U1" {
+		t.Errorf("unexpected calls to conversations.open: %v", calls)
+	}
+	calls := srv.Calls("chat.postMessage")
+	if len(calls) != 1 || calls[0].Values.Get("channel") != "DU1" || calls[0].Values.Get("text") != "hello" {
+		t.Errorf("unexpected calls to chat.postMessage: %v", calls)
+	}
+	if calls := srv.Calls("chat.postEphemeral"); len(calls) != 0 {
+		t.Errorf("unexpected calls to chat.postEphemeral: %v", calls)
+	}
+}
+
+func TestSendUnknownTeam(t *testing.T) {
+	s, srv, _ := setup(t)
+	defer srv.Close()
+
+	e := messaging.Envelope{Destination: messaging.Address{TeamID: "T2", ChannelID: "C1"}}
+	if err := s.Send(e); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if calls := srv.Calls(""); len(calls) != 0 {
+		t.Error("unexpected calls to Slack:", calls)
+	}
+}
+
+func TestSendRevoked(t *testing.T) {
+	s, srv, teams := setup(t)
+	defer srv.Close()
+
+	srv.SetError("chat.postMessage", "token_revoked")
+
+	e := messaging.Envelope{Destination: messaging.Address{TeamID: "T1", ChannelID: "G1"}}
+	if err := s.Send(e); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if _, _, err := teams.Workspace("T1"); errors.Cause(err) != secrets.ErrUnknownTeam {
+		t.Error("tokens not deleted:", err)
+	}
+}
+
+func TestSendRateLimited(t *testing.T) {
+	s, srv, teams := setup(t)
+	defer srv.Close()
+
+	srv.SetRateLimit("chat.postMessage", time.Minute)
+
+	e := messaging.Envelope{Destination: messaging.Address{TeamID: "T1", ChannelID: "G1"}}
+	if err := s.Send(e); err == nil {
+		t.Fatal("expected an error when rate limited")
+	}
+
+	if _, _, err := teams.Workspace("T1"); err != nil {
+		t.Error("tokens should be kept:", err)
+	}
+}
+
+func TestSendRecorded(t *testing.T) {
+	s, srv, _ := setup(t)
+	defer srv.Close()
+
+	e := messaging.Envelope{
+		Destination: messaging.Address{TeamID: "T1", ChannelID: "G1"},
+		Message:     messaging.Message{Text: "hello"},
+		Key:         "report:r1",
+	}
+	if err := s.Send(e); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	sent, err := messaging.GetSent(s.data, "T1", "report:r1")
+	if err != nil {
+		t.Fatal("message not recorded:", err)
+	}
+	if sent.ChannelID != "G1" || sent.Ts == "" {
+		t.Errorf("unexpected record: %+v", sent)
+	}
+
+	// Ephemeral messages can't be changed so aren't recorded.
+	e = messaging.Envelope{
+		Destination: messaging.Address{TeamID: "T1", ChannelID: "C1", UserID: "U1"},
+		Delivery:    messaging.DeliveryEphemeral,
+		Key:         "ephemeral",
+	}
+	if err := s.Send(e); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if _, err := messaging.GetSent(s.data, "T1", "ephemeral"); err == nil {
+		t.Error("ephemeral message recorded")
+	}
+}
+
+func TestSendChanges(t *testing.T) {
+	s, srv, _ := setup(t)
+	defer srv.Close()
+
+	err := messaging.SaveSent(s.data, messaging.Sent{TeamID: "T1", Key: "report:r1", ChannelID: "G1", Ts: "1.000001"})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	tcs := []struct {
+		name   string
+		e      messaging.Envelope
+		method string
+		ch, ts string
+		err    bool
+	}{
+		{
+			name:   "update by key",
+			e:      messaging.Envelope{Destination: messaging.Address{TeamID: "T1"}, Operation: messaging.OperationUpdate, Key: "report:r1"},
+			method: "chat.update", ch: "G1", ts: "1.000001",
+		},
+		{
+			name:   "update by timestamp",
+			e:      messaging.Envelope{Destination: messaging.Address{TeamID: "T1", ChannelID: "C1", Ts: "2.000002"}, Operation: messaging.OperationUpdate},
+			method: "chat.update", ch: "C1", ts: "2.000002",
+		},
+		{
+			name:   "delete by key",
+			e:      messaging.Envelope{Destination: messaging.Address{TeamID: "T1"}, Operation: messaging.OperationDelete, Key: "report:r1"},
+			method: "chat.delete", ch: "G1", ts: "1.000001",
+		},
+		{
+			name:   "unknown key",
+			e:      messaging.Envelope{Destination: messaging.Address{TeamID: "T1"}, Operation: messaging.OperationDelete, Key: "report:r2"},
+			method: "chat.delete", err: true,
+		},
+		{
+			name:   "unknown operation",
+			e:      messaging.Envelope{Destination: messaging.Address{TeamID: "T1", ChannelID: "C1"}, Operation: "archive"},
+			method: "chat.postMessage", err: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			srv.Reset()
+
+			err := s.Send(tc.e)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if calls := srv.Calls(tc.method); len(calls) != 0 {
+					t.Errorf("unexpected calls to %s: %v", tc.method, calls)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			calls := srv.Calls(tc.method)
+			if len(calls) != 1 {
+				t.Fatalf("unexpected number of calls to %s: %d", tc.method, len(calls))
+			}
+			if v := calls[0].Values; v.Get("channel") != tc.ch || v.Get("ts") != tc.ts {
+				t.Errorf("unexpected call: %v", v)
+			}
+		})
+	}
+}
+
+// handle passes the envelope to the Sender as if it had been taken off the
+// queue.
+func handle(t *testing.T, s *Sender, e messaging.Envelope) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	evt := queue.SQSEvent{Records: []events.SQSMessage{{Body: string(body)}}}
+	if err := s.Handle(context.Background(), evt); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestHandleNotSentYet(t *testing.T) {
+	reply := messaging.Envelope{
+		Destination: messaging.Address{TeamID: "T1", ChannelID: "G1", ThreadKey: "report:r1"},
+		Message:     messaging.Message{Text: "hello"},
+		Delivery:    messaging.DeliveryThread,
+	}
+	update := messaging.Envelope{Destination: messaging.Address{TeamID: "T1"}, Operation: messaging.OperationUpdate, Key: "report:r1"}
+
+	tcs := []struct {
+		name   string
+		e      messaging.Envelope
+		method string
+		sent   bool
+	}{
+		{name: "reply", e: reply, method: "chat.postMessage", sent: true},
+		{name: "update", e: update, method: "chat.update"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			s, srv, _ := setup(t)
+			defer srv.Close()
+			out := s.out.(*slacktest.Queue)
+
+			// Envelopes referring to a message that hasn't been sent are
+			// placed back on the queue, rather than relying on the queue to
+			// deliver them again, until we have tried maxAttempts times.
+			e := tc.e
+			for i := 1; i < maxAttempts; i++ {
+				out.Reset()
+				handle(t, s, e)
+				if len(out.Envelopes) != 1 || out.Envelopes[0].Attempts != i {
+					t.Fatalf("attempt %d: unexpected messages queued: %+v", i, out.Envelopes)
+				}
+				if calls := srv.Calls(""); len(calls) != 0 {
+					t.Fatalf("attempt %d: unexpected calls to Slack: %v", i, calls)
+				}
+				e = out.Envelopes[0]
+			}
+
+			// The message still hasn't been sent, so replies are posted
+			// without a thread and anything else is dropped.
+			out.Reset()
+			handle(t, s, e)
+			if len(out.Envelopes) != 0 {
+				t.Error("unexpected messages queued:", out.Envelopes)
+			}
+			calls := srv.Calls(tc.method)
+			if sent := len(calls) == 1; sent != tc.sent {
+				t.Fatalf("unexpected calls to %s: %v", tc.method, calls)
+			}
+			if tc.sent && (calls[0].Values.Get("channel") != "G1" || calls[0].Values.Get("thread_ts") != "") {
+				t.Errorf("unexpected message sent: %v", calls[0].Values)
+			}
+		})
+	}
+}
+
+func TestHandleSentLater(t *testing.T) {
+	s, srv, _ := setup(t)
+	defer srv.Close()
+	out := s.out.(*slacktest.Queue)
+
+	reply := messaging.Envelope{
+		Destination: messaging.Address{TeamID: "T1", ChannelID: "G1", ThreadKey: "report:r1"},
+		Message:     messaging.Message{Text: "hello"},
+		Delivery:    messaging.DeliveryThread,
+	}
+	handle(t, s, reply)
+	if len(out.Envelopes) != 1 {
+		t.Fatal("reply not placed back on the queue:", out.Envelopes)
+	}
+
+	// The reply is sent in the thread once the message has been sent.
+	sent := messaging.Sent{TeamID: "T1", Key: "report:r1", ChannelID: "G1", Ts: "1.000001"}
+	if err := messaging.SaveSent(s.data, sent); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	handle(t, s, out.Envelopes[0])
+
+	calls := srv.Calls("chat.postMessage")
+	if len(calls) != 1 || calls[0].Values.Get("thread_ts") != "1.000001" {
+		t.Errorf("reply not sent in the thread: %v", calls)
+	}
+}
+
+func TestSendResponseURL(t *testing.T) {
+	s, srv, _ := setup(t)
+	defer srv.Close()
+
+	tcs := []struct {
+		name string
+		e    messaging.Envelope
+		want string
+	}{
+		{name: "post", e: messaging.Envelope{Message: messaging.Message{Text: "hello"}}, want: `"response_type":"ephemeral"`},
+		{name: "update", e: messaging.Envelope{Operation: messaging.OperationUpdate, Message: messaging.Message{Text: "hello"}}, want: `"replace_original":true`},
+		{name: "delete", e: messaging.Envelope{Operation: messaging.OperationDelete}, want: `"delete_original":true`},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			srv.Reset()
+
+			tc.e.Destination = messaging.Address{TeamID: "T1", ResponseURL: srv.ResponseURL("1")}
+			if err := s.Send(tc.e); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			calls := srv.Calls(slacktest.ResponseMethod)
+			if len(calls) != 1 || strings.Contains(string(calls[0].Body), tc.want) == false {
+				t.Errorf("unexpected calls to the response URL: %v", calls)
+			}
+			if calls := srv.Calls("chat.postMessage"); len(calls) != 0 {
+				t.Errorf("unexpected calls to chat.postMessage: %v", calls)
+			}
+		})
+	}
+}
+
+func TestSendThreadKey(t *testing.T) {
+	s, srv, _ := setup(t)
+	defer srv.Close()
+
+	e := messaging.Envelope{
+		Destination: messaging.Address{TeamID: "T1", ThreadKey: "report:r1"},
+		Message:     messaging.Message{Text: "hello"},
+		Delivery:    messaging.DeliveryThread,
+	}
+	if err := s.Send(e); errors.Cause(err) != ErrNotSentYet {
+		t.Error("expected an error for a thread not sent yet:", err)
+	}
+
+	err := messaging.SaveSent(s.data, messaging.Sent{TeamID: "T1", Key: "report:r1", ChannelID: "G1", Ts: "1.000001"})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	srv.Reset()
+	if err := s.Send(e); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	calls := srv.Calls("chat.postMessage")
+	if len(calls) != 1 {
+		t.Fatalf("unexpected calls to chat.postMessage: %v", calls)
+	}
+	if v := calls[0].Values; v.Get("channel") != "G1" || v.Get("thread_ts") != "1.000001" {
+		t.Errorf("unexpected call: %v", v)
+	}
+}
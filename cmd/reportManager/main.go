@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/billglover/bbot/cmd/reportManager/manager"
+	"github.com/billglover/bbot/pkg/queue"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/billglover/bbot/pkg/templates"
+)
+
+func main() {
+	// Some admin actions result in messages being sent on Slack. We send
+	// these by placing messages on a queue for processing. The location of
+	// this queue is stored as an environment variable.
+	sendMessageQ := os.Getenv("SQS_QUEUE_SENDMESSAGE")
+	if sendMessageQ == "" {
+		fmt.Println("ERROR: SQS_QUEUE_SENDMESSAGE environment variable not set")
+		os.Exit(1)
+	}
+
+	// In order to retrieve values from the data store we need to know where
+	// the database is located. The AWS Region and DynamoDB table names are
+	// stored in environment variables. If these are not set the application
+	// is unable to function and so we terminate.
+	region := os.Getenv("BUDDYBOT_REGION")
+	if region == "" {
+		fmt.Println("ERROR: BUDDYBOT_REGION environment variable not set")
+		os.Exit(1)
+	}
+
+	authTable := os.Getenv("BUDDYBOT_AUTH_TABLE")
+	if authTable == "" {
+		fmt.Println("ERROR: BUDDYBOT_AUTH_TABLE environment variable not set")
+		os.Exit(1)
+	}
+
+	reportTable := os.Getenv("BUDDYBOT_REPORT_TABLE")
+	if reportTable == "" {
+		fmt.Println("ERROR: BUDDYBOT_REPORT_TABLE environment variable not set")
+		os.Exit(1)
+	}
+
+	dataTable := os.Getenv("BUDDYBOT_DATA_TABLE")
+	if dataTable == "" {
+		fmt.Println("ERROR: BUDDYBOT_DATA_TABLE environment variable not set")
+		os.Exit(1)
+	}
+
+	// Notifications are rendered from the templates packaged with the
+	// function. They are parsed once here and reused for every invocation.
+	tmpl, err := templates.Load(templates.DefaultDir)
+	if err != nil {
+		fmt.Println("ERROR: unable to load templates:", err)
+		os.Exit(1)
+	}
+
+	q, err := queue.NewSQSQueue(sendMessageQ)
+	if err != nil {
+		fmt.Println("ERROR: unable to determine sendMessage queue:", err)
+		os.Exit(1)
+	}
+
+	tokens := &storage.DynamoDB{Region: region, Table: authTable}
+	reports := &storage.DynamoDB{Region: region, Table: reportTable}
+	data := &storage.DynamoDB{Region: region, Table: dataTable}
+
+	teams, err := slack.NewTeams(tokens)
+	if err != nil {
+		fmt.Println("ERROR: unable to create teams:", err)
+		os.Exit(1)
+	}
+
+	// We tell AWS Lambda to start handling incoming admin actions using our
+	// Manager.
+	mgr, err := manager.New(q, teams, reports, data, manager.Templates(tmpl))
+	if err != nil {
+		fmt.Println("ERROR: unable to create manager:", err)
+		os.Exit(1)
+	}
+	lambda.Start(mgr.Handle)
+}
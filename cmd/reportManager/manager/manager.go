@@ -0,0 +1,374 @@
+/*
+Package manager applies the actions admins take on reports using the buttons
+attached to each report in the admins channel.
+*/
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/queue"
+	"github.com/billglover/bbot/pkg/report"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/standing"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/billglover/bbot/pkg/templates"
+	"github.com/pkg/errors"
+)
+
+// Manager handles admin actions on reports. Messages for Slack are placed on
+// the outbound queue rather than being sent directly.
+type Manager struct {
+	out     queue.Queuer
+	teams   *slack.Teams
+	reports storage.Store
+	data    storage.Store
+	tmpl    *templates.Set
+}
+
+// New takes the outbound message queue, the teams that have installed
+// BuddyBot and the stores holding reports and team data such as settings and
+// returns a pointer to a Manager. It returns an error if any of the options
+// provided can't be applied.
+func New(out queue.Queuer, teams *slack.Teams, reports, data storage.Store, options ...func(*Manager) error) (*Manager, error) {
+	mgr := &Manager{out: out, teams: teams, reports: reports, data: data, tmpl: &templates.Set{}}
+	for _, option := range options {
+		if err := option(mgr); err != nil {
+			return nil, err
+		}
+	}
+	return mgr, nil
+}
+
+// Templates sets the templates used to render notifications. Without them
+// notifications use built in wording.
+func Templates(t *templates.Set) func(*Manager) error {
+	return func(mgr *Manager) error {
+		if t == nil {
+			return errors.New("templates must be provided")
+		}
+		mgr.tmpl = t
+		return nil
+	}
+}
+
+// Handle unmarshals admin actions taken off the reportAction queue and passes
+// them to HandleAction. Submissions of the note modal are passed to AddNote.
+//
+// If an error is returned the message remains on the queue for future
+// processing. For now, we don't return errors opting to log them instead.
+func (mgr *Manager) Handle(ctx context.Context, evt queue.SQSEvent) error {
+	for _, msg := range evt.Records {
+		vs := slack.ViewSubmission{}
+		err := json.Unmarshal([]byte(msg.Body), &vs)
+		if err == nil && vs.Type == slack.InteractionViewSubmission {
+			if err := mgr.AddNote(ctx, vs); err != nil {
+				fmt.Println("ERROR: unable to add note to report:", err)
+			}
+			continue
+		}
+
+		m := slack.MessageAction{}
+		err = json.Unmarshal([]byte(msg.Body), &m)
+		if err != nil {
+			fmt.Println("ERROR: unable to parse admin action:", err)
+			continue
+		}
+
+		if err := mgr.HandleAction(ctx, m); err != nil {
+			fmt.Println("ERROR: unable to handle admin action:", err)
+		}
+	}
+	return nil
+}
+
+// HandleAction takes an admin action on a report and applies it. The stored
+// report is moved on in its lifecycle and the change recorded in its history,
+// an update of the original admin message is queued to reflect the new
+// status and the change is noted in the thread under it. Admins adding a note
+// are shown the note modal instead.
+func (mgr *Manager) HandleAction(ctx context.Context, m slack.MessageAction) error {
+	if len(m.Actions) == 0 {
+		return errors.New("no action provided")
+	}
+	a := m.Actions[0]
+
+	// Slack only accepts the trigger for a few seconds after the button was
+	// clicked so the note modal is opened before anything else is done.
+	if a.Name == report.ActionNote {
+		ws, _, err := mgr.teams.Workspace(m.Team.ID)
+		if err != nil {
+			return err
+		}
+		return mgr.openNote(ws, m)
+	}
+
+	r, err := report.Get(mgr.reports, a.Value)
+	if err != nil {
+		return errors.Wrap(err, "unable to retrieve report")
+	}
+	if r.TeamID != m.Team.ID {
+		return errors.Errorf("report %s does not belong to team %s", r.UID, m.Team.ID)
+	}
+	if a.Name == report.ActionWarn && r.AuthorID == "" {
+		return errors.Errorf("report %s has no author to warn", r.UID)
+	}
+
+	ws, ar, err := mgr.teams.Workspace(r.TeamID)
+	if err != nil {
+		return err
+	}
+
+	// Notifications use the team's wording and link to its Code of Conduct.
+	st, err := settings.Load(mgr.data, ar)
+	if err != nil {
+		return errors.Wrap(err, "unable to fetch team settings")
+	}
+
+	to, ok := actionStatus[a.Name]
+	if ok == false {
+		return errors.Errorf("admin action not supported: %s", a.Name)
+	}
+
+	// Warnings are recorded against the author before the report is resolved
+	// so that the level of the warning noted in its history is the one the
+	// author is given, even if they are warned about another report at the
+	// same time. Authors are only warned once about each report.
+	now := time.Now().UTC()
+	var level standing.Level
+	var warned bool
+	if a.Name == report.ActionWarn {
+		check := r
+		if err := check.Transition(to, now); err != nil {
+			return errors.Wrap(err, "unable to apply admin action")
+		}
+		level, warned = mgr.warn(r, m.User.ID, now)
+	}
+
+	// The report may be changed at the same time, e.g. by someone flagging
+	// the message again, in which case the action is applied to their
+	// changes. If the report can't be resolved the warning is withdrawn.
+	var notice *messaging.Envelope
+	updated, err := report.Update(mgr.reports, r.UID, func(r *report.Report) error {
+		if err := r.Transition(to, now); err != nil {
+			return errors.Wrap(err, "unable to apply admin action")
+		}
+
+		if a.Name == report.ActionWarn {
+			r.Record(report.EventWarned, m.User.ID, level.String(), now)
+		} else {
+			r.Record(report.EventStatus, m.User.ID, "", now)
+		}
+
+		// If the team waits for the admins to confirm a report before telling
+		// the author their message was flagged, the notification is sent now.
+		// Authors being warned are told by the warning instead, and authors of
+		// dismissed reports are never told.
+		notice = nil
+		if r.AuthorNotice != nil {
+			if r.Status.Confirmed() && a.Name != report.ActionWarn {
+				notice = r.AuthorNotice
+			}
+			r.AuthorNotice = nil
+		}
+		return nil
+	})
+	if err != nil {
+		if warned {
+			mgr.withdraw(r)
+		}
+		return err
+	}
+	r = updated
+	d := report.TemplateData(r, ar.TeamName, st.CoCURL)
+
+	var msgs []messaging.Envelope
+	switch a.Name {
+	case report.ActionWarn:
+		msgs = append(msgs, mgr.msgForAuthor(ws, r, st, d, level))
+	case report.ActionEscalate:
+		msgs = append(msgs, msgForEscalation(r, m))
+	}
+	if notice != nil {
+		msgs = append(msgs, *notice)
+	}
+
+	// Keep a record of the change in the thread under the admin message. It
+	// is the message the action was taken on so we already know where the
+	// thread is.
+	txt := statusText(a.Name, m.User.ID, r.Status, level)
+	if notice != nil {
+		txt += " The author has been told their message was flagged."
+	}
+	reply := report.ThreadReply(r, m.Channel.ID, txt)
+	reply.Destination.ThreadTs = string(m.MessageTs)
+	msgs = append(msgs, reply, mgr.msgForUpdate(r, d, m.Channel.ID, string(m.MessageTs)))
+
+	for _, msg := range msgs {
+		h := queue.Headers{"Team": msg.Destination.TeamID}
+		if err := mgr.out.Queue(ctx, h, msg); err != nil {
+			fmt.Println("ERROR: unable to queue message:", err)
+		}
+	}
+
+	fmt.Printf("INFO: report %s is now %s\n", r.UID, r.Status)
+	return nil
+}
+
+// msgForUpdate takes a report, the data for the templates and where the
+// admin message for the report was posted and constructs an update of the
+// admin message to show the report as it is now.
+func (mgr *Manager) msgForUpdate(r report.Report, d templates.Data, channel, ts string) messaging.Envelope {
+	e := messaging.Envelope{
+		Destination: messaging.Address{
+			TeamID:    r.TeamID,
+			ChannelID: channel,
+			Ts:        ts,
+		},
+		Message:   mgr.adminMessage(r, d),
+		Operation: messaging.OperationUpdate,
+	}
+	return e
+}
+
+// actionStatus maps each admin action, other than adding a note, to the
+// status it moves a report to.
+var actionStatus = map[string]report.Status{
+	report.ActionAcknowledge: report.StatusAcknowledged,
+	report.ActionDismiss:     report.StatusDismissed,
+	report.ActionWarn:        report.StatusResolved,
+	report.ActionEscalate:    report.StatusEscalated,
+}
+
+// adminMessage takes a report and the data for the templates and constructs
+// the message shown in the admins channel for the report.
+func (mgr *Manager) adminMessage(r report.Report, d templates.Data) messaging.Message {
+	desc, err := mgr.tmpl.Render(templates.Admins, "", "", d)
+	if err != nil {
+		fmt.Println("ERROR: unable to render message for admins:", err)
+		desc = report.DefaultDescription
+	}
+	return report.AdminMessage(r, desc)
+}
+
+// warnings maps each level of warning to the template used to warn the
+// author and the wording used if the template can't be rendered.
+var warnings = map[standing.Level]struct {
+	template string
+	fallback string
+}{
+	standing.LevelNotice: {templates.Warning, "One of our admins has reviewed a message you posted and found that it does not comply with the Code of Conduct. Please take care to follow the Code of Conduct in future."},
+	standing.LevelFormal: {templates.WarningFormal, "This is a formal warning. One of our admins has reviewed a message you posted and found that it does not comply with the Code of Conduct. You have been warned about a message before. Please make sure you follow the Code of Conduct from now on."},
+	standing.LevelFinal:  {templates.WarningFinal, "This is a final warning. One of our admins has reviewed a message you posted and found that it does not comply with the Code of Conduct. You have already been given a formal warning. The admins may take further action if you don't follow the Code of Conduct from now on."},
+}
+
+// warn takes a report and the admin warning its author and records the
+// warning against the author. It returns the level of the warning, which
+// depends on how many times the author has been warned before, and whether
+// it was recorded. Warning the author about the same report again returns the
+// level of the earlier warning without recording another. If we can't record
+// the warning we still warn the author at the level their standing suggests.
+func (mgr *Manager) warn(r report.Report, admin string, at time.Time) (standing.Level, bool) {
+	level := standing.LevelNotice
+	added := false
+	_, err := standing.Update(mgr.data, r.TeamID, r.AuthorID, func(s *standing.Standing) bool {
+		n := s.Violations()
+		level = s.Warn(r.UID, admin, at)
+		added = s.Violations() > n
+		return added
+	})
+	if err != nil {
+		fmt.Println("ERROR: unable to save author standing:", err)
+		return level, false
+	}
+	return level, added
+}
+
+// withdraw takes a report whose author has been warned and removes the
+// warning from their standing. It is used if the report couldn't be resolved
+// after the warning was recorded.
+func (mgr *Manager) withdraw(r report.Report) {
+	_, err := standing.Update(mgr.data, r.TeamID, r.AuthorID, func(s *standing.Standing) bool {
+		return s.Withdraw(r.UID)
+	})
+	if err != nil {
+		fmt.Println("ERROR: unable to withdraw warning:", err)
+	}
+}
+
+// msgForAuthor takes a report, the team settings, the data for the templates
+// and the level of warning and constructs a direct message warning the user
+// who authored the flagged message, in their own language if we have a
+// template for it.
+func (mgr *Manager) msgForAuthor(ws *slack.Workspace, r report.Report, st settings.Settings, d templates.Data, level standing.Level) messaging.Envelope {
+	locale, err := ws.UserLocale(r.AuthorID)
+	if err != nil {
+		fmt.Println("ERROR: unable to get user locale:", err)
+	}
+
+	// Authors are never told who flagged their message, even by wording the
+	// team has chosen.
+	d.Reporter, d.ReporterID, d.Anonymous = "", "", false
+
+	w := warnings[level]
+	d.Warning = level.String()
+	override, _ := st.Message(settings.MessageWarning)
+	txt, err := mgr.tmpl.Render(w.template, locale, override, d)
+	if err != nil {
+		fmt.Println("ERROR: unable to render warning for author:", err)
+		txt = w.fallback
+		if st.CoCURL != "" {
+			txt += " You can find the Code of Conduct here: " + st.CoCURL
+		}
+	}
+
+	// The author is sent a direct message so that the warning is still there
+	// for them to read after Slack is reloaded.
+	e := messaging.Envelope{
+		Destination: messaging.Address{
+			TeamID: r.TeamID,
+			UserID: r.AuthorID,
+		},
+		Message:  messaging.Message{Text: txt},
+		Delivery: messaging.DeliveryDirect,
+	}
+	return e
+}
+
+// statusText takes the admin action taken on a report, the admin who took it,
+// the new status of the report and, for warnings, the level of the warning
+// and describes the change.
+func statusText(action, user string, status report.Status, level standing.Level) string {
+	if action == report.ActionWarn {
+		return fmt.Sprintf("<@%s> sent the author a *%s* and the report is now *%s*.", user, level, status)
+	}
+	return fmt.Sprintf("<@%s> changed the status of the report to *%s*.", user, status)
+}
+
+// msgForEscalation takes a report and the admin action that escalated it and
+// constructs a message drawing the attention of everyone in the admins
+// channel. Concerns raised with a slash command have no message, so the
+// concern itself is quoted instead.
+func msgForEscalation(r report.Report, m slack.MessageAction) messaging.Envelope {
+	txt := fmt.Sprintf("<!here> <@%s> has escalated the report of a message posted by %s in #%s: %s",
+		m.User.ID, r.AuthorName, r.ChannelName, r.Permalink)
+	if r.AuthorID == "" {
+		txt = fmt.Sprintf("<!here> <@%s> has escalated a concern raised in #%s: %s",
+			m.User.ID, r.ChannelName, r.MessageText)
+	}
+
+	e := messaging.Envelope{
+		Destination: messaging.Address{
+			TeamID:    r.TeamID,
+			ChannelID: m.Channel.ID,
+		},
+		Message:  messaging.Message{Text: txt},
+		Delivery: messaging.DeliveryChannel,
+	}
+	return e
+}
@@ -0,0 +1,503 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/report"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/slack/slacktest"
+	"github.com/billglover/bbot/pkg/standing"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/billglover/bbot/pkg/templates"
+	"github.com/pkg/errors"
+)
+
+// setup returns a Manager using a fake Slack server with a single team
+// installed and an open report, along with the server, outbound queue and
+// report store.
+func setup(t *testing.T) (*Manager, *slacktest.Server, *slacktest.Queue, storage.Store) {
+	srv := slacktest.NewServer()
+	srv.AddUser(slacktest.User{ID: "U2", Name: "author"})
+
+	reports := storage.NewMemory("uid")
+	r := report.Report{UID: "T1:C1:100.0:200.0", TeamID: "T1", ChannelID: "C1", AuthorID: "U2", Status: report.StatusOpen}
+	if err := report.Save(reports, r); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	out := &slacktest.Queue{}
+	mgr, err := New(out, srv.Install("T1"), reports, storage.NewMemory("uid"))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	return mgr, srv, out, reports
+}
+
+// click returns an admin clicking a button on the admin message for the
+// report set up by setup.
+func click(action string) slack.MessageAction {
+	return slack.MessageAction{
+		CallbackID: report.CallbackID,
+		Team:       slack.Team{ID: "T1"},
+		Channel:    slack.Channel{ID: "G0ADMINS"},
+		User:       slack.User{ID: "U1"},
+		MessageTs:  "300.0",
+		TriggerID:  "1234.5678",
+		Actions:    []slack.Action{{Name: action, Value: "T1:C1:100.0:200.0"}},
+	}
+}
+
+// updates returns the updates of admin messages queued by the Manager.
+func updates(out *slacktest.Queue) []messaging.Envelope {
+	var es []messaging.Envelope
+	for _, e := range out.Envelopes {
+		if e.Operation == messaging.OperationUpdate {
+			es = append(es, e)
+		}
+	}
+	return es
+}
+
+func TestHandleAction(t *testing.T) {
+	tcs := []struct {
+		action string
+		team   string
+		status report.Status
+		fails  bool
+	}{
+		{action: report.ActionAcknowledge, team: "T1", status: report.StatusAcknowledged},
+		{action: report.ActionDismiss, team: "T1", status: report.StatusDismissed},
+		{action: report.ActionWarn, team: "T1", status: report.StatusResolved},
+		{action: report.ActionEscalate, team: "T1", status: report.StatusEscalated},
+		{action: "unknown", team: "T1", status: report.StatusOpen, fails: true},
+		{action: report.ActionAcknowledge, team: "T2", status: report.StatusOpen, fails: true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.action+" "+tc.team, func(t *testing.T) {
+			mgr, srv, out, reports := setup(t)
+			defer srv.Close()
+
+			m := click(tc.action)
+			m.Team.ID = tc.team
+			err := mgr.HandleAction(context.Background(), m)
+			if (err != nil) != tc.fails {
+				t.Fatal("unexpected error:", err)
+			}
+
+			r, err := report.Get(reports, "T1:C1:100.0:200.0")
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if r.Status != tc.status {
+				t.Error("unexpected status:", r.Status)
+			}
+
+			if tc.fails {
+				if len(out.Envelopes) != 0 {
+					t.Error("unexpected messages queued:", out.Envelopes)
+				}
+				return
+			}
+
+			// The admin message is updated by the Message Sender rather than
+			// straight away.
+			if len(srv.Calls("chat.update")) != 0 {
+				t.Error("admin message updated directly")
+			}
+			us := updates(out)
+			if len(us) != 1 {
+				t.Fatal("unexpected number of updates queued:", len(us))
+			}
+			if d := us[0].Destination; d.TeamID != "T1" || d.ChannelID != "G0ADMINS" || d.Ts != "300.0" {
+				t.Errorf("unexpected update: %+v", d)
+			}
+		})
+	}
+}
+
+func TestHandleActionHistory(t *testing.T) {
+	mgr, srv, out, reports := setup(t)
+	defer srv.Close()
+
+	for _, a := range []string{report.ActionAcknowledge, report.ActionWarn} {
+		if err := mgr.HandleAction(context.Background(), click(a)); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+
+	r, err := report.Get(reports, "T1:C1:100.0:200.0")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(r.History) != 2 {
+		t.Fatal("unexpected history:", r.History)
+	}
+	if e := r.History[0]; e.Kind != report.EventStatus || e.Status != report.StatusAcknowledged || e.UserID != "U1" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if e := r.History[1]; e.Kind != report.EventWarned || e.Status != report.StatusResolved {
+		t.Errorf("unexpected event: %+v", e)
+	}
+
+	us := updates(out)
+	if len(us) != 2 {
+		t.Fatal("unexpected number of updates queued:", len(us))
+	}
+	if b, _ := json.Marshal(us[1].Message); strings.Contains(string(b), "first notice") == false {
+		t.Error("history not shown on admin message")
+	}
+}
+
+func TestAddNote(t *testing.T) {
+	mgr, srv, out, reports := setup(t)
+	defer srv.Close()
+
+	// Clicking the button opens the modal without changing the report.
+	if err := mgr.HandleAction(context.Background(), click(report.ActionNote)); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	views := srv.Calls("views.open")
+	if len(views) != 1 {
+		t.Fatal("unexpected number of calls to views.open:", len(views))
+	}
+	if all := srv.Calls(""); all[0].Method != "views.open" {
+		t.Error("modal opened after calling", all[0].Method)
+	}
+	opened := struct {
+		View slack.View `json:"view"`
+	}{}
+	if err := json.Unmarshal(views[0].Body, &opened); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if opened.View.CallbackID != report.NoteCallbackID {
+		t.Error("unexpected view opened:", opened.View.CallbackID)
+	}
+	if len(out.Envelopes) != 0 {
+		t.Error("unexpected messages queued when opening the modal")
+	}
+
+	vs := slack.ViewSubmission{
+		Type: slack.InteractionViewSubmission,
+		Team: slack.Team{ID: "T1"},
+		User: slack.User{ID: "U3"},
+		View: slack.View{
+			CallbackID:      report.NoteCallbackID,
+			PrivateMetadata: opened.View.PrivateMetadata,
+			State: &slack.ViewState{Values: map[string]map[string]slack.ViewStateValue{
+				fieldNote: {fieldNote: {Type: "plain_text_input", Value: "Spoke to the author."}},
+			}},
+		},
+	}
+	if err := mgr.AddNote(context.Background(), vs); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	r, err := report.Get(reports, "T1:C1:100.0:200.0")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(r.History) != 1 || r.History[0].Kind != report.EventNote || r.History[0].Text != "Spoke to the author." || r.History[0].UserID != "U3" {
+		t.Errorf("unexpected history: %+v", r.History)
+	}
+	if r.Status != report.StatusOpen {
+		t.Error("unexpected status:", r.Status)
+	}
+
+	if len(out.Envelopes) != 2 {
+		t.Fatal("unexpected number of messages queued:", len(out.Envelopes))
+	}
+	if reply := out.Envelopes[0]; reply.Destination.ThreadTs != "300.0" || strings.Contains(reply.Message.Text, "Spoke to the author.") == false {
+		t.Errorf("unexpected thread reply: %+v", reply)
+	}
+	us := updates(out)
+	if len(us) != 1 || us[0].Destination.ChannelID != "G0ADMINS" || us[0].Destination.Ts != "300.0" {
+		t.Fatal("admin message not updated:", us)
+	}
+	out.Reset()
+
+	// Empty notes aren't recorded.
+	vs.View.State.Values[fieldNote][fieldNote] = slack.ViewStateValue{Value: " "}
+	if err := mgr.AddNote(context.Background(), vs); err == nil {
+		t.Error("expected an error for an empty note")
+	}
+
+	// The report isn't read until the note is submitted so notes can only be
+	// added to reports of the team submitting them.
+	vs.View.State.Values[fieldNote][fieldNote] = slack.ViewStateValue{Value: "Spoke to the author."}
+	vs.Team.ID = "T2"
+	if err := mgr.AddNote(context.Background(), vs); err == nil {
+		t.Error("expected an error adding a note to another team's report")
+	}
+	if len(out.Envelopes) != 0 {
+		t.Error("unexpected messages queued:", out.Envelopes)
+	}
+}
+
+func TestHandleActionClosed(t *testing.T) {
+	mgr, srv, _, reports := setup(t)
+	defer srv.Close()
+
+	if err := mgr.HandleAction(context.Background(), click(report.ActionDismiss)); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := mgr.HandleAction(context.Background(), click(report.ActionAcknowledge)); err == nil {
+		t.Error("expected an error changing a closed report")
+	}
+
+	r, err := report.Get(reports, "T1:C1:100.0:200.0")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(r.History) != 1 {
+		t.Error("unexpected history:", r.History)
+	}
+}
+
+func TestWarnHidesReporter(t *testing.T) {
+	mgr, srv, out, reports := setup(t)
+	defer srv.Close()
+
+	_, err := report.Update(reports, "T1:C1:100.0:200.0", func(r *report.Report) error {
+		r.ReporterID, r.ReporterName = "U3", "reporter"
+		return nil
+	})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// Even wording chosen by the team can't tell the author who flagged their
+	// message.
+	st := settings.Settings{TeamID: "T1", Messages: map[string]string{settings.MessageWarning: "Reported by {{.Reporter}}{{.ReporterID}}."}}
+	if err := settings.Save(mgr.data, st); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if err := mgr.HandleAction(context.Background(), click(report.ActionWarn)); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(out.Envelopes) == 0 || out.Envelopes[0].Message.Text != "Reported by ." {
+		t.Errorf("reporter shown to author: %+v", out.Envelopes)
+	}
+}
+
+func TestWarnEscalates(t *testing.T) {
+	mgr, srv, out, reports := setup(t)
+	defer srv.Close()
+
+	want := []standing.Level{standing.LevelNotice, standing.LevelFormal, standing.LevelFinal, standing.LevelFinal}
+	for i, level := range want {
+		// Each warning is about a different report of a message by the same
+		// author.
+		id := report.ID("T1", "C1", "100.0", fmt.Sprintf("21%d.0", i))
+		r := report.Report{UID: id, TeamID: "T1", ChannelID: "C1", AuthorID: "U2", Status: report.StatusOpen}
+		if err := report.Save(reports, r); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		out.Reset()
+
+		m := click(report.ActionWarn)
+		m.Actions[0].Value = id
+		if err := mgr.HandleAction(context.Background(), m); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+
+		if len(out.Envelopes) != 3 {
+			t.Fatal("unexpected number of messages queued:", len(out.Envelopes))
+		}
+		warning := out.Envelopes[0]
+		if warning.Mode() != messaging.DeliveryDirect || warning.Destination.UserID != "U2" || warning.Destination.ChannelID != "" {
+			t.Errorf("warning %d not sent as a direct message to the author: %+v", i, warning.Destination)
+		}
+		if strings.Contains(out.Envelopes[1].Message.Text, level.String()) == false {
+			t.Errorf("warning %d: level not noted in thread: %s", i, out.Envelopes[1].Message.Text)
+		}
+
+		r, err := report.Get(reports, id)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if len(r.History) != 1 || r.History[0].Kind != report.EventWarned || r.History[0].Text != level.String() {
+			t.Errorf("warning %d: unexpected history: %+v", i, r.History)
+		}
+	}
+
+	s, err := standing.Get(mgr.data, "T1", "U2")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s.Violations() != len(want) {
+		t.Error("unexpected number of violations:", s.Violations())
+	}
+	for i, w := range s.Warnings {
+		if w.Level != want[i] || w.WarnedBy != "U1" {
+			t.Errorf("unexpected warning %d: %+v", i, w)
+		}
+	}
+}
+
+func TestWarnTemplates(t *testing.T) {
+	mgr, srv, out, _ := setup(t)
+	defer srv.Close()
+
+	tmpl, err := templates.Load(filepath.Join("..", "..", "..", templates.DefaultDir))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	mgr.tmpl = tmpl
+
+	// The author has been warned twice before.
+	s := standing.Standing{TeamID: "T1", UserID: "U2"}
+	s.Warn("T1:C1:1.0:2.0", "U1", time.Now())
+	s.Warn("T1:C1:3.0:4.0", "U1", time.Now())
+	if err := standing.Save(mgr.data, s); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if err := mgr.HandleAction(context.Background(), click(report.ActionWarn)); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if strings.HasPrefix(out.Envelopes[0].Message.Text, "This is a final warning.") == false {
+		t.Error("unexpected warning:", out.Envelopes[0].Message.Text)
+	}
+}
+
+func TestDeferredAuthorNotice(t *testing.T) {
+	tcs := []struct {
+		action string
+		sent   bool
+	}{
+		{action: report.ActionAcknowledge, sent: true},
+		{action: report.ActionEscalate, sent: true},
+		{action: report.ActionDismiss},
+		{action: report.ActionWarn},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.action, func(t *testing.T) {
+			mgr, srv, out, reports := setup(t)
+			defer srv.Close()
+
+			notice := messaging.Envelope{
+				Destination: messaging.Address{TeamID: "T1", UserID: "U2"},
+				Message:     messaging.Message{Text: "One of your messages has been flagged."},
+				Delivery:    messaging.DeliveryDirect,
+			}
+			r, err := report.Get(reports, "T1:C1:100.0:200.0")
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			r.AuthorNotice = &notice
+			if err := report.Save(reports, r); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			if err := mgr.HandleAction(context.Background(), click(tc.action)); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			sent := false
+			for _, e := range out.Envelopes {
+				if e.Message.Text == notice.Message.Text {
+					sent = true
+				}
+			}
+			if sent != tc.sent {
+				t.Errorf("unexpected author notification: %t", sent)
+			}
+
+			// The notification is only ever sent once.
+			r, err = report.Get(reports, "T1:C1:100.0:200.0")
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if r.AuthorNotice != nil {
+				t.Error("notification still deferred:", r.AuthorNotice)
+			}
+		})
+	}
+}
+
+func TestConcern(t *testing.T) {
+	mgr, srv, out, reports := setup(t)
+	defer srv.Close()
+
+	// Concerns raised with a slash command have no author.
+	sc := slack.SlashCommand{TeamID: "T1", ChannelID: "C1", ChannelName: "general", UserID: "U3", UserName: "reporter"}
+	r := report.FromCommand(sc, "someone was rude", false, time.Now())
+	if err := report.Save(reports, r); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	m := click(report.ActionWarn)
+	m.Actions[0].Value = r.UID
+	if err := mgr.HandleAction(context.Background(), m); err == nil {
+		t.Error("expected an error warning the author of a concern")
+	}
+	if len(out.Envelopes) != 0 {
+		t.Error("unexpected messages queued:", out.Envelopes)
+	}
+	s, err := standing.Get(mgr.data, "T1", "")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s.Violations() != 0 {
+		t.Error("warning recorded without an author:", s.Warnings)
+	}
+
+	m = click(report.ActionEscalate)
+	m.Actions[0].Value = r.UID
+	if err := mgr.HandleAction(context.Background(), m); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(out.Envelopes) == 0 || out.Envelopes[0].Message.Text != "<!here> <@U1> has escalated a concern raised in #general: someone was rude" {
+		t.Errorf("unexpected escalation: %+v", out.Envelopes)
+	}
+
+	r, err = report.Get(reports, r.UID)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if r.Status != report.StatusEscalated || len(r.History) != 1 || r.History[0].Kind != report.EventStatus {
+		t.Errorf("unexpected report: %s, %+v", r.Status, r.History)
+	}
+}
+
+// unwritable is a store that can be read from but not written to.
+type unwritable struct {
+	storage.Store
+}
+
+func (unwritable) SaveIf(v interface{}, c storage.Condition) error {
+	return errors.New("service unavailable")
+}
+
+func TestWarnWithdrawn(t *testing.T) {
+	mgr, srv, out, reports := setup(t)
+	defer srv.Close()
+
+	// The warning is withdrawn if the report can't be resolved.
+	mgr.reports = unwritable{reports}
+	if err := mgr.HandleAction(context.Background(), click(report.ActionWarn)); err == nil {
+		t.Fatal("expected an error when the report can't be saved")
+	}
+	if len(out.Envelopes) != 0 {
+		t.Error("unexpected messages queued:", out.Envelopes)
+	}
+
+	s, err := standing.Get(mgr.data, "T1", "U2")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s.Violations() != 0 {
+		t.Error("warning not withdrawn:", s.Warnings)
+	}
+}
@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/queue"
+	"github.com/billglover/bbot/pkg/report"
+	"github.com/billglover/bbot/pkg/settings"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/pkg/errors"
+)
+
+// fieldNote is the block ID of the note field in the note modal.
+const fieldNote = "note"
+
+// noteMetadata is kept in the note modal so that we know which report to add
+// the note to, and which admin message to update, once it is submitted.
+type noteMetadata struct {
+	Report    string `json:"report"`
+	ChannelID string `json:"channel"`
+	Ts        string `json:"ts"`
+}
+
+// openNote opens the note modal for the admin who clicked the "Add note"
+// button on the admin message for a report. The report is checked once the
+// note is submitted.
+func (mgr *Manager) openNote(ws *slack.Workspace, m slack.MessageAction) error {
+	md, err := json.Marshal(noteMetadata{Report: m.Actions[0].Value, ChannelID: m.Channel.ID, Ts: string(m.MessageTs)})
+	if err != nil {
+		return errors.Wrap(err, "unable to encode note metadata")
+	}
+
+	v := slack.View{
+		Type:            "modal",
+		CallbackID:      report.NoteCallbackID,
+		Title:           messaging.PlainText("Add note"),
+		Submit:          messaging.PlainText("Save"),
+		Close:           messaging.PlainText("Cancel"),
+		PrivateMetadata: string(md),
+	}
+
+	note := messaging.TextInput(fieldNote, "Note", "", true)
+	note.Optional = false
+	note.Hint = messaging.PlainText("Record what you did or found out. Notes are kept in the history of the report and can't be changed later.")
+	v.Blocks = append(v.Blocks, note)
+
+	_, err = ws.OpenView(m.TriggerID, v)
+	return err
+}
+
+// AddNote takes a submission of the note modal and records the note in the
+// history of the report. An update of the admin message for the report is
+// queued to show the note and the note is repeated in the thread under it.
+func (mgr *Manager) AddNote(ctx context.Context, vs slack.ViewSubmission) error {
+	if vs.View.CallbackID != report.NoteCallbackID {
+		return errors.Errorf("view submission not supported: %s", vs.View.CallbackID)
+	}
+
+	md := noteMetadata{}
+	if err := json.Unmarshal([]byte(vs.View.PrivateMetadata), &md); err != nil {
+		return errors.Wrap(err, "unable to decode note metadata")
+	}
+
+	note := strings.TrimSpace(vs.Value(fieldNote))
+	now := time.Now().UTC()
+	r, err := report.Update(mgr.reports, md.Report, func(r *report.Report) error {
+		if r.TeamID != vs.Team.ID {
+			return errors.Errorf("report %s does not belong to team %s", r.UID, vs.Team.ID)
+		}
+		return r.AddNote(vs.User.ID, note, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	_, ar, err := mgr.teams.Workspace(r.TeamID)
+	if err != nil {
+		return err
+	}
+
+	st, err := settings.Load(mgr.data, ar)
+	if err != nil {
+		return errors.Wrap(err, "unable to fetch team settings")
+	}
+
+	reply := report.ThreadReply(r, md.ChannelID, fmt.Sprintf("<@%s> added a note: %s", vs.User.ID, note))
+	reply.Destination.ThreadTs = md.Ts
+	update := mgr.msgForUpdate(r, report.TemplateData(r, ar.TeamName, st.CoCURL), md.ChannelID, md.Ts)
+	for _, msg := range []messaging.Envelope{reply, update} {
+		h := queue.Headers{"Team": msg.Destination.TeamID}
+		if err := mgr.out.Queue(ctx, h, msg); err != nil {
+			return errors.Wrap(err, "unable to queue message")
+		}
+	}
+
+	fmt.Printf("INFO: note added to report %s\n", r.UID)
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/billglover/bbot/cmd/teamManager/team"
+	"github.com/billglover/bbot/pkg/storage"
+)
+
+func main() {
+	// In order to remove values from the data store we need to know where
+	// the database is located. The AWS Region and DynamoDB table name are stored
+	// in environment variables. If these are not set the application is unable
+	// to function and so we terminate.
+	region := os.Getenv("BUDDYBOT_REGION")
+	if region == "" {
+		fmt.Println("ERROR: BUDDYBOT_REGION environment variable not set")
+		os.Exit(1)
+	}
+
+	authTable := os.Getenv("BUDDYBOT_AUTH_TABLE")
+	if authTable == "" {
+		fmt.Println("ERROR: BUDDYBOT_AUTH_TABLE environment variable not set")
+		os.Exit(1)
+	}
+
+	tokens := &storage.DynamoDB{Region: region, Table: authTable}
+
+	// We tell AWS Lambda to start handling incoming team events using our
+	// Manager.
+	tm := team.New(tokens)
+	lambda.Start(tm.Handle)
+}
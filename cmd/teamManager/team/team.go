@@ -0,0 +1,110 @@
+/*
+Package team keeps the access tokens we hold for each team in step with the
+installation of BuddyBot in the team.
+*/
+package team
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/billglover/bbot/pkg/queue"
+	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// Manager handles events affecting the installation of BuddyBot in a team.
+type Manager struct {
+	tokens storage.Store
+}
+
+// New takes the store holding team tokens and returns a pointer to a
+// Manager.
+func New(tokens storage.Store) *Manager {
+	return &Manager{tokens: tokens}
+}
+
+// Handle unmarshals events taken off the teamEvent queue and passes them to
+// HandleEvent.
+//
+// If an error is returned the message remains on the queue for future
+// processing. For now, we don't return errors opting to log them instead.
+func (tm *Manager) Handle(ctx context.Context, evt queue.SQSEvent) error {
+	for _, msg := range evt.Records {
+		ec := slack.EventCallback{}
+		err := json.Unmarshal([]byte(msg.Body), &ec)
+		if err != nil {
+			fmt.Println("ERROR: unable to parse event:", err)
+			continue
+		}
+
+		if err := tm.HandleEvent(ec); err != nil {
+			fmt.Println("ERROR: unable to handle event:", err)
+		}
+	}
+	return nil
+}
+
+// HandleEvent takes an event affecting the installation of BuddyBot in a team.
+// If BuddyBot has been removed from the team, or the access tokens we hold
+// for the team have been revoked, the tokens are deleted.
+func (tm *Manager) HandleEvent(ec slack.EventCallback) error {
+	et, err := ec.EventType()
+	if err != nil {
+		return err
+	}
+
+	switch et {
+	case slack.EventAppUninstalled:
+		// BuddyBot has been removed so none of the tokens we hold are valid.
+
+	case slack.EventTokensRevoked:
+		e := slack.TokensRevokedEvent{}
+		if err := ec.Decode(&e); err != nil {
+			return err
+		}
+
+		ar, err := secrets.GetTeamTokens(tm.tokens, ec.TeamID)
+		if errors.Cause(err) == secrets.ErrUnknownTeam {
+			fmt.Println("INFO: no tokens held for team:", ec.TeamID)
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to fetch team tokens")
+		}
+
+		if revoked(e, ar) == false {
+			fmt.Println("INFO: revoked tokens are not ones we hold for team:", ec.TeamID)
+			return nil
+		}
+
+	default:
+		return errors.Errorf("event not supported: %s", et)
+	}
+
+	if err := secrets.DeleteTeamTokens(tm.tokens, ec.TeamID); err != nil {
+		return errors.Wrap(err, "unable to delete team tokens")
+	}
+
+	fmt.Printf("INFO: tokens deleted for team %s following %s\n", ec.TeamID, et)
+	return nil
+}
+
+// revoked reports whether any of the tokens we hold for a team are among
+// those that have been revoked.
+func revoked(e slack.TokensRevokedEvent, ar secrets.AuthRecord) bool {
+	for _, u := range e.Tokens.OAuth {
+		if u == ar.UserID {
+			return true
+		}
+	}
+	for _, u := range e.Tokens.Bot {
+		if u == ar.BotUserID {
+			return true
+		}
+	}
+	return false
+}
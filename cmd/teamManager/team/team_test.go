@@ -0,0 +1,81 @@
+package team
+
+import (
+	"testing"
+
+	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/storage"
+)
+
+func TestHandleEvent(t *testing.T) {
+	tcs := []struct {
+		name    string
+		team    string
+		event   string
+		deleted bool
+		fails   bool
+	}{
+		{name: "app uninstalled", team: "T1", event: `{"type":"app_uninstalled"}`, deleted: true},
+		{name: "app uninstalled by unknown team", team: "T2", event: `{"type":"app_uninstalled"}`},
+		{name: "bot token revoked", team: "T1", event: `{"type":"tokens_revoked","tokens":{"bot":["B1"]}}`, deleted: true},
+		{name: "user token revoked", team: "T1", event: `{"type":"tokens_revoked","tokens":{"oauth":["U1"]}}`, deleted: true},
+		{name: "tokens we don't hold revoked", team: "T1", event: `{"type":"tokens_revoked","tokens":{"oauth":["U2"],"bot":["B2"]}}`},
+		{name: "tokens revoked by unknown team", team: "T2", event: `{"type":"tokens_revoked","tokens":{"bot":["B1"]}}`},
+		{name: "unsupported event", team: "T1", event: `{"type":"member_joined_channel"}`, fails: true},
+		{name: "no event", team: "T1", fails: true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens := storage.NewMemory("uid")
+			ar := secrets.AuthRecord{UID: "T1", TeamID: "T1", UserID: "U1", BotUserID: "B1", AccessToken: "xoxp-1", BotAccessToken: "xoxb-1"}
+			if err := secrets.SaveTeamTokens(tokens, ar); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			ec := slack.EventCallback{Type: slack.EventTypeCallback, TeamID: tc.team}
+			if tc.event != "" {
+				ec.Event = []byte(tc.event)
+			}
+
+			err := New(tokens).HandleEvent(ec)
+			if (err != nil) != tc.fails {
+				t.Fatal("unexpected error:", err)
+			}
+
+			// Only the tokens of the team the event is about are ever deleted.
+			_, err = secrets.GetTeamTokens(tokens, "T1")
+			if deleted := err == secrets.ErrUnknownTeam; deleted != tc.deleted {
+				t.Errorf("unexpected tokens held: %v", err)
+			}
+		})
+	}
+}
+
+func TestRevoked(t *testing.T) {
+	ar := secrets.AuthRecord{TeamID: "T1", UserID: "U1", BotUserID: "B1"}
+
+	tcs := []struct {
+		name  string
+		oauth []string
+		bot   []string
+		want  bool
+	}{
+		{name: "user token", oauth: []string{"U1"}, want: true},
+		{name: "bot token", bot: []string{"B2", "B1"}, want: true},
+		{name: "tokens we don't hold", oauth: []string{"U2"}, bot: []string{"B2"}},
+		{name: "no tokens"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			e := slack.TokensRevokedEvent{Type: slack.EventTokensRevoked}
+			e.Tokens.OAuth = tc.oauth
+			e.Tokens.Bot = tc.bot
+			if got := revoked(e, ar); got != tc.want {
+				t.Errorf("got %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
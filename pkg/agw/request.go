@@ -1,49 +1,53 @@
 package agw
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
-	"net/http"
+	"encoding/base64"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/pkg/errors"
 )
 
 // Request is of type APIGatewayProxyRequest
 type Request events.APIGatewayProxyRequest
 
 // IsValid takes a signing key and returns true if the request signature is
-// valid. It returns false in all other cases.
+// valid and the request was sent within the default window. It returns false
+// in all other cases. Use a Validator to find out why a request is invalid.
 func (r *Request) IsValid(key string) bool {
-	if r.HTTPMethod != http.MethodPost {
-		return false
-	}
-
-	ts, ok := r.Headers["X-Slack-Request-Timestamp"]
-	if ok == false {
+	v, err := NewValidator(key)
+	if err != nil {
 		return false
 	}
+	return v.Validate(*r) == nil
+}
 
-	sig, ok := r.Headers["X-Slack-Signature"]
-	if ok == false {
-		return false
+// Header takes a header name and returns its value. Header names are matched
+// without regard to case as API Gateway may change the case of header names.
+// The boolean reports whether the header was present.
+func (r *Request) Header(name string) (string, bool) {
+	if v, ok := r.Headers[name]; ok {
+		return v, true
 	}
-
-	if checkHMAC(r.Body, ts, sig, key) == false {
-		return false
+	for k, v := range r.Headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
 	}
-
-	return true
+	return "", false
 }
 
-// CheckHMAC reports whether msgHMAC is a valid HMAC tag for msg.
-func checkHMAC(body, timestamp, msgHMAC, key string) bool {
-	msgHMAC = msgHMAC[3:]
-	msg := "v0:" + timestamp + ":" + body
-	hash := hmac.New(sha256.New, []byte(key))
-	hash.Write([]byte(msg))
+// DecodedBody returns the body of the request as sent by the client. API
+// Gateway base64 encodes some request bodies and these are decoded. It
+// returns an error if unable to decode the body.
+func (r *Request) DecodedBody() (string, error) {
+	if r.IsBase64Encoded == false {
+		return r.Body, nil
+	}
 
-	expectedKey := hash.Sum(nil)
-	actualKey, _ := hex.DecodeString(msgHMAC)
-	return hmac.Equal(expectedKey, actualKey)
+	b, err := base64.StdEncoding.DecodeString(r.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to decode request body")
+	}
+	return string(b), nil
 }
@@ -47,3 +47,30 @@ func SuccessResponse() (Response, error) {
 	}
 	return resp, nil
 }
+
+// EmptyResponse generates a Response with no body. Slack treats this as an
+// acknowledgement where any body would otherwise be shown to the user.
+func EmptyResponse() (Response, error) {
+	resp := Response{
+		StatusCode: http.StatusOK,
+	}
+	return resp, nil
+}
+
+// ChallengeResponse takes the challenge sent by Slack when verifying an
+// endpoint and generates the Response expected in return.
+func ChallengeResponse(challenge string) (Response, error) {
+	p := struct {
+		Challenge string `json:"challenge"`
+	}{
+		Challenge: challenge,
+	}
+
+	body, _ := json.Marshal(p)
+	resp := Response{
+		Body:       string(body),
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}
+	return resp, nil
+}
@@ -0,0 +1,234 @@
+package agw
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxAge is the age beyond which a signed request is considered stale
+// unless configured otherwise. It matches the window recommended by Slack.
+const DefaultMaxAge = 5 * time.Minute
+
+// Headers used by Slack to sign requests.
+const (
+	HeaderTimestamp = "X-Slack-Request-Timestamp"
+	HeaderSignature = "X-Slack-Signature"
+)
+
+// signers computes the signature of a request for each supported signature
+// version. The version is sent as a prefix to the signature, e.g. "v0=".
+var signers = map[string]func(body, timestamp, key string) []byte{
+	"v0": func(body, timestamp, key string) []byte {
+		hash := hmac.New(sha256.New, []byte(key))
+		hash.Write([]byte("v0:" + timestamp + ":" + body))
+		return hash.Sum(nil)
+	},
+}
+
+// Reasons a request may fail validation, other than those described by
+// MissingHeaderError and VersionError.
+var (
+	ErrMethod             = errors.New("request method must be POST")
+	ErrBadBody            = errors.New("request body could not be decoded")
+	ErrBadTimestamp       = errors.New("request timestamp is not a valid timestamp")
+	ErrStaleTimestamp     = errors.New("request timestamp is outside the allowed window")
+	ErrMalformedSignature = errors.New("request signature is malformed")
+	ErrBadSignature       = errors.New("request signature does not match")
+	ErrReplayed           = errors.New("request signature has already been seen")
+)
+
+// MissingHeaderError is returned when a request lacks a header required to
+// validate it.
+type MissingHeaderError struct {
+	Header string
+}
+
+func (e *MissingHeaderError) Error() string {
+	return fmt.Sprintf("request header missing: %s", e.Header)
+}
+
+// VersionError is returned when a request is signed using a signature version
+// we don't support.
+type VersionError struct {
+	Version string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("request signature version not supported: %q", e.Version)
+}
+
+// Validator checks that requests were signed by Slack and were sent recently.
+// A captured request can't be replayed once it falls outside the allowed
+// window and, if a NonceCache is configured, can't be replayed within it.
+type Validator struct {
+	secret string
+	maxAge time.Duration
+	now    func() time.Time
+	seen   *NonceCache
+}
+
+// NewValidator returns a new Validator using the Slack signing secret
+// provided. It optionally takes configuration functions to modify the
+// default configuration.
+func NewValidator(secret string, options ...func(*Validator) error) (*Validator, error) {
+	v := new(Validator)
+	v.secret = secret
+	v.maxAge = DefaultMaxAge
+	v.now = time.Now
+	for _, option := range options {
+		err := option(v)
+		if err != nil {
+			return v, err
+		}
+	}
+
+	return v, nil
+}
+
+// MaxAge sets the age beyond which requests are rejected as stale.
+func MaxAge(d time.Duration) func(*Validator) error {
+	return func(v *Validator) error {
+		if d <= 0 {
+			return errors.New("maximum request age must be positive")
+		}
+		v.maxAge = d
+		return nil
+	}
+}
+
+// Clock sets the function used to determine the current time.
+func Clock(now func() time.Time) func(*Validator) error {
+	return func(v *Validator) error {
+		v.now = now
+		return nil
+	}
+}
+
+// Nonces sets a cache used to reject signatures that have already been seen.
+func Nonces(c *NonceCache) func(*Validator) error {
+	return func(v *Validator) error {
+		v.seen = c
+		return nil
+	}
+}
+
+// Validate returns nil if the request is signed with the signing secret and
+// its timestamp falls within the allowed window. Otherwise it returns an
+// error describing why the request is invalid.
+func (v *Validator) Validate(r Request) error {
+	if r.HTTPMethod != http.MethodPost {
+		return ErrMethod
+	}
+
+	ts, ok := r.Header(HeaderTimestamp)
+	if ok == false {
+		return &MissingHeaderError{Header: HeaderTimestamp}
+	}
+
+	sig, ok := r.Header(HeaderSignature)
+	if ok == false {
+		return &MissingHeaderError{Header: HeaderSignature}
+	}
+
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrBadTimestamp
+	}
+
+	now := v.now()
+	age := now.Sub(time.Unix(secs, 0))
+	if age > v.maxAge || age < -v.maxAge {
+		return ErrStaleTimestamp
+	}
+
+	body, err := r.DecodedBody()
+	if err != nil {
+		return ErrBadBody
+	}
+
+	mac, err := checkSignature(body, ts, sig, v.secret)
+	if err != nil {
+		return err
+	}
+
+	// Hex is case insensitive so the same signature can be sent in more than
+	// one form. We remember the signature it decodes to rather than the form
+	// it was sent in.
+	if v.seen != nil && v.seen.Seen(hex.EncodeToString(mac), now) {
+		return ErrReplayed
+	}
+
+	return nil
+}
+
+// checkSignature returns the decoded signature if sig is a valid signature of
+// the body and timestamp using the key. Otherwise it returns an error
+// describing why the signature is invalid.
+func checkSignature(body, timestamp, sig, key string) ([]byte, error) {
+	i := strings.Index(sig, "=")
+	if i == -1 {
+		return nil, ErrMalformedSignature
+	}
+
+	version := sig[:i]
+	sign, ok := signers[version]
+	if ok == false {
+		return nil, &VersionError{Version: version}
+	}
+
+	actual, err := hex.DecodeString(sig[i+1:])
+	if err != nil || len(actual) == 0 {
+		return nil, ErrMalformedSignature
+	}
+
+	expected := sign(body, timestamp, key)
+	if hmac.Equal(expected, actual) == false {
+		return nil, ErrBadSignature
+	}
+	return actual, nil
+}
+
+// NonceCache is a short-lived record of request signatures we have seen. It
+// is safe for concurrent use.
+type NonceCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	sigs map[string]time.Time
+}
+
+// NewNonceCache returns a NonceCache that remembers signatures for the
+// duration provided. This should be at least the maximum request age.
+func NewNonceCache(ttl time.Duration) *NonceCache {
+	return &NonceCache{
+		ttl:  ttl,
+		sigs: make(map[string]time.Time),
+	}
+}
+
+// Seen records a signature at the time provided and reports whether it had
+// already been recorded. Expired signatures are forgotten.
+func (c *NonceCache) Seen(sig string, at time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for s, t := range c.sigs {
+		if at.Sub(t) > c.ttl {
+			delete(c.sigs, s)
+		}
+	}
+
+	if _, ok := c.sigs[sig]; ok {
+		return true
+	}
+	c.sigs[sig] = at
+	return false
+}
@@ -0,0 +1,232 @@
+package agw
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sign returns the v0 signature of the body sent at the time given.
+func sign(secret, body string, at time.Time) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	hash := hmac.New(sha256.New, []byte(secret))
+	hash.Write([]byte("v0:" + ts + ":" + body))
+	return "v0=" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// signedRequest returns a request signed with the secret at the time given.
+func signedRequest(secret, body string, at time.Time) Request {
+	return Request{
+		HTTPMethod: http.MethodPost,
+		Body:       body,
+		Headers: map[string]string{
+			"X-Slack-Request-Timestamp": strconv.FormatInt(at.Unix(), 10),
+			"X-Slack-Signature":         sign(secret, body, at),
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := sign("secret", "body", now)
+
+	tcs := []struct {
+		name string
+		req  Request
+		want error
+	}{
+		{
+			name: "valid",
+			req:  signedRequest("secret", "body", now),
+			want: nil,
+		},
+		{
+			name: "lower case headers",
+			req: Request{
+				HTTPMethod: http.MethodPost,
+				Body:       "body",
+				Headers:    map[string]string{"x-slack-request-timestamp": ts, "x-slack-signature": sig},
+			},
+			want: nil,
+		},
+		{
+			name: "base64 encoded body",
+			req: Request{
+				HTTPMethod:      http.MethodPost,
+				Body:            base64.StdEncoding.EncodeToString([]byte("body")),
+				IsBase64Encoded: true,
+				Headers:         map[string]string{"X-Slack-Request-Timestamp": ts, "X-Slack-Signature": sig},
+			},
+			want: nil,
+		},
+		{
+			name: "bad base64 encoded body",
+			req: Request{
+				HTTPMethod:      http.MethodPost,
+				Body:            "!",
+				IsBase64Encoded: true,
+				Headers:         map[string]string{"X-Slack-Request-Timestamp": ts, "X-Slack-Signature": sig},
+			},
+			want: ErrBadBody,
+		},
+		{
+			name: "wrong method",
+			req:  Request{HTTPMethod: http.MethodGet},
+			want: ErrMethod,
+		},
+		{
+			name: "missing timestamp",
+			req: Request{
+				HTTPMethod: http.MethodPost,
+				Headers:    map[string]string{"X-Slack-Signature": sig},
+			},
+			want: &MissingHeaderError{Header: HeaderTimestamp},
+		},
+		{
+			name: "missing signature",
+			req: Request{
+				HTTPMethod: http.MethodPost,
+				Headers:    map[string]string{"X-Slack-Request-Timestamp": ts},
+			},
+			want: &MissingHeaderError{Header: HeaderSignature},
+		},
+		{
+			name: "bad timestamp",
+			req: Request{
+				HTTPMethod: http.MethodPost,
+				Headers:    map[string]string{"X-Slack-Request-Timestamp": "yesterday", "X-Slack-Signature": sig},
+			},
+			want: ErrBadTimestamp,
+		},
+		{
+			name: "stale timestamp",
+			req:  signedRequest("secret", "body", now.Add(-10*time.Minute)),
+			want: ErrStaleTimestamp,
+		},
+		{
+			name: "future timestamp",
+			req:  signedRequest("secret", "body", now.Add(10*time.Minute)),
+			want: ErrStaleTimestamp,
+		},
+		{
+			name: "short signature",
+			req: Request{
+				HTTPMethod: http.MethodPost,
+				Headers:    map[string]string{"X-Slack-Request-Timestamp": ts, "X-Slack-Signature": "v0"},
+			},
+			want: ErrMalformedSignature,
+		},
+		{
+			name: "empty signature",
+			req: Request{
+				HTTPMethod: http.MethodPost,
+				Headers:    map[string]string{"X-Slack-Request-Timestamp": ts, "X-Slack-Signature": "v0="},
+			},
+			want: ErrMalformedSignature,
+		},
+		{
+			name: "signature not hex",
+			req: Request{
+				HTTPMethod: http.MethodPost,
+				Headers:    map[string]string{"X-Slack-Request-Timestamp": ts, "X-Slack-Signature": "v0=xyz"},
+			},
+			want: ErrMalformedSignature,
+		},
+		{
+			name: "unsupported version",
+			req: Request{
+				HTTPMethod: http.MethodPost,
+				Headers:    map[string]string{"X-Slack-Request-Timestamp": ts, "X-Slack-Signature": "v1" + sig[2:]},
+			},
+			want: &VersionError{Version: "v1"},
+		},
+		{
+			name: "signature mismatch",
+			req:  signedRequest("other secret", "body", now),
+			want: ErrBadSignature,
+		},
+		{
+			name: "body altered",
+			req: Request{
+				HTTPMethod: http.MethodPost,
+				Body:       "altered body",
+				Headers:    map[string]string{"X-Slack-Request-Timestamp": ts, "X-Slack-Signature": sig},
+			},
+			want: ErrBadSignature,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := NewValidator("secret", Clock(func() time.Time { return now }))
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			got := v.Validate(tc.req)
+			if reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("unexpected result: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateReplay(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	v, err := NewValidator("secret",
+		Clock(func() time.Time { return now }),
+		Nonces(NewNonceCache(DefaultMaxAge)),
+	)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	req := signedRequest("secret", "body", now)
+	if err := v.Validate(req); err != nil {
+		t.Fatal("unexpected error on first request:", err)
+	}
+	if err := v.Validate(req); err != ErrReplayed {
+		t.Error("unexpected error on replayed request:", err)
+	}
+
+	// Changing the case of the signature doesn't make it a new request.
+	req.Headers["X-Slack-Signature"] = "v0=" + strings.ToUpper(strings.TrimPrefix(req.Headers["X-Slack-Signature"], "v0="))
+	if err := v.Validate(req); err != ErrReplayed {
+		t.Error("unexpected error on replayed request with an upper case signature:", err)
+	}
+}
+
+func TestNonceCacheExpiry(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	c := NewNonceCache(time.Minute)
+
+	if c.Seen("sig", now) {
+		t.Error("signature seen before it was recorded")
+	}
+	if c.Seen("sig", now.Add(30*time.Second)) == false {
+		t.Error("signature not seen within the ttl")
+	}
+	if c.Seen("sig", now.Add(2*time.Minute)) {
+		t.Error("signature seen after the ttl expired")
+	}
+}
+
+func TestHeader(t *testing.T) {
+	r := Request{Headers: map[string]string{"content-type": "application/json"}}
+
+	v, ok := r.Header("Content-Type")
+	if ok == false || v != "application/json" {
+		t.Errorf("unexpected header: %q, %v", v, ok)
+	}
+
+	if _, ok := r.Header("X-Missing"); ok {
+		t.Error("unexpected header found")
+	}
+}
@@ -0,0 +1,241 @@
+package messaging
+
+import "encoding/json"
+
+// The types of Block that can be added to a Message or a modal. Input blocks
+// may only be added to a modal.
+const (
+	BlockSection = "section"
+	BlockContext = "context"
+	BlockDivider = "divider"
+	BlockActions = "actions"
+	BlockInput   = "input"
+)
+
+// The types of Element that can be added to a Block.
+const (
+	ElementButton         = "button"
+	ElementStaticSelect   = "static_select"
+	ElementPlainTextInput = "plain_text_input"
+)
+
+// Block is a Slack Block Kit layout block, used in both messages and modals.
+// Only the fields used by its Type are set:
+//
+// A section shows Text, or Fields in two columns, with an optional Accessory
+// such as a button alongside. A context shows small print from Context. A
+// divider separates the blocks either side of it. An actions block shows the
+// interactive Elements, e.g. buttons. An input block collects a single value
+// in a modal using its Element, shown with a Label and an optional Hint.
+type Block struct {
+	Type      string    `json:"type"`
+	BlockID   string    `json:"block_id,omitempty"`
+	Text      *Text     `json:"text,omitempty"`
+	Fields    []Text    `json:"fields,omitempty"`
+	Accessory *Element  `json:"accessory,omitempty"`
+	Label     *Text     `json:"label,omitempty"`
+	Hint      *Text     `json:"hint,omitempty"`
+	Optional  bool      `json:"optional,omitempty"`
+	Element   *Element  `json:"element,omitempty"`
+	Context   []Text    `json:"-"`
+	Elements  []Element `json:"-"`
+}
+
+// Text is a Block Kit text object. Type is either "mrkdwn" or "plain_text".
+type Text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Element is an interactive Block Kit element. When a user interacts with it
+// the ActionID and Value, or the Value of the Option selected, are sent back
+// to us along with the BlockID of the Block containing it.
+type Element struct {
+	Type          string `json:"type"`
+	ActionID      string `json:"action_id,omitempty"`
+	Text          *Text  `json:"text,omitempty"`
+	Value         string `json:"value,omitempty"`
+	Style         string `json:"style,omitempty"`
+	URL           string `json:"url,omitempty"`
+	Placeholder   *Text  `json:"placeholder,omitempty"`
+	Options       []Opt  `json:"options,omitempty"`
+	InitialOption *Opt   `json:"initial_option,omitempty"`
+	InitialValue  string `json:"initial_value,omitempty"`
+	Multiline     bool   `json:"multiline,omitempty"`
+}
+
+// Opt is an option in a select menu.
+type Opt struct {
+	Text  *Text  `json:"text"`
+	Value string `json:"value"`
+}
+
+// Markdown returns a text object formatted using Slack markdown.
+func Markdown(txt string) *Text {
+	return &Text{Type: "mrkdwn", Text: txt}
+}
+
+// PlainText returns a text object shown exactly as written.
+func PlainText(txt string) *Text {
+	return &Text{Type: "plain_text", Text: txt}
+}
+
+// Section returns a section Block showing the markdown text provided.
+func Section(txt string) Block {
+	return Block{Type: BlockSection, Text: Markdown(txt)}
+}
+
+// Fields returns a section Block showing each of the markdown fields provided
+// in two columns.
+func Fields(fields ...string) Block {
+	b := Block{Type: BlockSection}
+	for _, f := range fields {
+		b.Fields = append(b.Fields, *Markdown(f))
+	}
+	return b
+}
+
+// Context returns a context Block showing the markdown text provided.
+func Context(txt ...string) Block {
+	b := Block{Type: BlockContext}
+	for _, t := range txt {
+		b.Context = append(b.Context, *Markdown(t))
+	}
+	return b
+}
+
+// Divider returns a divider Block.
+func Divider() Block {
+	return Block{Type: BlockDivider}
+}
+
+// Actions returns an actions Block containing the elements provided. The ID
+// is sent back to us when a user interacts with one of the elements.
+func Actions(id string, elements ...Element) Block {
+	return Block{Type: BlockActions, BlockID: id, Elements: elements}
+}
+
+// Button returns a button Element. Style may be empty, "primary" or
+// "danger".
+func Button(actionID, txt, value, style string) Element {
+	return Element{Type: ElementButton, ActionID: actionID, Text: PlainText(txt), Value: value, Style: style}
+}
+
+// Option returns an option for a select menu.
+func Option(txt, value string) Opt {
+	return Opt{Text: PlainText(txt), Value: value}
+}
+
+// StaticSelect returns a select menu Element offering the options provided.
+func StaticSelect(actionID, placeholder string, options ...Opt) Element {
+	return Element{Type: ElementStaticSelect, ActionID: actionID, Placeholder: PlainText(placeholder), Options: options}
+}
+
+// TextInput returns an input Block containing an optional plain text field.
+// The block and the field share the ID provided.
+func TextInput(id, label, initial string, multiline bool) Block {
+	return Block{
+		Type:     BlockInput,
+		BlockID:  id,
+		Label:    PlainText(label),
+		Optional: true,
+		Element: &Element{
+			Type:         ElementPlainTextInput,
+			ActionID:     id,
+			InitialValue: initial,
+			Multiline:    multiline,
+		},
+	}
+}
+
+// SelectInput returns an input Block containing a select menu offering the
+// options provided. The option with the initial value is selected to begin
+// with. The block and the menu share the ID provided.
+func SelectInput(id, label string, options []Opt, initial string) Block {
+	b := Block{
+		Type:    BlockInput,
+		BlockID: id,
+		Label:   PlainText(label),
+		Element: &Element{Type: ElementStaticSelect, ActionID: id, Options: options},
+	}
+	for i, o := range options {
+		if o.Value == initial {
+			b.Element.InitialOption = &options[i]
+		}
+	}
+	return b
+}
+
+// block is a Block as it is represented in JSON. Context and Elements share
+// the elements field so are held as raw JSON until the type is known.
+type block struct {
+	Type      string          `json:"type"`
+	BlockID   string          `json:"block_id,omitempty"`
+	Text      *Text           `json:"text,omitempty"`
+	Fields    []Text          `json:"fields,omitempty"`
+	Accessory *Element        `json:"accessory,omitempty"`
+	Label     *Text           `json:"label,omitempty"`
+	Hint      *Text           `json:"hint,omitempty"`
+	Optional  bool            `json:"optional,omitempty"`
+	Element   *Element        `json:"element,omitempty"`
+	Elements  json.RawMessage `json:"elements,omitempty"`
+}
+
+// MarshalJSON encodes the Block in the form expected by Slack.
+func (b Block) MarshalJSON() ([]byte, error) {
+	raw := block{
+		Type:      b.Type,
+		BlockID:   b.BlockID,
+		Text:      b.Text,
+		Fields:    b.Fields,
+		Accessory: b.Accessory,
+		Label:     b.Label,
+		Hint:      b.Hint,
+		Optional:  b.Optional,
+		Element:   b.Element,
+	}
+
+	var elements interface{}
+	switch {
+	case b.Type == BlockContext && b.Context != nil:
+		elements = b.Context
+	case b.Elements != nil:
+		elements = b.Elements
+	}
+
+	if elements != nil {
+		e, err := json.Marshal(elements)
+		if err != nil {
+			return nil, err
+		}
+		raw.Elements = e
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON decodes a Block encoded by MarshalJSON.
+func (b *Block) UnmarshalJSON(data []byte) error {
+	raw := block{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*b = Block{
+		Type:      raw.Type,
+		BlockID:   raw.BlockID,
+		Text:      raw.Text,
+		Fields:    raw.Fields,
+		Accessory: raw.Accessory,
+		Label:     raw.Label,
+		Hint:      raw.Hint,
+		Optional:  raw.Optional,
+		Element:   raw.Element,
+	}
+
+	if len(raw.Elements) == 0 {
+		return nil
+	}
+	if b.Type == BlockContext {
+		return json.Unmarshal(raw.Elements, &b.Context)
+	}
+	return json.Unmarshal(raw.Elements, &b.Elements)
+}
@@ -1,32 +1,101 @@
 package messaging
 
 // Message is an internal representation of messages that will be sent to a
-// Slack channel or user.
+// Slack channel or user. A message may be laid out using Blocks, in which
+// case Text is only shown in notifications.
 type Message struct {
 	Text        string       `json:"text"`
 	Attachments []Attachment `json:"attachment"`
+	Blocks      []Block      `json:"blocks,omitempty"`
 }
 
-// Envelope provides routing information for a message.
+// The ways in which a message can be delivered.
+const (
+	// DeliveryEphemeral shows the message to the user in the channel. Only they
+	// can see it and it disappears when Slack is reloaded.
+	DeliveryEphemeral = "ephemeral"
+
+	// DeliveryDirect sends the message to the user in a direct message.
+	DeliveryDirect = "direct"
+
+	// DeliveryChannel posts the message in the channel for everyone to see.
+	DeliveryChannel = "channel"
+
+	// DeliveryThread posts the message as a reply in the thread started by
+	// the message identified by the ThreadTs of the Address. If the ThreadTs
+	// isn't known, the message posted with the ThreadKey of the Address is
+	// used instead.
+	DeliveryThread = "thread"
+)
+
+// The operations that can be carried out with a message.
+const (
+	// OperationPost sends a new message. It is the default.
+	OperationPost = "post"
+
+	// OperationUpdate replaces the content of a message sent previously.
+	OperationUpdate = "update"
+
+	// OperationDelete deletes a message sent previously.
+	OperationDelete = "delete"
+)
+
+// Envelope provides routing information for a message. Delivery is one of
+// the Delivery constants and determines which parts of the Destination are
+// used. Operation is one of the Operation constants.
+//
+// When a message is posted with a Key, the channel and timestamp of the
+// message are recorded against it. A message can then be updated or deleted
+// by sending an envelope with the same Key. Alternatively, the message can be
+// identified by the ChannelID and Ts of the Destination, or by a ResponseURL
+// provided by Slack.
 type Envelope struct {
 	Destination Address `json:"destination"`
-	Ephemeral   bool    `json:"ephemeral,omitempty"`
+	Delivery    string  `json:"delivery,omitempty"`
+	Operation   string  `json:"operation,omitempty"`
+	Key         string  `json:"key,omitempty"`
 	Message     Message `json:"message"`
+	Attempts    int     `json:"attempts,omitempty"`
 }
 
 // Address indicates where the message should be sent.
 type Address struct {
-	TeamID    string `json:"team_id"`
-	ChannelID string `json:"channel_id"`
-	UserID    string `json:"user_id"`
+	TeamID      string `json:"team_id"`
+	ChannelID   string `json:"channel_id"`
+	UserID      string `json:"user_id"`
+	ThreadTs    string `json:"thread_ts,omitempty"`
+	ThreadKey   string `json:"thread_key,omitempty"`
+	Ts          string `json:"ts,omitempty"`
+	ResponseURL string `json:"response_url,omitempty"`
+}
+
+// Mode returns how the message should be delivered. If no Delivery has been
+// set it is determined by the Destination: messages addressed to a user in a
+// channel are ephemeral, those addressed to only a channel are posted in it
+// and those addressed to only a user are sent as a direct message.
+func (e Envelope) Mode() string {
+	if e.Delivery != "" {
+		return e.Delivery
+	}
+
+	switch {
+	case e.Destination.UserID != "" && e.Destination.ChannelID != "":
+		return DeliveryEphemeral
+	case e.Destination.UserID != "":
+		return DeliveryDirect
+	default:
+		return DeliveryChannel
+	}
 }
 
 // Attachment is an attachment to a message
 type Attachment struct {
-	Title       string  `json:"title,omitempty"`
-	Description string  `json:"description,omitempty"`
-	TitleLink   string  `json:"title_link,omitempty"`
-	Fields      []Field `json:"fields,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	TitleLink   string   `json:"title_link,omitempty"`
+	Fields      []Field  `json:"fields,omitempty"`
+	CallbackID  string   `json:"callback_id,omitempty"`
+	Actions     []Action `json:"actions,omitempty"`
 }
 
 // Field is a field in an Attachment
@@ -35,3 +104,12 @@ type Field struct {
 	Value string `json:"value,omitempty"`
 	Short bool   `json:"short,omitempty"`
 }
+
+// Action is a button in an Attachment. When clicked, the Name and Value are
+// sent back to us along with the CallbackID of the Attachment.
+type Action struct {
+	Name  string `json:"name"`
+	Text  string `json:"text"`
+	Value string `json:"value,omitempty"`
+	Style string `json:"style,omitempty"`
+}
@@ -0,0 +1,102 @@
+package messaging
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeJSON(t *testing.T) {
+	e := Envelope{
+		Destination: Address{TeamID: "T1", ChannelID: "C1"},
+		Message: Message{
+			Text: "Message Flagged",
+			Blocks: []Block{
+				Section("*Message Flagged*"),
+				Fields("*Author*\n<@U1>", "*Channel*\n<#C1>"),
+				Context("Flagged by <@U2>"),
+				Divider(),
+				Actions("report-1",
+					Button("ack", "Acknowledge", "r1", "primary"),
+					StaticSelect("severity", "Severity", Option("Low", "low"), Option("High", "high")),
+				),
+			},
+		},
+	}
+	e.Message.Blocks[0].Accessory = &Element{Type: ElementButton, ActionID: "view", Text: &Text{Type: "plain_text", Text: "View"}, URL: "https://example.com"}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// Context and actions blocks both hold their content in elements.
+	if strings.Count(string(b), `"elements":`) != 2 {
+		t.Errorf("unexpected encoding: %s", b)
+	}
+
+	got := Envelope{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if reflect.DeepEqual(got, e) == false {
+		t.Errorf("envelope not preserved:\n got: %+v\nwant: %+v", got, e)
+	}
+}
+
+func TestInputBlocksJSON(t *testing.T) {
+	options := []Opt{Option("Low", "low"), Option("High", "high")}
+	blocks := []Block{
+		TextInput("note", "Note", "", true),
+		SelectInput("severity", "Severity", options, "high"),
+	}
+	blocks[0].Hint = PlainText("Anything else?")
+
+	b, err := json.Marshal(blocks)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	got := []Block{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if reflect.DeepEqual(got, blocks) == false {
+		t.Errorf("blocks not preserved:\n got: %+v\nwant: %+v", got, blocks)
+	}
+	if got[1].Element.InitialOption == nil || got[1].Element.InitialOption.Value != "high" {
+		t.Error("unexpected initial option:", got[1].Element.InitialOption)
+	}
+}
+
+func TestEnvelopeJSONWithoutBlocks(t *testing.T) {
+	b, err := json.Marshal(Envelope{Message: Message{Text: "hello"}})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if strings.Contains(string(b), "blocks") {
+		t.Errorf("unexpected blocks: %s", b)
+	}
+}
+
+func TestEnvelopeMode(t *testing.T) {
+	tcs := []struct {
+		name string
+		e    Envelope
+		want string
+	}{
+		{name: "explicit", e: Envelope{Destination: Address{ChannelID: "C1", UserID: "U1"}, Delivery: DeliveryDirect}, want: DeliveryDirect},
+		{name: "user in channel", e: Envelope{Destination: Address{ChannelID: "C1", UserID: "U1"}}, want: DeliveryEphemeral},
+		{name: "user", e: Envelope{Destination: Address{UserID: "U1"}}, want: DeliveryDirect},
+		{name: "channel", e: Envelope{Destination: Address{ChannelID: "C1"}}, want: DeliveryChannel},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.e.Mode(); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package messaging
+
+import (
+	"time"
+
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// Sent records where a message posted with a Key ended up so that it can be
+// updated or deleted later.
+type Sent struct {
+	UID       string    `json:"uid"`
+	TeamID    string    `json:"team_id"`
+	Key       string    `json:"key"`
+	ChannelID string    `json:"channel_id"`
+	Ts        string    `json:"ts"`
+	Sent      time.Time `json:"sent"`
+}
+
+// SentID returns the identifier used to store the record of the message sent
+// to a team with the Key provided.
+func SentID(teamID, key string) string {
+	return "sent:" + teamID + ":" + key
+}
+
+// GetSent takes a Team ID and Key and returns the record of the message sent
+// with them. It returns an error if there is no such message or we are
+// unable to retrieve it.
+func GetSent(db storage.Store, teamID, key string) (Sent, error) {
+	s := Sent{}
+	err := db.Retrieve("uid", SentID(teamID, key), &s)
+	return s, err
+}
+
+// SaveSent takes the record of a message sent and stores it. It returns an
+// error if unable to store the record in the database.
+func SaveSent(db storage.Store, s Sent) error {
+	if s.TeamID == "" || s.Key == "" {
+		return errors.New("sent messages must have a team ID and key")
+	}
+	s.UID = SentID(s.TeamID, s.Key)
+	return db.Save(s)
+}
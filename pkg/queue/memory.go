@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Handler processes messages taken off a queue. It has the same signature as
+// the Lambda functions that consume our SQS queues.
+type Handler func(ctx context.Context, evt SQSEvent) error
+
+// MemoryQueue implements the Queuer interface using an in-process channel. It
+// allows the Lambda functions that consume SQS queues to be run locally.
+type MemoryQueue struct {
+	name string
+	msgs chan events.SQSMessage
+	seq  int64
+}
+
+// NewMemoryQueue takes a name and the number of messages the queue can hold
+// and returns a pointer to a MemoryQueue.
+func NewMemoryQueue(name string, size int) *MemoryQueue {
+	return &MemoryQueue{
+		name: name,
+		msgs: make(chan events.SQSMessage, size),
+	}
+}
+
+// Queue takes message headers and a body and places it onto the queue. It
+// blocks if the queue is full until there is space or the context is done.
+func (q *MemoryQueue) Queue(ctx context.Context, h Headers, b Body) error {
+	body, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	attributes := make(map[string]events.SQSMessageAttribute)
+	for k, v := range h {
+		v := v
+		attributes[k] = events.SQSMessageAttribute{
+			DataType:    "String",
+			StringValue: &v,
+		}
+	}
+
+	msg := events.SQSMessage{
+		MessageId:         q.name + "-" + strconv.FormatInt(atomic.AddInt64(&q.seq, 1), 10),
+		Body:              string(body),
+		MessageAttributes: attributes,
+		EventSource:       "bbot:memory",
+	}
+
+	select {
+	case q.msgs <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Consume takes messages off the queue one at a time and passes them to the
+// handler until the context is done. Errors returned by the handler are
+// logged and the message is dropped.
+func (q *MemoryQueue) Consume(ctx context.Context, h Handler) {
+	for {
+		select {
+		case msg := <-q.msgs:
+			evt := SQSEvent{Records: []events.SQSMessage{msg}}
+			if err := h(ctx, evt); err != nil {
+				fmt.Printf("ERROR: unable to handle message %s: %v\n", msg.MessageId, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -1 +1,49 @@
 package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	q := NewMemoryQueue("test", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := q.Queue(ctx, Headers{"Team": "T1"}, map[string]string{"text": "hello"})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	got := make(chan SQSEvent, 1)
+	go q.Consume(ctx, func(ctx context.Context, evt SQSEvent) error {
+		got <- evt
+		return nil
+	})
+
+	select {
+	case evt := <-got:
+		if len(evt.Records) != 1 {
+			t.Fatal("unexpected number of records:", len(evt.Records))
+		}
+		msg := evt.Records[0]
+
+		if v := msg.MessageAttributes["Team"].StringValue; v == nil || *v != "T1" {
+			t.Error("unexpected team header:", v)
+		}
+
+		b := map[string]string{}
+		if err := json.Unmarshal([]byte(msg.Body), &b); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if b["text"] != "hello" {
+			t.Error("unexpected body:", msg.Body)
+		}
+
+	case <-ctx.Done():
+		t.Fatal("message not consumed")
+	}
+}
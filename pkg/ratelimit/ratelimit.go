@@ -0,0 +1,130 @@
+/*
+Package ratelimit limits how often something may happen for a team, e.g. how
+many messages one person may flag in an hour. Counts are kept in the data
+store rather than in memory so that the limit holds across every invocation
+of a function, including invocations running at the same time.
+
+Each count covers a fixed window, starting with the first time it happens and
+lasting for the period of the limit. Counts are updated with a conditional
+save so that concurrent updates to the same count are retried rather than
+lost.
+*/
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// attempts is the number of times we try to update a count before giving up.
+// Attempts only fail if another invocation updated the count at the same
+// time.
+const attempts = 5
+
+// Limit is the number of times something may happen within a period. A Limit
+// with a Count of zero or less allows everything.
+type Limit struct {
+	Count  int
+	Period time.Duration
+}
+
+// Window represents the number of times something has happened since the
+// start of the window that we store in the data store. The version is
+// incremented on every update so that we can detect concurrent updates.
+type Window struct {
+	UID     string    `json:"uid"`
+	TeamID  string    `json:"team_id"`
+	Key     string    `json:"key"`
+	Start   time.Time `json:"start"`
+	Count   int       `json:"count"`
+	Version int       `json:"version"`
+}
+
+// ID returns the identifier used to store the Window for a key in a team.
+func ID(teamID, key string) string {
+	return "ratelimit:" + teamID + ":" + key
+}
+
+// Allow takes a Team ID and the key of the thing that is happening, e.g.
+// "reporter:U1", and counts it happening at the time provided. It returns
+// false, without counting it, if it has already happened as many times as the
+// limit allows within the current window. It returns an error if unable to
+// update the count.
+func Allow(db storage.Store, teamID, key string, l Limit, at time.Time) (bool, error) {
+	if l.Count <= 0 {
+		return true, nil
+	}
+
+	for i := 0; i < attempts; i++ {
+		w := Window{}
+		err := db.Retrieve("uid", ID(teamID, key), &w)
+		if err != nil && errors.Cause(err) != storage.ErrNotFound {
+			return false, errors.Wrap(err, "unable to retrieve count")
+		}
+
+		// A new window is started if there isn't one or the current window
+		// has ended.
+		cond := storage.Equal("version", w.Version)
+		switch {
+		case err != nil:
+			cond = storage.Absent("uid")
+			w = Window{UID: ID(teamID, key), TeamID: teamID, Key: key, Start: at}
+		case at.Sub(w.Start) >= l.Period:
+			w.Start, w.Count = at, 0
+		case w.Count >= l.Count:
+			return false, nil
+		}
+
+		w.Count++
+		w.Version++
+		err = db.SaveIf(w, cond)
+		if errors.Cause(err) == storage.ErrConditionFailed {
+			continue
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "unable to save count")
+		}
+		return true, nil
+	}
+	return false, errors.Errorf("unable to update count for %s after %d attempts", key, attempts)
+}
+
+// Refund takes a Team ID, the key of the thing that happened and its limit
+// and uncounts it happening at the time provided, e.g. because it was
+// rejected by another limit after being counted. The time must be the time
+// it was counted at. Nothing is uncounted if the window it was counted in
+// has ended. It returns an error if unable to update the count.
+func Refund(db storage.Store, teamID, key string, l Limit, at time.Time) error {
+	if l.Count <= 0 {
+		return nil
+	}
+
+	for i := 0; i < attempts; i++ {
+		w := Window{}
+		err := db.Retrieve("uid", ID(teamID, key), &w)
+		if errors.Cause(err) == storage.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to retrieve count")
+		}
+		if at.Before(w.Start) || at.Sub(w.Start) >= l.Period || w.Count == 0 {
+			return nil
+		}
+
+		cond := storage.Equal("version", w.Version)
+		w.Count--
+		w.Version++
+		err = db.SaveIf(w, cond)
+		if errors.Cause(err) == storage.ErrConditionFailed {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to save count")
+		}
+		return nil
+	}
+	return errors.Errorf("unable to update count for %s after %d attempts", key, attempts)
+}
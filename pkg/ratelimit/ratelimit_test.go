@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/billglover/bbot/pkg/storage"
+)
+
+func TestAllow(t *testing.T) {
+	db := storage.NewMemory("uid")
+	l := Limit{Count: 3, Period: time.Hour}
+	start := time.Date(2020, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	tcs := []struct {
+		key  string
+		at   time.Time
+		want bool
+	}{
+		{key: "reporter:U1", at: start, want: true},
+		{key: "reporter:U1", at: start.Add(time.Minute), want: true},
+		{key: "reporter:U1", at: start.Add(2 * time.Minute), want: true},
+		{key: "reporter:U1", at: start.Add(3 * time.Minute), want: false},
+		{key: "reporter:U2", at: start.Add(3 * time.Minute), want: true},
+		{key: "reporter:U1", at: start.Add(59 * time.Minute), want: false},
+		{key: "reporter:U1", at: start.Add(time.Hour), want: true},
+		{key: "reporter:U1", at: start.Add(time.Hour + time.Minute), want: true},
+	}
+
+	for i, tc := range tcs {
+		got, err := Allow(db, "T1", tc.key, l, tc.at)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if got != tc.want {
+			t.Errorf("%d: unexpected result for %s at %s: %t", i, tc.key, tc.at.Format(time.Kitchen), got)
+		}
+	}
+
+	// Teams are counted separately.
+	if ok, err := Allow(db, "T2", "reporter:U1", l, start.Add(3*time.Minute)); err != nil || ok == false {
+		t.Errorf("unexpected result for another team: %t, %v", ok, err)
+	}
+}
+
+func TestAllowWithoutLimit(t *testing.T) {
+	db := storage.NewMemory("uid")
+	for i := 0; i < 10; i++ {
+		if ok, err := Allow(db, "T1", "reporter:U1", Limit{}, time.Now()); err != nil || ok == false {
+			t.Fatalf("unexpected result: %t, %v", ok, err)
+		}
+	}
+
+	w := Window{}
+	if err := db.Retrieve("uid", ID("T1", "reporter:U1"), &w); err == nil {
+		t.Error("unexpected count stored:", w)
+	}
+}
+
+func TestAllowConcurrently(t *testing.T) {
+	db := storage.NewMemory("uid")
+	l := Limit{Count: 5, Period: time.Hour}
+	now := time.Now()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := Allow(db, "T1", "reporter:U1", l, now)
+			if err != nil || ok == false {
+				return
+			}
+			mu.Lock()
+			allowed++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	w := Window{}
+	if err := db.Retrieve("uid", ID("T1", "reporter:U1"), &w); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if allowed > l.Count || w.Count != allowed {
+		t.Errorf("unexpected count: %d allowed, %d counted", allowed, w.Count)
+	}
+}
+
+func TestRefund(t *testing.T) {
+	db := storage.NewMemory("uid")
+	l := Limit{Count: 1, Period: time.Hour}
+	start := time.Date(2020, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	// Nothing happens refunding something that was never counted.
+	if err := Refund(db, "T1", "reporter:U1", l, start); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if ok, err := Allow(db, "T1", "reporter:U1", l, start); err != nil || ok == false {
+		t.Fatalf("unexpected result: %t, %v", ok, err)
+	}
+	if err := Refund(db, "T1", "reporter:U1", l, start); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if ok, err := Allow(db, "T1", "reporter:U1", l, start.Add(time.Minute)); err != nil || ok == false {
+		t.Errorf("refund not applied: %t, %v", ok, err)
+	}
+
+	// Refunds don't carry over into a later window.
+	if err := Refund(db, "T1", "reporter:U1", l, start.Add(-time.Minute)); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if ok, err := Allow(db, "T1", "reporter:U1", l, start.Add(2*time.Minute)); err != nil || ok {
+		t.Errorf("refund applied to a later window: %t, %v", ok, err)
+	}
+}
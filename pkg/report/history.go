@@ -0,0 +1,75 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EventKind is the kind of thing that happened to a Report.
+type EventKind string
+
+// Admins may add notes to a report, change its status or warn the author.
+const (
+	EventNote   EventKind = "note"
+	EventStatus EventKind = "status"
+	EventWarned EventKind = "warned"
+)
+
+// Event is an entry in the history of a Report, recording what an admin did
+// and when. Events record the status of the report once the event happened
+// and, for notes, the text of the note or, for warnings, how stern the
+// warning was.
+type Event struct {
+	Kind   EventKind `json:"kind"`
+	UserID string    `json:"user_id"`
+	Status Status    `json:"status,omitempty"`
+	Text   string    `json:"text,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Record adds an event to the history of the Report at the time provided. The
+// history is append only, events are never changed or removed once recorded.
+func (r *Report) Record(kind EventKind, userID, text string, at time.Time) {
+	r.History = append(r.History, Event{Kind: kind, UserID: userID, Status: r.Status, Text: text, At: at})
+	r.Updated = at
+}
+
+// AddNote takes a note an admin has written about the Report and records it in
+// the history at the time provided. It returns an error if the note is empty.
+func (r *Report) AddNote(userID, note string, at time.Time) error {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return errors.New("note must not be empty")
+	}
+	r.Record(EventNote, userID, note, at)
+	return nil
+}
+
+// String describes the event, e.g. "<@U1> changed the status to *resolved*".
+func (e Event) String() string {
+	switch e.Kind {
+	case EventNote:
+		return fmt.Sprintf("<@%s> added a note: %s", e.UserID, e.Text)
+	case EventStatus:
+		return fmt.Sprintf("<@%s> changed the status to *%s*", e.UserID, e.Status)
+	case EventWarned:
+		if e.Text != "" {
+			return fmt.Sprintf("<@%s> sent the author a *%s*", e.UserID, e.Text)
+		}
+		return fmt.Sprintf("<@%s> warned the author", e.UserID)
+	}
+	return fmt.Sprintf("<@%s> %s", e.UserID, e.Kind)
+}
+
+// historyText returns the history of the Report, one event per line with the
+// oldest first, each prefixed with the time it happened.
+func historyText(r Report) string {
+	lines := make([]string, len(r.History))
+	for i, e := range r.History {
+		lines[i] = e.At.UTC().Format("2 Jan 2006 15:04") + " " + e.String()
+	}
+	return strings.Join(lines, "\n")
+}
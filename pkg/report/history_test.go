@@ -0,0 +1,90 @@
+package report
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	r := Report{UID: "T1:C1:100.0:200.0", Status: StatusOpen}
+	at := time.Date(2020, 5, 1, 9, 30, 0, 0, time.UTC)
+
+	if err := r.Transition(StatusAcknowledged, at); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	r.Record(EventStatus, "U1", "", at)
+	if err := r.AddNote("U2", "  Spoke to the author.  ", at.Add(time.Hour)); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := r.Transition(StatusResolved, at.Add(2*time.Hour)); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	r.Record(EventWarned, "U1", "", at.Add(2*time.Hour))
+
+	if len(r.History) != 3 {
+		t.Fatal("unexpected history:", r.History)
+	}
+	if e := r.History[0]; e.Kind != EventStatus || e.Status != StatusAcknowledged || e.UserID != "U1" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if e := r.History[1]; e.Kind != EventNote || e.Text != "Spoke to the author." || e.Status != StatusAcknowledged {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if e := r.History[2]; e.Kind != EventWarned || e.Status != StatusResolved {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if r.Updated != at.Add(2*time.Hour) {
+		t.Error("unexpected update time:", r.Updated)
+	}
+
+	if err := r.AddNote("U2", " ", at); err == nil {
+		t.Error("expected an error for an empty note")
+	}
+	if len(r.History) != 3 {
+		t.Error("empty note recorded:", r.History)
+	}
+
+	want := []string{
+		"1 May 2020 09:30 <@U1> changed the status to *acknowledged*",
+		"1 May 2020 10:30 <@U2> added a note: Spoke to the author.",
+		"1 May 2020 11:30 <@U1> warned the author",
+	}
+	if got := historyText(r); got != strings.Join(want, "\n") {
+		t.Errorf("unexpected history:\n%s", got)
+	}
+}
+
+func TestAdminMessageHistory(t *testing.T) {
+	r := Report{UID: "T1:C1:100.0:200.0", AuthorID: "U3", Status: StatusOpen}
+	a := AdminMessage(r, "").Attachments[0]
+	for _, f := range a.Fields {
+		if f.Name == "history" {
+			t.Error("history shown without any events:", f.Value)
+		}
+	}
+	if len(a.Actions) != 5 || a.Actions[4].Name != ActionNote {
+		t.Error("unexpected actions:", a.Actions)
+	}
+
+	// Admins can still add notes once the report is closed.
+	at := time.Now()
+	if err := r.Transition(StatusDismissed, at); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	r.Record(EventStatus, "U1", "", at)
+
+	a = AdminMessage(r, "").Attachments[0]
+	if len(a.Actions) != 1 || a.Actions[0].Name != ActionNote || a.CallbackID != CallbackID {
+		t.Error("unexpected actions:", a.CallbackID, a.Actions)
+	}
+	shown := false
+	for _, f := range a.Fields {
+		if f.Name == "history" && strings.Contains(f.Value, "*dismissed*") {
+			shown = true
+		}
+	}
+	if shown == false {
+		t.Error("history not shown:", a.Fields)
+	}
+}
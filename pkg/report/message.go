@@ -0,0 +1,164 @@
+package report
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/templates"
+)
+
+// Callback IDs of the message actions used to flag a message. Both are
+// handled by msgFlagger.
+const (
+	FlagCallbackID            = "flagMessage"
+	FlagAnonymouslyCallbackID = "flagMessageAnonymously"
+)
+
+// DetailsCallbackID identifies the modal reporters use to add details to a
+// flag and its submissions.
+const DetailsCallbackID = "flagDetails"
+
+// CallbackID identifies the admin actions on a report when Slack sends us a
+// button click.
+const CallbackID = "reportAction"
+
+// NoteCallbackID identifies the modal admins use to add a note to a report and
+// its submissions.
+const NoteCallbackID = "reportNote"
+
+// Actions admins can take on a report from the admins channel. Each is sent
+// back to us as the name of the button clicked with the report ID as its
+// value.
+const (
+	ActionAcknowledge = "acknowledge"
+	ActionDismiss     = "dismiss"
+	ActionWarn        = "warn"
+	ActionEscalate    = "escalate"
+	ActionNote        = "note"
+)
+
+// DefaultDescription introduces a report in the admins channel if no other
+// description is provided.
+const DefaultDescription = "The following message has been flagged for a potential Code of Conduct violation."
+
+// AdminMessageKey takes a report ID and returns the key the admin message for
+// the report is sent with. The key allows the message to be changed later.
+func AdminMessageKey(id string) string {
+	return "report:" + id
+}
+
+// AdminMessage takes a Report and a description introducing it and constructs
+// the message posted to the admins channel. If the description is empty the
+// DefaultDescription is used. Everyone who has flagged the message is listed,
+// along with the number of flags if there has been more than one and any
+// details the reporters have added and the history of what admins have done.
+// Buttons allowing admins to act on the report are included until the report
+// is closed, after which admins can still add notes.
+func AdminMessage(r Report, description string) messaging.Message {
+	if description == "" {
+		description = DefaultDescription
+	}
+
+	a := messaging.Attachment{
+		Title:       "Message Flagged",
+		TitleLink:   r.Permalink,
+		Description: description,
+		Fields: []messaging.Field{
+			{Name: "message", Value: r.MessageText, Short: false},
+			{Name: "reporter", Value: strings.Join(r.Reporters(), ", "), Short: true},
+			{Name: "author", Value: r.AuthorName, Short: true},
+			{Name: "channel", Value: r.ChannelName, Short: true},
+			{Name: "status", Value: string(r.Status), Short: true},
+		},
+	}
+
+	if n := r.Count(); n > 1 {
+		a.Fields = append(a.Fields, messaging.Field{Name: "flags", Value: strconv.Itoa(n), Short: true})
+	}
+	if cs := r.Categories(); len(cs) > 0 {
+		a.Fields = append(a.Fields, messaging.Field{Name: "category", Value: strings.Join(cs, ", "), Short: true})
+	}
+	if sev := r.Severity(); sev != "" {
+		a.Fields = append(a.Fields, messaging.Field{Name: "severity", Value: sev, Short: true})
+	}
+	if notes := notes(r); notes != "" {
+		a.Fields = append(a.Fields, messaging.Field{Name: "notes", Value: notes, Short: false})
+	}
+
+	if history := historyText(r); history != "" {
+		a.Fields = append(a.Fields, messaging.Field{Name: "history", Value: history, Short: false})
+	}
+
+	// Concerns raised with a slash command have no author to warn.
+	a.CallbackID = CallbackID
+	if r.Status.Closed() == false {
+		a.Actions = []messaging.Action{
+			{Name: ActionAcknowledge, Text: "Acknowledge", Value: r.UID, Style: "primary"},
+			{Name: ActionDismiss, Text: "Dismiss", Value: r.UID},
+		}
+		if r.AuthorID != "" {
+			a.Actions = append(a.Actions, messaging.Action{Name: ActionWarn, Text: "Warn author", Value: r.UID})
+		}
+		a.Actions = append(a.Actions, messaging.Action{Name: ActionEscalate, Text: "Escalate", Value: r.UID, Style: "danger"})
+	}
+	a.Actions = append(a.Actions, messaging.Action{Name: ActionNote, Text: "Add note", Value: r.UID})
+
+	return messaging.Message{Attachments: []messaging.Attachment{a}}
+}
+
+// notes returns the notes reporters have added to their flags, one per line,
+// each with the name of the reporter unless they flagged anonymously.
+func notes(r Report) string {
+	var lines []string
+	for _, f := range r.Flags {
+		if f.Note == "" {
+			continue
+		}
+		name := f.ReporterName
+		if f.Anonymous {
+			name = AnonymousReporter
+		}
+		lines = append(lines, name+": "+f.Note)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ThreadReply takes a Report, the admins channel and the text of an update on
+// the report and constructs a message that replies in the thread under the
+// admin message for the report. Where the admin message was posted is looked
+// up when the reply is sent, so the reply can be constructed before the admin
+// message has been sent.
+func ThreadReply(r Report, channel, txt string) messaging.Envelope {
+	return messaging.Envelope{
+		Destination: messaging.Address{
+			TeamID:    r.TeamID,
+			ChannelID: channel,
+			ThreadKey: AdminMessageKey(r.UID),
+		},
+		Message:  messaging.Message{Text: txt},
+		Delivery: messaging.DeliveryThread,
+	}
+}
+
+// TemplateData takes a Report along with the name of the team and the link to
+// its Code of Conduct and returns the data used to render notifications about
+// the report. If the report was flagged anonymously the reporter is hidden.
+func TemplateData(r Report, team, cocURL string) templates.Data {
+	d := templates.Data{
+		Team:       team,
+		Reporter:   r.ReporterName,
+		ReporterID: r.ReporterID,
+		Author:     r.AuthorName,
+		AuthorID:   r.AuthorID,
+		Channel:    r.ChannelName,
+		ChannelID:  r.ChannelID,
+		Permalink:  r.Permalink,
+		CoCURL:     cocURL,
+	}
+	if r.Anonymous() {
+		d.Reporter, d.ReporterID = AnonymousReporter, ""
+		d.Anonymous = true
+	}
+	return d
+}
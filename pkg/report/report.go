@@ -0,0 +1,491 @@
+/*
+Package report provides a record of every message that has been flagged for a
+potential Code of Conduct violation. Each flag is stored as a Report which
+tracks the people involved and moves through a simple lifecycle as admins
+deal with it.
+*/
+package report
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// Status is the stage a Report has reached in its lifecycle.
+type Status string
+
+// A Report starts out open. Admins may acknowledge it while they investigate
+// or escalate it for wider attention before either resolving or dismissing
+// it. Resolved and dismissed reports are closed and cannot change status
+// again.
+const (
+	StatusOpen         Status = "open"
+	StatusAcknowledged Status = "acknowledged"
+	StatusEscalated    Status = "escalated"
+	StatusResolved     Status = "resolved"
+	StatusDismissed    Status = "dismissed"
+)
+
+// transitions lists the statuses a Report may move to from each status.
+var transitions = map[Status][]Status{
+	StatusOpen:         {StatusAcknowledged, StatusEscalated, StatusResolved, StatusDismissed},
+	StatusAcknowledged: {StatusEscalated, StatusResolved, StatusDismissed},
+	StatusEscalated:    {StatusResolved, StatusDismissed},
+}
+
+// Closed reports whether no further status changes are allowed.
+func (s Status) Closed() bool {
+	return len(transitions[s]) == 0
+}
+
+// Confirmed reports whether an admin has confirmed the report is genuine by
+// acknowledging, escalating or resolving it.
+func (s Status) Confirmed() bool {
+	return s == StatusAcknowledged || s == StatusEscalated || s == StatusResolved
+}
+
+// attempts is the number of times we try to update a report before giving up.
+// Attempts only fail if the report was changed at the same time, e.g. by
+// someone flagging the message while an admin acts on its report.
+const attempts = 5
+
+// Report represents a flagged message that we store in the data store. If the
+// team waits for an admin to confirm the report before telling the author
+// their message has been flagged, the notification is kept in AuthorNotice
+// until then. The version is incremented every time the report is saved so
+// that concurrent changes are detected rather than lost.
+type Report struct {
+	UID          string              `json:"uid"`
+	TeamID       string              `json:"team_id"`
+	ChannelID    string              `json:"channel_id"`
+	ChannelName  string              `json:"channel_name"`
+	MessageTs    string              `json:"message_ts"`
+	MessageText  string              `json:"message_text"`
+	ReporterID   string              `json:"reporter_id"`
+	ReporterName string              `json:"reporter_name"`
+	AuthorID     string              `json:"author_id"`
+	AuthorName   string              `json:"author_name"`
+	Permalink    string              `json:"permalink"`
+	Flags        []Flag              `json:"flags,omitempty"`
+	History      []Event             `json:"history,omitempty"`
+	AuthorNotice *messaging.Envelope `json:"author_notice,omitempty"`
+	Status       Status              `json:"status"`
+	Created      time.Time           `json:"created"`
+	Updated      time.Time           `json:"updated"`
+	Version      int                 `json:"version,omitempty"`
+}
+
+// Flag records someone flagging the message in a Report. A message flagged
+// again while its report is open is added to the same report, so the first
+// flag is the one recorded in the ReporterID and ReporterName of the report.
+// Reporters may add a category, severity and note once they have flagged the
+// message.
+//
+// The reporter of an anonymous flag is recorded but not shown to the admins.
+type Flag struct {
+	ReporterID   string    `json:"reporter_id"`
+	ReporterName string    `json:"reporter_name"`
+	Anonymous    bool      `json:"anonymous,omitempty"`
+	Category     string    `json:"category,omitempty"`
+	Severity     string    `json:"severity,omitempty"`
+	Note         string    `json:"note,omitempty"`
+	Flagged      time.Time `json:"flagged"`
+}
+
+// Categories reporters can choose from to describe why they flagged a
+// message.
+var Categories = []string{"Harassment", "Spam", "Hate speech", "Other"}
+
+// Severities reporters can choose from, least severe first.
+var Severities = []string{"Low", "Medium", "High"}
+
+// AnonymousReporter is shown to admins in place of the name of someone who
+// flagged a message anonymously.
+const AnonymousReporter = "someone anonymous"
+
+// New takes a message action and returns an open Report created at the time
+// provided. If anonymous is true the reporter isn't shown to the admins.
+// Author name and permalink are not part of the message action and must be
+// filled in by the caller.
+func New(m slack.MessageAction, anonymous bool, at time.Time) Report {
+	r := Report{
+		TeamID:       m.Team.ID,
+		ChannelID:    m.Channel.ID,
+		ChannelName:  m.Channel.Name,
+		MessageTs:    string(m.MessageTs),
+		MessageText:  m.Message.Text,
+		ReporterID:   m.User.ID,
+		ReporterName: m.User.Name,
+		AuthorID:     m.Message.UserID,
+		Status:       StatusOpen,
+		Created:      at,
+		Updated:      at,
+	}
+	r.UID = ID(r.TeamID, r.ChannelID, r.MessageTs, string(m.ActionTs))
+	r.AddFlag(m, anonymous, at)
+	return r
+}
+
+// AddFlag takes a message action flagging the message in the Report and adds
+// it to the report at the time provided. If anonymous is true the reporter
+// isn't shown to the admins.
+func (r *Report) AddFlag(m slack.MessageAction, anonymous bool, at time.Time) {
+	r.Flags = append(r.Flags, Flag{ReporterID: m.User.ID, ReporterName: m.User.Name, Anonymous: anonymous, Flagged: at})
+	r.Updated = at
+}
+
+// AddDetails takes the category, severity and note a reporter has given for
+// their flag and adds them to their most recent flag in the Report at the
+// time provided. Details that are empty are left unchanged. It returns an
+// error if the reporter hasn't flagged the message.
+func (r *Report) AddDetails(reporterID, category, severity, note string, at time.Time) error {
+	for i := len(r.Flags) - 1; i >= 0; i-- {
+		f := &r.Flags[i]
+		if f.ReporterID != reporterID {
+			continue
+		}
+		if category != "" {
+			f.Category = category
+		}
+		if severity != "" {
+			f.Severity = severity
+		}
+		if note != "" {
+			f.Note = note
+		}
+		r.Updated = at
+		return nil
+	}
+	return errors.Errorf("%s has not flagged the message in report %s", reporterID, r.UID)
+}
+
+// Severity returns the most severe of the severities given by reporters. It
+// returns an empty string if none has been given.
+func (r Report) Severity() string {
+	max := -1
+	for _, f := range r.Flags {
+		for i, s := range Severities {
+			if s == f.Severity && i > max {
+				max = i
+			}
+		}
+	}
+	if max < 0 {
+		return ""
+	}
+	return Severities[max]
+}
+
+// Categories returns the categories given by reporters, in the order they
+// were first given.
+func (r Report) Categories() []string {
+	seen := make(map[string]bool)
+	var cs []string
+	for _, f := range r.Flags {
+		if f.Category == "" || seen[f.Category] {
+			continue
+		}
+		seen[f.Category] = true
+		cs = append(cs, f.Category)
+	}
+	return cs
+}
+
+// Count returns the number of times the message in the Report has been
+// flagged. Reports stored before flags were recorded count as one.
+func (r Report) Count() int {
+	if len(r.Flags) == 0 {
+		return 1
+	}
+	return len(r.Flags)
+}
+
+// Reporters returns the names of everyone who has flagged the message in the
+// Report, in the order they first flagged it. Anonymous reporters are listed
+// as the AnonymousReporter.
+func (r Report) Reporters() []string {
+	if len(r.Flags) == 0 {
+		return []string{r.ReporterName}
+	}
+
+	seen := make(map[string]bool, len(r.Flags))
+	var names []string
+	for _, f := range r.Flags {
+		if seen[f.ReporterID] {
+			continue
+		}
+		seen[f.ReporterID] = true
+		if f.Anonymous {
+			names = append(names, AnonymousReporter)
+			continue
+		}
+		names = append(names, f.ReporterName)
+	}
+	return names
+}
+
+// Anonymous reports whether the message in the Report was first flagged
+// anonymously, in which case the ReporterID and ReporterName of the report
+// must not be shown to the admins.
+func (r Report) Anonymous() bool {
+	return len(r.Flags) > 0 && r.Flags[0].Anonymous
+}
+
+// FromCommand takes a slash command used to report a concern and returns an
+// open Report created at the time provided. There is no flagged message so
+// the description provided by the reporter is recorded in its place. If
+// anonymous is true the reporter isn't shown to the admins.
+func FromCommand(sc slack.SlashCommand, description string, anonymous bool, at time.Time) Report {
+	r := Report{
+		TeamID:       sc.TeamID,
+		ChannelID:    sc.ChannelID,
+		ChannelName:  sc.ChannelName,
+		MessageText:  description,
+		ReporterID:   sc.UserID,
+		ReporterName: sc.UserName,
+		Status:       StatusOpen,
+		Created:      at,
+		Updated:      at,
+	}
+	r.Flags = []Flag{{ReporterID: sc.UserID, ReporterName: sc.UserName, Anonymous: anonymous, Flagged: at}}
+	ts := fmt.Sprintf("%d.%06d", at.Unix(), at.Nanosecond()/1000)
+	r.UID = ID(r.TeamID, r.ChannelID, "", ts)
+	return r
+}
+
+// ID returns the identifier used to store a Report. It is built from the team,
+// channel and message timestamp of the flagged message along with the
+// timestamp of the flag itself.
+func ID(team, channel, messageTs, actionTs string) string {
+	return strings.Join([]string{team, channel, messageTs, actionTs}, ":")
+}
+
+// Transition moves the Report to a new status at the time provided. It returns
+// an error if the lifecycle does not allow the change.
+func (r *Report) Transition(to Status, at time.Time) error {
+	for _, s := range transitions[r.Status] {
+		if s == to {
+			r.Status = to
+			r.Updated = at
+			return nil
+		}
+	}
+	return errors.Errorf("report cannot move from %s to %s", r.Status, to)
+}
+
+// Get takes a Report ID and returns the stored Report. It returns an error if
+// unable to retrieve the report.
+func Get(db storage.Store, id string) (Report, error) {
+	r := Report{}
+	err := db.Retrieve("uid", id, &r)
+	return r, err
+}
+
+// Save takes a Report, either new or as returned by Get, and stores it. A
+// report is only stored if it hasn't been changed since it was read. It
+// returns an error with a cause of storage.ErrConditionFailed if it has, and
+// an error if unable to store the report in the database.
+func Save(db storage.Store, r Report) error {
+	if r.UID == "" {
+		return errors.New("report must have an ID")
+	}
+
+	// Reports stored before they were versioned have no version.
+	cond := storage.Absent("version")
+	if r.Version > 0 {
+		cond = storage.Equal("version", r.Version)
+	}
+	r.Version++
+	return db.SaveIf(r, cond)
+}
+
+// Update takes a Report ID and a function that changes the report. The
+// function is given the stored report and the changed report is saved. If
+// the report is changed by someone else before it is saved, the function is
+// called again with their changes. Update returns the report as saved. It
+// returns an error if the function does, or if unable to retrieve or store
+// the report.
+func Update(db storage.Store, id string, change func(*Report) error) (Report, error) {
+	for i := 0; i < attempts; i++ {
+		r, err := Get(db, id)
+		if err != nil {
+			return r, errors.Wrap(err, "unable to retrieve report")
+		}
+
+		if err := change(&r); err != nil {
+			return r, err
+		}
+
+		err = Save(db, r)
+		if errors.Cause(err) == storage.ErrConditionFailed {
+			continue
+		}
+		if err != nil {
+			return r, errors.Wrap(err, "unable to save report")
+		}
+		r.Version++
+		return r, nil
+	}
+	return Report{}, errors.Errorf("unable to update report %s after %d attempts", id, attempts)
+}
+
+// TeamIndex is the name of the index used to query reports by team.
+const TeamIndex = "team_id-index"
+
+// List takes a Team ID and returns all stored reports for the team. It
+// returns an error if unable to retrieve the reports.
+func List(db storage.Store, teamID string) ([]Report, error) {
+	var rs []Report
+	err := db.Query(TeamIndex, "team_id", teamID, &rs)
+	return rs, err
+}
+
+// open points to the report of a message that is still open. There is at
+// most one for each message so that every flag of the message made while its
+// report is open, including flags made at the same time, is added to the same
+// report. It is stored alongside the reports but has no team so isn't listed
+// with them.
+type open struct {
+	UID      string `json:"uid"`
+	ReportID string `json:"report_id"`
+}
+
+// openID returns the identifier used to store the pointer to the open report
+// of a message.
+func openID(teamID, channel, messageTs string) string {
+	return "open:" + strings.Join([]string{teamID, channel, messageTs}, ":")
+}
+
+// FindOpen takes a team, channel and message timestamp and returns the
+// report of the message that is still open, i.e. not closed. It returns false
+// if there is no such report, or an error if unable to retrieve the report.
+func FindOpen(db storage.Store, teamID, channel, messageTs string) (Report, bool, error) {
+	r, _, ok, err := findOpen(db, teamID, channel, messageTs)
+	return r, ok, err
+}
+
+// findOpen returns the open report of a message, as FindOpen does, along
+// with the ID of the report the message last had open, if any.
+func findOpen(db storage.Store, teamID, channel, messageTs string) (Report, string, bool, error) {
+	p := open{}
+	err := db.Retrieve("uid", openID(teamID, channel, messageTs), &p)
+	if errors.Cause(err) == storage.ErrNotFound {
+		r, ok, err := legacyOpen(db, teamID, channel, messageTs)
+		return r, "", ok, err
+	}
+	if err != nil {
+		return Report{}, "", false, errors.Wrap(err, "unable to retrieve open report")
+	}
+
+	r, err := Get(db, p.ReportID)
+	if errors.Cause(err) == storage.ErrNotFound {
+		return Report{}, p.ReportID, false, nil
+	}
+	if err != nil {
+		return Report{}, p.ReportID, false, errors.Wrap(err, "unable to retrieve open report")
+	}
+	return r, p.ReportID, r.Status.Closed() == false, nil
+}
+
+// legacyOpen returns the earliest report of a message that is still open
+// from those stored before open reports were recorded. It returns false if
+// there is no such report, or an error if unable to retrieve the reports for
+// the team.
+func legacyOpen(db storage.Store, teamID, channel, messageTs string) (Report, bool, error) {
+	rs, err := List(db, teamID)
+	if err != nil {
+		return Report{}, false, errors.Wrap(err, "unable to retrieve reports")
+	}
+
+	found := Report{}
+	ok := false
+	for _, r := range rs {
+		if r.ChannelID != channel || r.MessageTs != messageTs || r.Status.Closed() {
+			continue
+		}
+		if ok == false || r.Created.Before(found.Created) {
+			found, ok = r, true
+		}
+	}
+	return found, ok, nil
+}
+
+// File records a flag of a message. If the message has an open report, add
+// is called to add the flag to it and the report is saved. Otherwise the
+// report returned by create is stored as the open report of the message.
+// Flags made at the same time are added to the same report. File returns the
+// report as saved and true if the flag was added to an existing report. It
+// returns an error if unable to store the flag, along with the report the
+// flag would have been added to so that people can still be told about it.
+func File(db storage.Store, teamID, channel, messageTs string, create func() Report, add func(*Report)) (Report, bool, error) {
+	var created *Report
+	newReport := func() Report {
+		if created == nil {
+			r := create()
+			created = &r
+		}
+		created.Version = 0
+		return *created
+	}
+
+	for i := 0; i < attempts; i++ {
+		r, last, ok, err := findOpen(db, teamID, channel, messageTs)
+		if err != nil {
+			return newReport(), false, err
+		}
+
+		if ok {
+			add(&r)
+			err := Save(db, r)
+			if errors.Cause(err) == storage.ErrConditionFailed {
+				continue
+			}
+			if err != nil {
+				return r, true, errors.Wrap(err, "unable to save report")
+			}
+			r.Version++
+
+			// Reports stored before open reports were recorded become the
+			// open report of their message.
+			if last == "" {
+				err := db.SaveIf(open{UID: openID(teamID, channel, messageTs), ReportID: r.UID}, storage.Absent("uid"))
+				if err != nil && errors.Cause(err) != storage.ErrConditionFailed {
+					fmt.Println("ERROR: unable to save open report:", err)
+				}
+			}
+			return r, true, nil
+		}
+
+		// The new report is stored before it is made the open report so that
+		// the open report always exists. If someone else makes their report
+		// the open report first, ours is removed and the flag added to theirs.
+		r = newReport()
+		if err := Save(db, r); err != nil {
+			return r, false, errors.Wrap(err, "unable to save report")
+		}
+
+		cond := storage.Absent("uid")
+		if last != "" {
+			cond = storage.Equal("report_id", last)
+		}
+		err = db.SaveIf(open{UID: openID(teamID, channel, messageTs), ReportID: r.UID}, cond)
+		if errors.Cause(err) == storage.ErrConditionFailed {
+			if err := db.Delete("uid", r.UID); err != nil {
+				return r, false, errors.Wrap(err, "unable to remove duplicate report")
+			}
+			continue
+		}
+		if err != nil {
+			return r, false, errors.Wrap(err, "unable to save open report")
+		}
+		r.Version++
+		return r, false, nil
+	}
+	return newReport(), false, errors.Errorf("unable to file flag of message %s after %d attempts", messageTs, attempts)
+}
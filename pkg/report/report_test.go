@@ -0,0 +1,428 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+func TestNew(t *testing.T) {
+	m := slack.MessageAction{
+		Team:      slack.Team{ID: "T1"},
+		Channel:   slack.Channel{ID: "C1", Name: "general"},
+		User:      slack.User{ID: "U1", Name: "reporter"},
+		ActionTs:  "200.0",
+		MessageTs: "100.0",
+		Message:   slack.Message{UserID: "U2", Text: "hello"},
+	}
+	now := time.Now()
+	r := New(m, false, now)
+
+	if r.UID != "T1:C1:100.0:200.0" {
+		t.Error("unexpected report ID:", r.UID)
+	}
+	if r.Status != StatusOpen {
+		t.Error("unexpected status:", r.Status)
+	}
+	if r.ReporterID != "U1" || r.AuthorID != "U2" {
+		t.Errorf("unexpected reporter or author: %s, %s", r.ReporterID, r.AuthorID)
+	}
+	if r.Created != now || r.Updated != now {
+		t.Error("unexpected timestamps:", r.Created, r.Updated)
+	}
+}
+
+func TestTransition(t *testing.T) {
+	tcs := []struct {
+		name    string
+		from    Status
+		to      Status
+		wantErr bool
+	}{
+		{name: "acknowledge open", from: StatusOpen, to: StatusAcknowledged},
+		{name: "resolve open", from: StatusOpen, to: StatusResolved},
+		{name: "dismiss acknowledged", from: StatusAcknowledged, to: StatusDismissed},
+		{name: "escalate acknowledged", from: StatusAcknowledged, to: StatusEscalated},
+		{name: "resolve escalated", from: StatusEscalated, to: StatusResolved},
+		{name: "acknowledge escalated", from: StatusEscalated, to: StatusAcknowledged, wantErr: true},
+		{name: "escalate resolved", from: StatusResolved, to: StatusEscalated, wantErr: true},
+		{name: "reopen acknowledged", from: StatusAcknowledged, to: StatusOpen, wantErr: true},
+		{name: "reopen resolved", from: StatusResolved, to: StatusOpen, wantErr: true},
+		{name: "dismiss resolved", from: StatusResolved, to: StatusDismissed, wantErr: true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := Report{Status: tc.from}
+			at := time.Now()
+			err := r.Transition(tc.to, at)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.wantErr {
+				if r.Status != tc.from {
+					t.Error("status changed on failed transition:", r.Status)
+				}
+				return
+			}
+			if r.Status != tc.to || r.Updated != at {
+				t.Errorf("unexpected report after transition: %+v", r)
+			}
+		})
+	}
+}
+
+func TestAdminMessage(t *testing.T) {
+	actions := func(r Report) map[string]string {
+		as := map[string]string{}
+		for _, a := range AdminMessage(r, "").Attachments[0].Actions {
+			as[a.Name] = a.Value
+		}
+		return as
+	}
+
+	r := Report{UID: "T1:C1:100.0:200.0", AuthorID: "U2", Status: StatusOpen}
+	as := actions(r)
+	for _, name := range []string{ActionAcknowledge, ActionDismiss, ActionWarn, ActionEscalate} {
+		if v, ok := as[name]; !ok || v != r.UID {
+			t.Errorf("unexpected %s action on an open report: %q", name, v)
+		}
+	}
+
+	r.Status = StatusResolved
+	as = actions(r)
+	for _, name := range []string{ActionAcknowledge, ActionDismiss, ActionWarn, ActionEscalate} {
+		if _, ok := as[name]; ok {
+			t.Errorf("unexpected %s action on a closed report", name)
+		}
+	}
+}
+
+func TestSaveConflict(t *testing.T) {
+	db := storage.NewMemory("uid")
+	if err := Save(db, Report{UID: "r1", TeamID: "T1", Status: StatusOpen}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	r, err := Get(db, "r1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := Save(db, r); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// The report read earlier is out of date once it has been saved.
+	if err := Save(db, r); errors.Cause(err) != storage.ErrConditionFailed {
+		t.Error("expected a conflict saving a stale report:", err)
+	}
+	if err := Save(db, Report{UID: "r1", TeamID: "T1"}); errors.Cause(err) != storage.ErrConditionFailed {
+		t.Error("expected a conflict replacing a report:", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	db := storage.NewMemory("uid")
+	if err := Save(db, Report{UID: "r1", TeamID: "T1", Status: StatusOpen}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// Flags added at the same time are all kept.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m := slack.MessageAction{User: slack.User{ID: fmt.Sprintf("U%d", i)}}
+			_, err := Update(db, "r1", func(r *Report) error {
+				r.AddFlag(m, false, time.Now().UTC())
+				return nil
+			})
+			if err != nil {
+				t.Error("unexpected error:", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	r, err := Get(db, "r1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(r.Flags) != 3 {
+		t.Errorf("unexpected flags: %+v", r.Flags)
+	}
+
+	if _, err := Update(db, "r1", func(r *Report) error { return r.Transition(StatusOpen, time.Now()) }); err == nil {
+		t.Error("expected an error from the change")
+	}
+}
+
+func TestFile(t *testing.T) {
+	db := storage.NewMemory("uid")
+	m := slack.MessageAction{
+		Team:      slack.Team{ID: "T1"},
+		Channel:   slack.Channel{ID: "C1", Name: "general"},
+		User:      slack.User{ID: "U1", Name: "reporter"},
+		ActionTs:  "200.0",
+		MessageTs: "100.0",
+		Message:   slack.Message{UserID: "U2", Text: "hello"},
+	}
+
+	file := func(actionTs string) (Report, bool) {
+		m.ActionTs = json.Number(actionTs)
+		create := func() Report { return New(m, false, time.Now().UTC()) }
+		add := func(r *Report) { r.AddFlag(m, false, time.Now().UTC()) }
+		r, flagged, err := File(db, "T1", "C1", "100.0", create, add)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		return r, flagged
+	}
+
+	first, flagged := file("200.0")
+	if flagged || first.UID != ID("T1", "C1", "100.0", "200.0") {
+		t.Errorf("unexpected first report: %t, %+v", flagged, first)
+	}
+
+	r, flagged := file("201.0")
+	if flagged == false || r.UID != first.UID || len(r.Flags) != 2 {
+		t.Errorf("flag not added to the open report: %t, %+v", flagged, r)
+	}
+
+	// Once the report is closed the message is reported again.
+	if _, err := Update(db, first.UID, func(r *Report) error { return r.Transition(StatusDismissed, time.Now()) }); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	r, flagged = file("202.0")
+	if flagged || r.UID == first.UID {
+		t.Errorf("flag added to a closed report: %t, %+v", flagged, r)
+	}
+	if open, ok, err := FindOpen(db, "T1", "C1", "100.0"); err != nil || ok == false || open.UID != r.UID {
+		t.Errorf("unexpected open report: %t, %v, %+v", ok, err, open)
+	}
+}
+
+func TestFileConcurrent(t *testing.T) {
+	db := storage.NewMemory("uid")
+
+	// Flags made at the same time are added to a single report.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m := slack.MessageAction{
+				Team:      slack.Team{ID: "T1"},
+				Channel:   slack.Channel{ID: "C1"},
+				User:      slack.User{ID: fmt.Sprintf("U%d", i)},
+				ActionTs:  json.Number(fmt.Sprintf("20%d.0", i)),
+				MessageTs: "100.0",
+			}
+			create := func() Report { return New(m, false, time.Now().UTC()) }
+			add := func(r *Report) { r.AddFlag(m, false, time.Now().UTC()) }
+			if _, _, err := File(db, "T1", "C1", "100.0", create, add); err != nil {
+				t.Error("unexpected error:", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	rs, err := List(db, "T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(rs) != 1 || len(rs[0].Flags) != 4 {
+		t.Errorf("unexpected reports: %+v", rs)
+	}
+}
+
+// TestFindOpen checks reports stored before open reports were recorded are
+// still found.
+func TestFindOpen(t *testing.T) {
+	db := storage.NewMemory("uid")
+	now := time.Now().UTC()
+
+	rs := []Report{
+		{UID: "r1", TeamID: "T1", ChannelID: "C1", MessageTs: "100.0", Status: StatusResolved, Created: now.Add(-2 * time.Hour)},
+		{UID: "r2", TeamID: "T1", ChannelID: "C1", MessageTs: "100.0", Status: StatusAcknowledged, Created: now.Add(-time.Hour)},
+		{UID: "r3", TeamID: "T1", ChannelID: "C1", MessageTs: "100.0", Status: StatusOpen, Created: now},
+		{UID: "r4", TeamID: "T1", ChannelID: "C2", MessageTs: "100.0", Status: StatusOpen, Created: now.Add(-3 * time.Hour)},
+	}
+	for _, r := range rs {
+		if err := Save(db, r); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+
+	r, ok, err := FindOpen(db, "T1", "C1", "100.0")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if ok == false || r.UID != "r2" {
+		t.Errorf("unexpected report: %t, %+v", ok, r)
+	}
+
+	if _, ok, _ := FindOpen(db, "T1", "C1", "200.0"); ok {
+		t.Error("unexpected report for a message that hasn't been flagged")
+	}
+}
+
+func TestAddFlag(t *testing.T) {
+	m := slack.MessageAction{
+		Team:      slack.Team{ID: "T1"},
+		Channel:   slack.Channel{ID: "C1", Name: "general"},
+		User:      slack.User{ID: "U1", Name: "reporter"},
+		ActionTs:  "200.0",
+		MessageTs: "100.0",
+		Message:   slack.Message{UserID: "U2", Text: "hello"},
+	}
+	r := New(m, false, time.Now())
+
+	flags := []struct {
+		user      slack.User
+		anonymous bool
+	}{
+		{user: slack.User{ID: "U3", Name: "another"}, anonymous: true},
+		{user: slack.User{ID: "U1", Name: "reporter"}},
+		{user: slack.User{ID: "U4", Name: "third"}},
+	}
+	for _, f := range flags {
+		m.User = f.user
+		r.AddFlag(m, f.anonymous, time.Now())
+	}
+
+	if r.Count() != 4 {
+		t.Error("unexpected count:", r.Count())
+	}
+	want := []string{"reporter", AnonymousReporter, "third"}
+	if got := r.Reporters(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Error("unexpected reporters:", got)
+	}
+
+	// The anonymous reporter is still recorded.
+	if r.Flags[1].ReporterID != "U3" || r.Flags[1].Anonymous == false {
+		t.Errorf("unexpected flag: %+v", r.Flags[1])
+	}
+	if r.Anonymous() {
+		t.Error("report first flagged by name is anonymous")
+	}
+
+	// Reports stored before flags were recorded
+	old := Report{ReporterName: "reporter"}
+	if old.Count() != 1 || len(old.Reporters()) != 1 {
+		t.Errorf("unexpected flags for an old report: %d, %v", old.Count(), old.Reporters())
+	}
+}
+
+func TestAddDetails(t *testing.T) {
+	m := slack.MessageAction{
+		Team:      slack.Team{ID: "T1"},
+		Channel:   slack.Channel{ID: "C1", Name: "general"},
+		User:      slack.User{ID: "U1", Name: "reporter"},
+		ActionTs:  "200.0",
+		MessageTs: "100.0",
+		Message:   slack.Message{UserID: "U2", Text: "hello"},
+	}
+	r := New(m, false, time.Now())
+	m.User = slack.User{ID: "U3", Name: "another"}
+	r.AddFlag(m, false, time.Now())
+
+	if r.Severity() != "" || len(r.Categories()) != 0 {
+		t.Errorf("unexpected details before any were given: %s, %v", r.Severity(), r.Categories())
+	}
+
+	details := []struct {
+		user, category, severity, note string
+	}{
+		{user: "U1", category: "Spam", severity: "High", note: "again"},
+		{user: "U3", category: "Harassment", severity: "Low"},
+		{user: "U1", category: "", severity: "", note: "and again"},
+	}
+	for _, d := range details {
+		if err := r.AddDetails(d.user, d.category, d.severity, d.note, time.Now()); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+
+	// Empty details leave earlier ones in place.
+	if f := r.Flags[0]; f.Category != "Spam" || f.Severity != "High" || f.Note != "and again" {
+		t.Errorf("unexpected flag: %+v", f)
+	}
+	if r.Severity() != "High" {
+		t.Error("unexpected severity:", r.Severity())
+	}
+	if got := r.Categories(); strings.Join(got, ",") != "Spam,Harassment" {
+		t.Error("unexpected categories:", got)
+	}
+
+	if err := r.AddDetails("U4", "Spam", "Low", "", time.Now()); err == nil {
+		t.Error("expected an error for someone who hasn't flagged the message")
+	}
+}
+
+func TestTemplateDataAnonymous(t *testing.T) {
+	m := slack.MessageAction{
+		Team:    slack.Team{ID: "T1"},
+		Channel: slack.Channel{ID: "C1", Name: "general"},
+		User:    slack.User{ID: "U1", Name: "reporter"},
+		Message: slack.Message{UserID: "U2", Text: "hello"},
+	}
+
+	r := New(m, true, time.Now())
+	if r.ReporterID != "U1" {
+		t.Error("reporter not recorded:", r.ReporterID)
+	}
+
+	d := TemplateData(r, "team", "")
+	if d.Reporter != AnonymousReporter || d.ReporterID != "" || d.Anonymous == false {
+		t.Errorf("reporter not hidden: %q, %q, %t", d.Reporter, d.ReporterID, d.Anonymous)
+	}
+
+	a := AdminMessage(r, "").Attachments[0]
+	for _, f := range a.Fields {
+		if f.Name == "reporter" && f.Value != AnonymousReporter {
+			t.Error("reporter not hidden from admins:", f.Value)
+		}
+	}
+}
+
+func TestFromCommandAnonymous(t *testing.T) {
+	sc := slack.SlashCommand{TeamID: "T1", ChannelID: "C1", UserID: "U1", UserName: "reporter"}
+
+	for _, anonymous := range []bool{true, false} {
+		r := FromCommand(sc, "something happened", anonymous, time.Now())
+		if r.ReporterID != "U1" || r.Anonymous() != anonymous {
+			t.Errorf("unexpected reporter: %s, %t", r.ReporterID, r.Anonymous())
+		}
+
+		want := "reporter"
+		if anonymous {
+			want = AnonymousReporter
+		}
+		for _, f := range AdminMessage(r, "").Attachments[0].Fields {
+			if f.Name == "reporter" && f.Value != want {
+				t.Errorf("unexpected reporter shown to admins: %s", f.Value)
+			}
+		}
+	}
+}
+
+func TestAdminMessageConcern(t *testing.T) {
+	sc := slack.SlashCommand{TeamID: "T1", ChannelID: "C1", UserID: "U1", UserName: "reporter"}
+	r := FromCommand(sc, "something happened", false, time.Now())
+
+	// Nobody posted a concern so there is nobody to warn.
+	for _, a := range AdminMessage(r, "").Attachments[0].Actions {
+		if a.Name == ActionWarn {
+			t.Error("admins offered to warn the author of a concern")
+		}
+	}
+}
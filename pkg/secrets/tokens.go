@@ -1,9 +1,20 @@
 package secrets
 
-import "github.com/billglover/bbot/pkg/storage"
+import (
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownTeam is returned when we hold no access tokens for a team. This
+// happens if the team never installed BuddyBot or has since removed it.
+var ErrUnknownTeam = errors.New("no access tokens held for team")
 
 // AuthRecord represents the access token we store in DynamoDB for
 // every authenticated workspace.
+//
+// The Code of Conduct link and admins channel were once held here and are
+// now part of the team settings. They are only read to copy them to the
+// settings of teams that configured them here.
 type AuthRecord struct {
 	UID            string `json:"uid"`
 	AccessToken    string `json:"access_token"`
@@ -13,22 +24,32 @@ type AuthRecord struct {
 	TeamID         string `json:"team_id"`
 	BotUserID      string `json:"bot_user_id"`
 	BotAccessToken string `json:"bot_access_token"`
-	CoCURL         string `json:"code_of_conduct_URL"`
-	AdminChannel   string `json:"admin_channel"`
+	CoCURL         string `json:"code_of_conduct_URL,omitempty"`
+	AdminChannel   string `json:"admin_channel,omitempty"`
 }
 
 // GetTeamTokens takes a Team ID and returns an AuthRecord containing the
-// access tokens for the team. It returns an error if unable to retrieve
-// the tokens.
-func GetTeamTokens(db *storage.DynamoDB, teamID string) (AuthRecord, error) {
+// access tokens for the team. It returns ErrUnknownTeam if there are no
+// tokens for the team and an error if unable to retrieve the tokens.
+func GetTeamTokens(db storage.Store, teamID string) (AuthRecord, error) {
 	record := AuthRecord{}
 	err := db.Retrieve("uid", teamID, &record)
+	if errors.Cause(err) == storage.ErrNotFound {
+		return record, ErrUnknownTeam
+	}
 	return record, err
 }
 
 // SaveTeamTokens takes an AuthRecord containing the access tokens for a team.
 // It returns an error if unable to store the tokens in the database.
-func SaveTeamTokens(db *storage.DynamoDB, teamTokens AuthRecord) error {
+func SaveTeamTokens(db storage.Store, teamTokens AuthRecord) error {
 	err := db.Save(teamTokens)
 	return err
 }
+
+// DeleteTeamTokens takes a Team ID and removes the access tokens for the
+// team. It returns an error if unable to delete the tokens.
+func DeleteTeamTokens(db storage.Store, teamID string) error {
+	err := db.Delete("uid", teamID)
+	return err
+}
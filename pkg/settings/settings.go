@@ -0,0 +1,253 @@
+/*
+Package settings provides the configuration each team can make to BuddyBot.
+Settings are changed by workspace admins using the `/buddybot config` slash
+command and are read by the functions that act on behalf of the team every
+time they run, so changes take effect immediately.
+*/
+package settings
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// CallbackID identifies the configuration modal and its submissions.
+const CallbackID = "buddybotConfig"
+
+// The notifications whose wording a team can change. Envelopes carrying one
+// of these notifications are marked with its kind.
+const (
+	MessageReporter = "reporter"
+	MessageAuthor   = "author"
+	MessageWarning  = "warning"
+)
+
+// Messages lists the notifications whose wording a team can change.
+var Messages = []string{MessageReporter, MessageAuthor, MessageWarning}
+
+// When the author of a flagged message is told it has been flagged. By default
+// they are told straight away. Teams worried about people flagging messages
+// in bad faith may wait until an admin confirms the report, or never tell
+// the author, in which case authors only hear from the admins if warned.
+const (
+	NotifyImmediately    = "immediately"
+	NotifyOnConfirmation = "confirmed"
+	NotifyNever          = "never"
+)
+
+// Limits on how many messages can be flagged in each LimitPeriod, by one
+// person or of messages posted by one person. Teams may set their own limits,
+// or turn them off with NoLimit.
+const (
+	DefaultReporterLimit = 10
+	DefaultAuthorLimit   = 20
+	NoLimit              = -1
+	LimitPeriod          = time.Hour
+)
+
+// attempts is the number of times we try to update the settings before giving
+// up. Attempts only fail if the settings were changed at the same time.
+const attempts = 5
+
+// Settings represents the configuration for a team that we store in the data
+// store. Empty values mean the default is used. If Anonymous is set, people
+// who flag a message are hidden from the admins as if they had chosen to flag
+// it anonymously. The version is incremented every time the settings are
+// saved so that concurrent changes are detected rather than lost.
+type Settings struct {
+	UID           string            `json:"uid"`
+	TeamID        string            `json:"team_id"`
+	AdminChannel  string            `json:"admin_channel,omitempty"`
+	CoCURL        string            `json:"coc_url,omitempty"`
+	Anonymous     bool              `json:"anonymous,omitempty"`
+	AuthorNotice  string            `json:"author_notice,omitempty"`
+	ReporterLimit int               `json:"reporter_limit,omitempty"`
+	AuthorLimit   int               `json:"author_limit,omitempty"`
+	Messages      map[string]string `json:"messages,omitempty"`
+	UpdatedBy     string            `json:"updated_by,omitempty"`
+	Updated       time.Time         `json:"updated"`
+	Version       int               `json:"version,omitempty"`
+}
+
+// ID returns the identifier used to store the Settings for a team.
+func ID(teamID string) string {
+	return "settings:" + teamID
+}
+
+// Get takes a Team ID and returns the stored Settings for the team. If the
+// team has not configured anything the default Settings are returned. It
+// returns an error if unable to retrieve the settings.
+func Get(db storage.Store, teamID string) (Settings, error) {
+	s := Settings{}
+	err := db.Retrieve("uid", ID(teamID), &s)
+	if errors.Cause(err) == storage.ErrNotFound {
+		return Settings{UID: ID(teamID), TeamID: teamID}, nil
+	}
+	return s, err
+}
+
+// Load takes the AuthRecord for a team and returns the stored Settings for
+// the team, as Get does. Teams that configured BuddyBot before settings were
+// introduced have their admins channel and Code of Conduct link held in the
+// AuthRecord. If such a team has no settings these are copied to its
+// settings, once, and the new settings returned. It returns an error if
+// unable to retrieve or copy the settings.
+func Load(db storage.Store, ar secrets.AuthRecord) (Settings, error) {
+	s := Settings{}
+	err := db.Retrieve("uid", ID(ar.TeamID), &s)
+	if err == nil {
+		return s, nil
+	}
+	if errors.Cause(err) != storage.ErrNotFound {
+		return s, err
+	}
+
+	s = Settings{UID: ID(ar.TeamID), TeamID: ar.TeamID}
+	if ar.AdminChannel == "" && ar.CoCURL == "" {
+		return s, nil
+	}
+
+	s.AdminChannel = ar.AdminChannel
+	s.CoCURL = ar.CoCURL
+	s.Updated = time.Now().UTC()
+	err = Save(db, s)
+	if errors.Cause(err) == storage.ErrConditionFailed {
+		// Someone else saved settings for the team first so we use theirs.
+		return Get(db, ar.TeamID)
+	}
+	if err != nil {
+		return s, errors.Wrap(err, "unable to copy settings from team tokens")
+	}
+	fmt.Println("INFO: settings copied from team tokens for team:", ar.TeamID)
+	s.Version++
+	return s, nil
+}
+
+// Save takes Settings, as returned by Get or Load, and stores them. Settings
+// are only stored if they haven't been changed since they were read. It
+// returns an error with a cause of storage.ErrConditionFailed if they have,
+// and an error if unable to store the settings in the database.
+func Save(db storage.Store, s Settings) error {
+	if s.TeamID == "" {
+		return errors.New("settings must have a team ID")
+	}
+	s.UID = ID(s.TeamID)
+
+	// Settings stored before they were versioned have no version.
+	cond := storage.Absent("version")
+	if s.Version > 0 {
+		cond = storage.Equal("version", s.Version)
+	}
+	s.Version++
+	return db.SaveIf(s, cond)
+}
+
+// Update takes a Team ID and a function that changes the settings for the
+// team. The function is given the current settings and returns false if
+// there is nothing to save. If the settings are changed by someone else
+// before they are saved, the function is called again with the new settings.
+// Update returns the settings as saved. It returns an error if unable to
+// read or store the settings.
+func Update(db storage.Store, teamID string, change func(*Settings) bool) (Settings, error) {
+	for i := 0; i < attempts; i++ {
+		s, err := Get(db, teamID)
+		if err != nil {
+			return s, errors.Wrap(err, "unable to fetch team settings")
+		}
+
+		if change(&s) == false {
+			return s, nil
+		}
+
+		err = Save(db, s)
+		if errors.Cause(err) == storage.ErrConditionFailed {
+			continue
+		}
+		if err != nil {
+			return s, errors.Wrap(err, "unable to save team settings")
+		}
+		s.Version++
+		return s, nil
+	}
+	return Settings{}, errors.Errorf("unable to update settings for team %s after %d attempts", teamID, attempts)
+}
+
+// Message takes the kind of a notification and returns the wording the team
+// has configured for it. It returns false if the default should be used.
+func (s Settings) Message(kind string) (string, bool) {
+	txt, ok := s.Messages[kind]
+	return txt, ok && txt != ""
+}
+
+// NotifyAuthor returns when the author of a flagged message is told it has
+// been flagged, one of NotifyImmediately, NotifyOnConfirmation or
+// NotifyNever.
+func (s Settings) NotifyAuthor() string {
+	switch s.AuthorNotice {
+	case NotifyOnConfirmation, NotifyNever:
+		return s.AuthorNotice
+	}
+	return NotifyImmediately
+}
+
+// FlagsPerReporter returns how many messages one person may flag in each
+// LimitPeriod. It returns zero if there is no limit.
+func (s Settings) FlagsPerReporter() int {
+	return limit(s.ReporterLimit, DefaultReporterLimit)
+}
+
+// FlagsPerAuthor returns how many messages posted by one person may be
+// flagged in each LimitPeriod. It returns zero if there is no limit.
+func (s Settings) FlagsPerAuthor() int {
+	return limit(s.AuthorLimit, DefaultAuthorLimit)
+}
+
+// limit returns the limit configured by a team, or the default if the team
+// hasn't set one. It returns zero if there is no limit.
+func limit(configured, def int) int {
+	switch {
+	case configured == 0:
+		return def
+	case configured < 0:
+		return 0
+	}
+	return configured
+}
+
+// ChannelResolver resolves the admins channel configured for a team, a
+// channel ID or name, to a channel ID.
+type ChannelResolver interface {
+	AdminChannelID(channel string) (string, error)
+}
+
+// AdminChannelID takes the Settings for a team and returns the ID of the
+// admins channel for the team, as resolved by the ChannelResolver. Once
+// resolved the ID is stored in place of the configured channel so that
+// future lookups don't need to search for it. Only the admins channel is
+// changed, and only if it is still the channel that was resolved, so other
+// changes made in the meantime are kept. It returns an error if unable to
+// resolve the admins channel.
+func AdminChannelID(db storage.Store, s Settings, r ChannelResolver) (string, error) {
+	id, err := r.AdminChannelID(s.AdminChannel)
+	if err != nil {
+		return "", err
+	}
+
+	if id != s.AdminChannel {
+		_, err := Update(db, s.TeamID, func(current *Settings) bool {
+			if current.AdminChannel != s.AdminChannel {
+				return false
+			}
+			current.AdminChannel = id
+			return true
+		})
+		if err != nil {
+			fmt.Println("ERROR: unable to store admins channel:", err)
+		}
+	}
+	return id, nil
+}
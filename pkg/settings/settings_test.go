@@ -0,0 +1,277 @@
+package settings
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// resolver resolves channel names using a fixed mapping.
+type resolver map[string]string
+
+func (r resolver) AdminChannelID(channel string) (string, error) {
+	id, ok := r[channel]
+	if ok == false {
+		return "", errors.New("channel_not_found")
+	}
+	return id, nil
+}
+
+func TestGet(t *testing.T) {
+	db := storage.NewMemory("uid")
+
+	s, err := Get(db, "T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s.UID != ID("T1") || s.TeamID != "T1" || s.AdminChannel != "" || s.CoCURL != "" {
+		t.Errorf("unexpected default settings: %+v", s)
+	}
+
+	s.CoCURL = "https://example.com/coc"
+	s.Messages = map[string]string{MessageReporter: "Thanks", MessageAuthor: ""}
+	if err := Save(db, s); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	s, err = Get(db, "T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s.CoCURL != "https://example.com/coc" {
+		t.Error("unexpected Code of Conduct link:", s.CoCURL)
+	}
+	if txt, ok := s.Message(MessageReporter); ok == false || txt != "Thanks" {
+		t.Errorf("unexpected reporter message: %q, %t", txt, ok)
+	}
+	if _, ok := s.Message(MessageAuthor); ok {
+		t.Error("expected the default author message")
+	}
+	if _, ok := s.Message(MessageWarning); ok {
+		t.Error("expected the default warning message")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	db := storage.NewMemory("uid")
+
+	// Teams without legacy settings get the defaults, which aren't stored.
+	s, err := Load(db, secrets.AuthRecord{TeamID: "T1"})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s.TeamID != "T1" || s.AdminChannel != "" || s.CoCURL != "" {
+		t.Errorf("unexpected default settings: %+v", s)
+	}
+	if err := db.Retrieve("uid", ID("T1"), &Settings{}); err == nil {
+		t.Error("unexpected settings stored")
+	}
+
+	// Legacy settings are copied across once.
+	ar := secrets.AuthRecord{TeamID: "T2", AdminChannel: "moderators", CoCURL: "https://example.com/coc"}
+	s, err = Load(db, ar)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s.AdminChannel != "moderators" || s.CoCURL != "https://example.com/coc" {
+		t.Errorf("legacy settings not copied: %+v", s)
+	}
+	s, err = Get(db, "T2")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s.AdminChannel != "moderators" || s.CoCURL != "https://example.com/coc" || s.Version != 1 {
+		t.Errorf("legacy settings not stored: %+v", s)
+	}
+
+	// Settings changed since are kept.
+	s.CoCURL = "https://example.com/new"
+	if err := Save(db, s); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	s, err = Load(db, ar)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s.CoCURL != "https://example.com/new" {
+		t.Error("settings replaced by legacy settings:", s.CoCURL)
+	}
+}
+
+func TestNotifyAuthor(t *testing.T) {
+	tcs := []struct {
+		notice string
+		want   string
+	}{
+		{notice: "", want: NotifyImmediately},
+		{notice: NotifyImmediately, want: NotifyImmediately},
+		{notice: NotifyOnConfirmation, want: NotifyOnConfirmation},
+		{notice: NotifyNever, want: NotifyNever},
+		{notice: "sometimes", want: NotifyImmediately},
+	}
+
+	for _, tc := range tcs {
+		s := Settings{AuthorNotice: tc.notice}
+		if got := s.NotifyAuthor(); got != tc.want {
+			t.Errorf("unexpected policy for %q: %s", tc.notice, got)
+		}
+	}
+}
+
+func TestFlagLimits(t *testing.T) {
+	tcs := []struct {
+		reporter, author int
+		wantReporter     int
+		wantAuthor       int
+	}{
+		{wantReporter: DefaultReporterLimit, wantAuthor: DefaultAuthorLimit},
+		{reporter: 3, author: 7, wantReporter: 3, wantAuthor: 7},
+		{reporter: NoLimit, author: NoLimit, wantReporter: 0, wantAuthor: 0},
+	}
+
+	for _, tc := range tcs {
+		s := Settings{ReporterLimit: tc.reporter, AuthorLimit: tc.author}
+		if s.FlagsPerReporter() != tc.wantReporter || s.FlagsPerAuthor() != tc.wantAuthor {
+			t.Errorf("unexpected limits for %d, %d: %d, %d", tc.reporter, tc.author, s.FlagsPerReporter(), s.FlagsPerAuthor())
+		}
+	}
+}
+
+func TestSaveConflict(t *testing.T) {
+	db := storage.NewMemory("uid")
+
+	first, err := Get(db, "T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	second := first
+
+	first.CoCURL = "https://example.com/first"
+	if err := Save(db, first); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// Settings read before the first save can't overwrite it.
+	second.CoCURL = "https://example.com/second"
+	if err := Save(db, second); errors.Cause(err) != storage.ErrConditionFailed {
+		t.Fatal("expected a conflict:", err)
+	}
+
+	s, err := Get(db, "T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s.CoCURL != "https://example.com/first" || s.Version != 1 {
+		t.Errorf("unexpected settings: %+v", s)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	db := storage.NewMemory("uid")
+
+	// Settings stored before they were versioned are updated too.
+	if err := db.Save(Settings{UID: ID("T1"), TeamID: "T1", CoCURL: "https://example.com/coc"}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(limit int) {
+			defer wg.Done()
+			_, err := Update(db, "T1", func(s *Settings) bool {
+				s.Messages = mergeMessage(s.Messages, fmt.Sprint(limit))
+				return true
+			})
+			if err != nil {
+				t.Error("unexpected error:", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	s, err := Get(db, "T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(s.Messages) != 3 || s.CoCURL != "https://example.com/coc" || s.Version != 3 {
+		t.Errorf("updates lost: %+v", s)
+	}
+
+	saved, err := Update(db, "T1", func(s *Settings) bool { return false })
+	if err != nil || saved.Version != 3 {
+		t.Errorf("unexpected result without changes: %+v, %v", saved, err)
+	}
+}
+
+// mergeMessage returns the messages with one more added, keyed by its text.
+func mergeMessage(msgs map[string]string, txt string) map[string]string {
+	merged := map[string]string{txt: txt}
+	for k, v := range msgs {
+		merged[k] = v
+	}
+	return merged
+}
+
+func TestSaveWithoutTeam(t *testing.T) {
+	if err := Save(storage.NewMemory("uid"), Settings{}); err == nil {
+		t.Error("expected an error for settings without a team")
+	}
+}
+
+func TestAdminChannelID(t *testing.T) {
+	db := storage.NewMemory("uid")
+	r := resolver{"moderators": "C0MODS01", "C0MODS01": "C0MODS01"}
+
+	if err := Save(db, Settings{TeamID: "T1", AdminChannel: "moderators"}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	s, err := Get(db, "T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// The Code of Conduct link is changed after the settings were read.
+	if _, err := Update(db, "T1", func(s *Settings) bool {
+		s.CoCURL = "https://example.com/coc"
+		return true
+	}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	id, err := AdminChannelID(db, s, r)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if id != "C0MODS01" {
+		t.Error("unexpected channel ID:", id)
+	}
+
+	s, err = Get(db, "T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s.AdminChannel != "C0MODS01" {
+		t.Error("admins channel not stored:", s.AdminChannel)
+	}
+	if s.CoCURL != "https://example.com/coc" {
+		t.Error("Code of Conduct link lost:", s.CoCURL)
+	}
+
+	// A channel changed by an admin in the meantime isn't replaced.
+	stale := Settings{TeamID: "T1", AdminChannel: "moderators"}
+	if _, err := AdminChannelID(db, stale, r); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s, _ := Get(db, "T1"); s.AdminChannel != "C0MODS01" {
+		t.Error("admins channel replaced:", s.AdminChannel)
+	}
+
+	if _, err := AdminChannelID(db, Settings{TeamID: "T1", AdminChannel: "missing"}, r); err == nil {
+		t.Error("expected an error for a missing channel")
+	}
+}
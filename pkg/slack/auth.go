@@ -4,6 +4,7 @@ package slack
 // a user adds our app to their workspace.
 type AuthResponse struct {
 	Ok          bool   `json:"ok"`
+	Error       string `json:"error,omitempty"`
 	AccessToken string `json:"access_token"`
 	Scope       string `json:"scope"`
 	UserID      string `json:"user_id"`
@@ -0,0 +1,102 @@
+package slack
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Types of request sent to us by the Slack Events API.
+const (
+	EventTypeURLVerification = "url_verification"
+	EventTypeCallback        = "event_callback"
+)
+
+// Types of event we are able to decode from an EventCallback.
+const (
+	EventAppUninstalled      = "app_uninstalled"
+	EventTokensRevoked       = "tokens_revoked"
+	EventMemberJoinedChannel = "member_joined_channel"
+	EventMessage             = "message"
+)
+
+// EventCallback is the message received from the Slack Events API. It wraps
+// the event that occurred in a workspace, or carries a challenge when Slack
+// is verifying ownership of our endpoint.
+type EventCallback struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge,omitempty"`
+	TeamID    string          `json:"team_id,omitempty"`
+	APIAppID  string          `json:"api_app_id,omitempty"`
+	EventID   string          `json:"event_id,omitempty"`
+	EventTime int64           `json:"event_time,omitempty"`
+	Event     json.RawMessage `json:"event,omitempty"`
+}
+
+// AppUninstalledEvent is sent when BuddyBot is removed from a workspace.
+type AppUninstalledEvent struct {
+	Type string `json:"type"`
+}
+
+// TokensRevokedEvent is sent when access tokens for a workspace are revoked.
+// It lists the IDs of the users whose tokens have been revoked.
+type TokensRevokedEvent struct {
+	Type   string `json:"type"`
+	Tokens struct {
+		OAuth []string `json:"oauth"`
+		Bot   []string `json:"bot"`
+	} `json:"tokens"`
+}
+
+// MemberJoinedChannelEvent is sent when a user joins a channel.
+type MemberJoinedChannelEvent struct {
+	Type        string `json:"type"`
+	User        string `json:"user"`
+	Channel     string `json:"channel"`
+	ChannelType string `json:"channel_type"`
+	Team        string `json:"team"`
+	Inviter     string `json:"inviter,omitempty"`
+}
+
+// MessageEvent is sent when a message is posted to a channel.
+type MessageEvent struct {
+	Message
+	Channel     string `json:"channel"`
+	ChannelType string `json:"channel_type,omitempty"`
+}
+
+// ParseEvent parses the body of a request, a string, and returns an
+// EventCallback.
+func ParseEvent(b string) (EventCallback, error) {
+	ec := EventCallback{}
+	err := json.Unmarshal([]byte(b), &ec)
+	if err != nil {
+		return ec, errors.Wrap(err, "failed to parse request body")
+	}
+	return ec, nil
+}
+
+// EventType returns the type of the event wrapped by the EventCallback. It
+// returns an error if there is no event or its type can't be determined.
+func (ec EventCallback) EventType() (string, error) {
+	e := struct {
+		Type string `json:"type"`
+	}{}
+	if err := ec.Decode(&e); err != nil {
+		return "", err
+	}
+	if e.Type == "" {
+		return "", errors.New("event has no type")
+	}
+	return e.Type, nil
+}
+
+// Decode unmarshals the event wrapped by the EventCallback into v, which
+// should be a pointer to one of the typed events.
+func (ec EventCallback) Decode(v interface{}) error {
+	if len(ec.Event) == 0 {
+		return errors.New("no event provided")
+	}
+	err := json.Unmarshal(ec.Event, v)
+	return errors.Wrap(err, "failed to decode event")
+}
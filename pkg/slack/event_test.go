@@ -0,0 +1,52 @@
+package slack
+
+import "testing"
+
+func TestParseEvent(t *testing.T) {
+	body := `{
+		"type": "event_callback",
+		"team_id": "T1",
+		"event_id": "Ev1",
+		"event": {
+			"type": "tokens_revoked",
+			"tokens": {"oauth": ["U1"], "bot": ["B1"]}
+		}
+	}`
+
+	ec, err := ParseEvent(body)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if ec.Type != EventTypeCallback || ec.TeamID != "T1" {
+		t.Errorf("unexpected event callback: %+v", ec)
+	}
+
+	et, err := ec.EventType()
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if et != EventTokensRevoked {
+		t.Error("unexpected event type:", et)
+	}
+
+	e := TokensRevokedEvent{}
+	if err := ec.Decode(&e); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(e.Tokens.Bot) != 1 || e.Tokens.Bot[0] != "B1" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
+
+func TestParseEventURLVerification(t *testing.T) {
+	ec, err := ParseEvent(`{"type": "url_verification", "challenge": "abc"}`)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if ec.Type != EventTypeURLVerification || ec.Challenge != "abc" {
+		t.Errorf("unexpected event callback: %+v", ec)
+	}
+	if _, err := ec.EventType(); err == nil {
+		t.Error("expected an error for a request without an event")
+	}
+}
@@ -20,6 +20,14 @@ type MessageAction struct {
 	Message     Message     `json:"message"`
 	ResponseURL string      `json:"response_url"`
 	TriggerID   string      `json:"trigger_id"`
+	Actions     []Action    `json:"actions,omitempty"`
+}
+
+// Action is a button clicked by a user on an interactive message.
+type Action struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
 }
 
 // Team is a Slack team.
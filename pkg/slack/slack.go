@@ -1,13 +1,22 @@
 package slack
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 
 	"github.com/billglover/bbot/pkg/messaging"
 	api "github.com/nlopes/slack"
 	"github.com/pkg/errors"
 )
 
+// DefaultAPIURL is the base URL of the Slack Web API.
+const DefaultAPIURL = "https://slack.com/api/"
+
 // Workspace represents a Slack workspace.
 type Workspace struct {
 	botClient    *api.Client
@@ -15,122 +24,394 @@ type Workspace struct {
 	botToken     string
 	botUserToken string
 	botUser      string
+	apiURL       string
 }
 
 // New returns a Workspace. It requires a botToken and a botUserToken to allow
-// it to perform operations in the Workspace. If either of these are empty, an
-// error is returned.
-func New(botToken, botUserToken, botUser string) (*Workspace, error) {
+// it to perform operations in the Workspace. If either of these are empty, or
+// if any of the options provided can't be applied, an error is returned.
+func New(botToken, botUserToken, botUser string, options ...func(*Workspace) error) (*Workspace, error) {
 	w := new(Workspace)
 	w.botToken = botToken
 	w.botUserToken = botUserToken
 	w.botUser = botUser
+	w.apiURL = DefaultAPIURL
 	if w.botToken == "" || w.botUserToken == "" || w.botUser == "" {
 		return w, errors.New("botToken, botUserToken and botUser must be provided")
 	}
-	w.botClient = api.New(botToken)
-	w.userClient = api.New(botUserToken)
+	for _, option := range options {
+		if err := option(w); err != nil {
+			return w, err
+		}
+	}
+
+	client := api.OptionHTTPClient(&rewriter{base: w.apiURL, client: http.DefaultClient})
+	w.botClient = api.New(botToken, client)
+	w.userClient = api.New(botUserToken, client)
 	return w, nil
 }
 
-// SendMessage sends a message to Slack.
-func (w *Workspace) SendMessage(e messaging.Envelope) error {
+// APIURL sets the base URL of the Slack Web API used by the Workspace, e.g.
+// "http://localhost:9090/api/". It allows the Workspace to be pointed at a
+// fake Slack server for testing and local development.
+func APIURL(u string) func(*Workspace) error {
+	return func(w *Workspace) error {
+		base, err := BaseURL(u)
+		if err != nil {
+			return err
+		}
+		w.apiURL = base
+		return nil
+	}
+}
 
-	switch {
+// BaseURL takes the base URL of the Slack Web API and returns it in the form
+// API methods can be appended to. It returns an error if the URL is not an
+// absolute URL.
+func BaseURL(u string) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid Slack API URL")
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return "", errors.Errorf("invalid Slack API URL: %s", u)
+	}
+	return strings.TrimSuffix(u, "/") + "/", nil
+}
 
-	// Ephemeral messages are sent to an individual user
-	case e.Ephemeral == true:
-		if e.Destination.UserID == "" {
-			return errors.New("ephemeral messages require a UserID")
-		}
+// rewriter sends requests intended for the Slack Web API to the base URL
+// configured for a Workspace. The Slack client always builds requests using
+// its package level API URL, so we swap it for ours as requests are made.
+type rewriter struct {
+	base   string
+	client api.HTTPRequester
+}
 
-		msgOptsEphemeral := api.MsgOptionPostEphemeral2(e.Destination.UserID)
-		msgOpts := api.MsgOptionText(e.Message.Text, true)
-		ts, err := w.botClient.PostEphemeral(e.Destination.ChannelID, e.Destination.UserID, msgOptsEphemeral, msgOpts)
+// Do rewrites the request URL and sends the request.
+func (r *rewriter) Do(req *http.Request) (*http.Response, error) {
+	if r.base != api.SLACK_API && strings.HasPrefix(req.URL.String(), api.SLACK_API) {
+		u, err := url.Parse(r.base + strings.TrimPrefix(req.URL.String(), api.SLACK_API))
 		if err != nil {
-			return errors.Wrap(err, "failed to send ephemeral message")
-		}
-		fmt.Println("INFO: ephemeral emssage sent:", ts)
-
-	// Standard messages without a UserID specified are sent to a channel
-	case e.Ephemeral == false && e.Destination.UserID == "":
-
-		msgParams := api.PostMessageParameters{
-			Username: w.botUser,
-			AsUser:   true,
-			Markdown: true,
-		}
-
-		if e.Message.Attachments != nil {
-			attachments := make([]api.Attachment, len(e.Message.Attachments))
-			for i, a := range e.Message.Attachments {
-				attachments[i] = api.Attachment{
-					Title:     a.Title,
-					TitleLink: a.TitleLink,
-					Pretext:   a.Description,
-				}
-
-				// include all fields
-				if a.Fields != nil {
-					fields := make([]api.AttachmentField, len(a.Fields))
-					for j, f := range a.Fields {
-						fields[j] = api.AttachmentField{Title: f.Name, Value: f.Value, Short: f.Short}
-					}
-					attachments[i].Fields = fields
-				}
-			}
-			msgParams.Attachments = attachments
+			return nil, err
+		}
+		req.URL = u
+		req.Host = u.Host
+	}
+	return r.client.Do(req)
+}
+
+// SendMessage sends a message to Slack. Messages are sent as JSON so that
+// they may contain both attachments and blocks. How the message is delivered
+// is determined by the Mode of the Envelope. It returns the channel the
+// message was sent to and its timestamp, which together identify the message
+// should it need to be changed later.
+func (w *Workspace) SendMessage(e messaging.Envelope) (string, string, error) {
+	d := e.Destination
+
+	switch e.Mode() {
+
+	// Ephemeral messages are shown to an individual user in a channel
+	case messaging.DeliveryEphemeral:
+		if d.UserID == "" || d.ChannelID == "" {
+			return "", "", errors.New("ephemeral messages require a UserID and ChannelID")
+		}
+
+		msg := w.chatMessage(d.ChannelID, e.Message)
+		msg.User = d.UserID
+		msg.ThreadTs = d.ThreadTs
+
+		resp := struct {
+			Ts string `json:"message_ts"`
+		}{}
+		if err := w.call("chat.postEphemeral", msg, &resp); err != nil {
+			return "", "", errors.Wrap(err, "failed to send ephemeral message")
+		}
+		fmt.Println("INFO: ephemeral message sent:", resp.Ts)
+		return d.ChannelID, resp.Ts, nil
+
+	// Direct messages are posted in the conversation between us and the user
+	case messaging.DeliveryDirect:
+		if d.UserID == "" {
+			return "", "", errors.New("direct messages require a UserID")
 		}
 
-		ch, ts, err := w.botClient.PostMessage(e.Destination.ChannelID, e.Message.Text, msgParams)
+		ch, err := w.OpenDM(d.UserID)
 		if err != nil {
-			return errors.Wrap(err, "unable to send message to channel")
+			return "", "", errors.Wrap(err, "unable to open direct message")
 		}
-		fmt.Printf("INFO: message posted in channel %s at %s", ch, ts)
+		return w.postMessage(ch, "", e.Message)
 
-		// Standard messages without a ChannelID specified ar esent to a user
-	case e.Ephemeral == false && e.Destination.ChannelID == "":
-		return errors.New("sending messages to an individual user is not currently supported")
+	case messaging.DeliveryChannel:
+		if d.ChannelID == "" {
+			return "", "", errors.New("channel messages require a ChannelID")
+		}
+		return w.postMessage(d.ChannelID, "", e.Message)
+
+	case messaging.DeliveryThread:
+		if d.ChannelID == "" || d.ThreadTs == "" {
+			return "", "", errors.New("thread replies require a ChannelID and ThreadTs")
+		}
+		return w.postMessage(d.ChannelID, d.ThreadTs, e.Message)
 
 	default:
-		return errors.Errorf("unable to determine intended message destination: %+v", e.Destination)
+		return "", "", errors.Errorf("unable to determine intended message delivery: %s", e.Delivery)
+	}
+}
+
+// postMessage posts a message in a channel, or as a reply in a thread if a
+// thread timestamp is provided. It returns the channel and timestamp of the
+// message.
+func (w *Workspace) postMessage(ch, thread string, m messaging.Message) (string, string, error) {
+	msg := w.chatMessage(ch, m)
+	msg.ThreadTs = thread
+	msg.AsUser = true
+
+	resp := struct {
+		Channel string `json:"channel"`
+		Ts      string `json:"ts"`
+	}{}
+	if err := w.call("chat.postMessage", msg, &resp); err != nil {
+		return "", "", errors.Wrap(err, "unable to send message to channel")
+	}
+	fmt.Printf("INFO: message posted in channel %s at %s\n", resp.Channel, resp.Ts)
+	return resp.Channel, resp.Ts, nil
+}
+
+// OpenDM takes a Slack User ID and returns the ID of the direct message
+// conversation between the user and BuddyBot. The conversation is opened if
+// it isn't already, otherwise the existing conversation is returned.
+func (w *Workspace) OpenDM(user string) (string, error) {
+	resp := struct {
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+	}{}
+	err := w.call("conversations.open", map[string]string{"users": user}, &resp)
+	if err != nil {
+		return "", err
 	}
+	return resp.Channel.ID, nil
+}
 
+// UpdateMessage replaces the content of a message previously sent to a
+// channel. The message is identified by the channel and its timestamp.
+func (w *Workspace) UpdateMessage(ch, ts string, m messaging.Message) error {
+	msg := w.chatMessage(ch, m)
+	msg.Ts = ts
+	msg.AsUser = true
+
+	if err := w.call("chat.update", msg, nil); err != nil {
+		return errors.Wrap(err, "unable to update message")
+	}
+	fmt.Printf("INFO: message updated in channel %s at %s\n", ch, ts)
 	return nil
 }
 
-// AdminChannelID returns the ChannelID for the admins channel in a workspace.
-// It returns an error if it is unable to identify the admins channel.
-func (w *Workspace) AdminChannelID() (string, error) {
-	var id string
+// DeleteMessage deletes a message previously sent to a channel. The message
+// is identified by the channel and its timestamp.
+func (w *Workspace) DeleteMessage(ch, ts string) error {
+	body := map[string]interface{}{"channel": ch, "ts": ts, "as_user": true}
+	if err := w.call("chat.delete", body, nil); err != nil {
+		return errors.Wrap(err, "unable to delete message")
+	}
+	fmt.Printf("INFO: message deleted in channel %s at %s\n", ch, ts)
+	return nil
+}
 
-	// Note: private channels are known as Groups in Slack.
-	//grps, err := w.botClient.GetGroups(true)
+// response is the body of a request to a response URL.
+type response struct {
+	Text            string            `json:"text"`
+	ResponseType    string            `json:"response_type,omitempty"`
+	ReplaceOriginal bool              `json:"replace_original"`
+	DeleteOriginal  bool              `json:"delete_original,omitempty"`
+	Attachments     []api.Attachment  `json:"attachments,omitempty"`
+	Blocks          []messaging.Block `json:"blocks,omitempty"`
+}
 
-	// TODO: this will fail if it can't find the admins channel in the first 1000
-	// results or if Slack decides to return fewer results. To fix this you need
-	// to use the cursor to paginate through results.
-	params := api.GetConversationsParameters{
-		ExcludeArchived: "true",
-		Types:           []string{"private_channel"},
-		Limit:           1000,
+// Respond sends a message to the response URL Slack provides with slash
+// commands and interactions. If replace is true the message replaces the
+// one the user interacted with, otherwise it is shown only to the user.
+// Response URLs don't require a token and expire after 30 minutes.
+func (w *Workspace) Respond(u string, m messaging.Message, replace bool) error {
+	r := response{
+		Text:            m.Text,
+		ReplaceOriginal: replace,
+		Attachments:     attachments(m.Attachments),
+		Blocks:          m.Blocks,
+	}
+	if replace == false {
+		r.ResponseType = "ephemeral"
+	}
+	return w.respond(u, r)
+}
+
+// DeleteResponse deletes the message the user interacted with using the
+// response URL Slack provided with the interaction.
+func (w *Workspace) DeleteResponse(u string) error {
+	return w.respond(u, response{DeleteOriginal: true})
+}
+
+// respond sends a JSON request to a response URL. Slack may reply with
+// either JSON or plain text so only errors it reports as JSON are returned.
+func (w *Workspace) respond(u string, r response) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
 	}
-	grps, _, err := w.botClient.GetConversations(&params)
+
+	resp, err := http.Post(u, "application/json; charset=utf-8", bytes.NewReader(b))
 	if err != nil {
-		return id, errors.Wrap(err, "unable to retrieve list of channels")
+		return errors.Wrap(err, "unable to send response")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unable to send response: %s", resp.Status)
+	}
+
+	v := struct {
+		Ok    *bool  `json:"ok"`
+		Error string `json:"error"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err == nil && v.Ok != nil && *v.Ok == false {
+		return errors.Errorf("unable to send response: %s", v.Error)
+	}
+	return nil
+}
+
+// chatMessage is the body of the requests used to send and update messages.
+type chatMessage struct {
+	Channel     string            `json:"channel"`
+	User        string            `json:"user,omitempty"`
+	Ts          string            `json:"ts,omitempty"`
+	ThreadTs    string            `json:"thread_ts,omitempty"`
+	Text        string            `json:"text"`
+	AsUser      bool              `json:"as_user,omitempty"`
+	Markdown    bool              `json:"mrkdwn"`
+	Attachments []api.Attachment  `json:"attachments,omitempty"`
+	Blocks      []messaging.Block `json:"blocks,omitempty"`
+}
+
+// chatMessage converts a message into the body of a request to send it to
+// the channel provided. Blocks are already in the form Slack expects so are
+// passed through as they are.
+func (w *Workspace) chatMessage(ch string, m messaging.Message) chatMessage {
+	return chatMessage{
+		Channel:     ch,
+		Text:        m.Text,
+		Markdown:    true,
+		Attachments: attachments(m.Attachments),
+		Blocks:      m.Blocks,
+	}
+}
+
+// attachments converts message attachments into their Slack API equivalent.
+// It returns nil if there are no attachments.
+func attachments(as []messaging.Attachment) []api.Attachment {
+	if as == nil {
+		return nil
+	}
+
+	attachments := make([]api.Attachment, len(as))
+	for i, a := range as {
+		attachments[i] = api.Attachment{
+			Title:      a.Title,
+			TitleLink:  a.TitleLink,
+			Pretext:    a.Description,
+			CallbackID: a.CallbackID,
+		}
+
+		// include all fields
+		if a.Fields != nil {
+			fields := make([]api.AttachmentField, len(a.Fields))
+			for j, f := range a.Fields {
+				fields[j] = api.AttachmentField{Title: f.Name, Value: f.Value, Short: f.Short}
+			}
+			attachments[i].Fields = fields
+		}
+
+		// include all buttons
+		if a.Actions != nil {
+			actions := make([]api.AttachmentAction, len(a.Actions))
+			for j, b := range a.Actions {
+				actions[j] = api.AttachmentAction{Name: b.Name, Text: b.Text, Type: "button", Value: b.Value, Style: b.Style}
+			}
+			attachments[i].Actions = actions
+		}
 	}
+	return attachments
+}
+
+// DefaultAdminChannel is the name of the private channel we look for when a
+// team has not configured an admins channel.
+const DefaultAdminChannel = "admins"
+
+// channelID matches the IDs Slack gives to channels and private channels.
+var channelID = regexp.MustCompile(`^[CG][A-Z0-9]{6,}$`)
+
+// IsChannelID reports whether s is a channel ID rather than a channel name.
+func IsChannelID(s string) bool {
+	return channelID.MatchString(s)
+}
+
+// AdminChannelID takes the admins channel configured for a workspace, either
+// a channel ID or name, and returns the ChannelID for the admins channel. If
+// no channel is configured it looks for a private channel called "admins".
+// It returns an error if it is unable to identify the admins channel.
+func (w *Workspace) AdminChannelID(channel string) (string, error) {
+	channel = strings.TrimPrefix(strings.TrimSpace(channel), "#")
+
+	switch {
+	case channel == "":
+		// Note: private channels are known as Groups in Slack.
+		id, err := w.ChannelID(DefaultAdminChannel, "private_channel")
+		if err != nil {
+			return id, errors.Wrapf(err, "unable to locate '%s' group", DefaultAdminChannel)
+		}
+		return id, nil
+
+	case IsChannelID(channel):
+		return channel, nil
 
-	for _, g := range grps {
-		if g.NameNormalized == "admins" {
-			id = g.ID
+	default:
+		id, err := w.ChannelID(channel, "private_channel", "public_channel")
+		if err != nil {
+			return id, errors.Wrapf(err, "unable to locate '%s' channel", channel)
 		}
+		return id, nil
 	}
+}
 
-	if id == "" {
-		return id, errors.New("unable to locate 'admins' group")
+// ChannelID takes the name of a channel and the types of channel to search
+// and returns the ChannelID of the channel. Names are matched as Slack
+// normalises them, in lower case and without a leading '#'. It pages through
+// all channels visible to BuddyBot. It returns an error if no channel has the
+// name.
+func (w *Workspace) ChannelID(name string, types ...string) (string, error) {
+	name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))
+	params := api.GetConversationsParameters{
+		ExcludeArchived: "true",
+		Types:           types,
+		Limit:           200,
 	}
 
-	return id, nil
+	for {
+		chs, cursor, err := w.botClient.GetConversations(&params)
+		if err != nil {
+			return "", errors.Wrap(err, "unable to retrieve list of channels")
+		}
+
+		for _, ch := range chs {
+			if ch.NameNormalized == name {
+				return ch.ID, nil
+			}
+		}
+
+		if cursor == "" {
+			return "", errors.New("channel not found")
+		}
+		params.Cursor = cursor
+	}
 }
 
 // UserName takes a UserID and returns the corresponding UserName. It reutrns
@@ -138,7 +419,37 @@ func (w *Workspace) AdminChannelID() (string, error) {
 func (w *Workspace) UserName(id string) (string, error) {
 
 	user, err := w.botClient.GetUserInfo(id)
-	return user.Name, err
+	if err != nil {
+		return "", err
+	}
+	return user.Name, nil
+}
+
+// IsAdmin takes a UserID and reports whether the user is an admin or owner of
+// the workspace. It returns an error if it is unable to look up the user.
+func (w *Workspace) IsAdmin(id string) (bool, error) {
+	user, err := w.botClient.GetUserInfo(id)
+	if err != nil {
+		return false, err
+	}
+	return user.IsAdmin || user.IsOwner || user.IsPrimaryOwner, nil
+}
+
+// UserLocale takes a UserID and returns the locale the user has chosen in
+// Slack, e.g. "en-US". It returns an error if it is unable to look up the
+// user.
+func (w *Workspace) UserLocale(id string) (string, error) {
+	r := struct {
+		User struct {
+			Locale string `json:"locale"`
+		} `json:"user"`
+	}{}
+
+	v := url.Values{"user": {id}, "include_locale": {"true"}}
+	if err := w.callForm("users.info", v, &r); err != nil {
+		return "", errors.Wrap(err, "unable to get user locale")
+	}
+	return r.User.Locale, nil
 }
 
 // Permalink takes a message timestamp and returns the corresponding permalink.
@@ -152,3 +463,85 @@ func (w *Workspace) Permalink(ch, ts string) (string, error) {
 	permalink, err := w.botClient.GetPermalink(&params)
 	return permalink, err
 }
+
+// IsRevoked reports whether an error returned by the Slack API indicates that
+// our access tokens are no longer valid, e.g. because BuddyBot has been
+// removed from the workspace.
+func IsRevoked(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch errors.Cause(err).Error() {
+	case "token_revoked", "account_inactive", "invalid_auth", "not_authed", "team_disabled":
+		return true
+	}
+	return false
+}
+
+// call sends a JSON request to a Slack API method using the bot token. It is
+// used for methods not supported by the Slack client. The response is decoded
+// into v if provided. It returns an error if Slack reports one.
+func (w *Workspace) call(method string, body, v interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.apiURL+method, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+w.botToken)
+	return w.do(method, req, v)
+}
+
+// callForm sends a form encoded request to a Slack API method using the bot
+// token. Some methods, such as those that read data, don't accept JSON. It
+// behaves in the same way as call.
+func (w *Workspace) callForm(method string, values url.Values, v interface{}) error {
+	values.Set("token", w.botToken)
+
+	req, err := http.NewRequest(http.MethodPost, w.apiURL+method, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return w.do(method, req, v)
+}
+
+// do sends a request to a Slack API method and decodes the response into v
+// if provided. It returns an error if Slack reports one.
+func (w *Workspace) do(method string, req *http.Request, v interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s: %s", method, resp.Status)
+	}
+
+	raw := json.RawMessage{}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return errors.Wrap(err, "unable to decode response")
+	}
+
+	r := struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+	}{}
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return errors.Wrap(err, "unable to decode response")
+	}
+	if r.Ok == false {
+		return errors.New(r.Error)
+	}
+
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
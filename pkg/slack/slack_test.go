@@ -0,0 +1,55 @@
+package slack
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestBaseURL(t *testing.T) {
+	tcs := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{in: "https://slack.com/api/", want: "https://slack.com/api/"},
+		{in: "http://localhost:9090/api", want: "http://localhost:9090/api/"},
+		{in: "localhost:9090", err: true},
+		{in: "/api/", err: true},
+		{in: "", err: true},
+	}
+
+	for _, tc := range tcs {
+		got, err := BaseURL(tc.in)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.in, err)
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestIsRevoked(t *testing.T) {
+	tcs := []struct {
+		err  error
+		want bool
+	}{
+		{err: nil},
+		{err: errors.New("token_revoked"), want: true},
+		{err: errors.Wrap(errors.New("account_inactive"), "unable to post message"), want: true},
+		{err: errors.New("channel_not_found")},
+	}
+
+	for _, tc := range tcs {
+		if got := IsRevoked(tc.err); got != tc.want {
+			t.Errorf("%v: got %t, want %t", tc.err, got, tc.want)
+		}
+	}
+}
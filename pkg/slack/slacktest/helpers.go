@@ -0,0 +1,70 @@
+package slacktest
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/queue"
+	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/storage"
+)
+
+// Install returns Teams pointed at the fake server with BuddyBot installed
+// for each of the teams provided. Tokens are held in memory. It panics if
+// the tokens can't be stored.
+func (s *Server) Install(teamIDs ...string) *slack.Teams {
+	tokens := storage.NewMemory("uid")
+	for _, id := range teamIDs {
+		err := secrets.SaveTeamTokens(tokens, secrets.AuthRecord{
+			UID:            id,
+			TeamID:         id,
+			AccessToken:    "xoxp-" + id,
+			BotAccessToken: "xoxb-" + id,
+			BotUserID:      "B" + id,
+		})
+		if err != nil {
+			panic("slacktest: unable to store team tokens: " + err.Error())
+		}
+	}
+
+	teams, err := slack.NewTeams(tokens, slack.APIURL(s.APIURL()))
+	if err != nil {
+		panic("slacktest: unable to create teams: " + err.Error())
+	}
+	return teams
+}
+
+// Queue is a queue that keeps the envelopes placed on it, in the order they
+// were queued. It allows the messages a function would send to Slack to be
+// inspected without a message queue.
+type Queue struct {
+	mu        sync.Mutex
+	Envelopes []messaging.Envelope
+}
+
+// Queue decodes the body as an envelope and keeps it.
+func (q *Queue) Queue(ctx context.Context, h queue.Headers, b queue.Body) error {
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	e := messaging.Envelope{}
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Envelopes = append(q.Envelopes, e)
+	return nil
+}
+
+// Reset discards the envelopes queued so far.
+func (q *Queue) Reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Envelopes = nil
+}
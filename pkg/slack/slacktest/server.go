@@ -0,0 +1,475 @@
+/*
+Package slacktest provides a fake Slack Web API server. It implements the API
+methods that BuddyBot uses, records every call made to it and can be told to
+fail calls or rate limit them. It allows the functions that talk to Slack to
+be tested, and run locally, without a connection to Slack.
+*/
+package slacktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/billglover/bbot/pkg/slack"
+)
+
+// Channel is a channel known to the fake server.
+type Channel struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Private bool   `json:"is_private"`
+}
+
+// User is a user known to the fake server.
+type User struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	IsAdmin bool   `json:"is_admin"`
+	Locale  string `json:"locale,omitempty"`
+}
+
+// Call is a record of a call made to the fake server. Form encoded arguments
+// are held in Values. Methods called with a JSON body have the body held in
+// Body, with the token from the Authorization header and any string fields
+// at the top level of the body held in Values.
+type Call struct {
+	Method string
+	Values url.Values
+	Body   []byte
+}
+
+// Server is a fake Slack Web API server. Use NewServer to create one and
+// Close to shut it down.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	channels   []Channel
+	users      map[string]User
+	auth       slack.AuthResponse
+	calls      []Call
+	errors     map[string]string
+	rateLimits map[string]time.Duration
+	seq        int64
+}
+
+// NewServer starts and returns a fake Slack Web API server.
+func NewServer() *Server {
+	s := &Server{
+		users:      make(map[string]User),
+		errors:     make(map[string]string),
+		rateLimits: make(map[string]time.Duration),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// APIURL returns the base URL of the fake Web API. It is intended to be
+// passed to slack.APIURL.
+func (s *Server) APIURL() string {
+	return s.Server.URL + "/api/"
+}
+
+// ResponseMethod is the method recorded for calls made to a response URL.
+const ResponseMethod = "response_url"
+
+// ResponseURL returns a response URL, like those Slack sends with slash
+// commands and interactions. Calls made to it are recorded with the
+// ResponseMethod, regardless of the id provided.
+func (s *Server) ResponseURL(id string) string {
+	return s.Server.URL + "/response/" + id
+}
+
+// AddChannel adds a channel to those returned by conversations.list.
+func (s *Server) AddChannel(c Channel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels = append(s.channels, c)
+}
+
+// AddUser adds a user to those returned by users.info.
+func (s *Server) AddUser(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.ID] = u
+}
+
+// SetAuth sets the response to oauth.access.
+func (s *Server) SetAuth(ar slack.AuthResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth = ar
+}
+
+// SetError causes all calls to an API method to fail with the Slack error
+// provided, e.g. "token_revoked". An empty error clears it.
+func (s *Server) SetError(method, err string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == "" {
+		delete(s.errors, method)
+		return
+	}
+	s.errors[method] = err
+}
+
+// SetRateLimit causes all calls to an API method to be rejected as rate
+// limited, asking the caller to retry after the duration provided. A zero
+// duration clears it.
+func (s *Server) SetRateLimit(method string, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if retryAfter == 0 {
+		delete(s.rateLimits, method)
+		return
+	}
+	s.rateLimits[method] = retryAfter
+}
+
+// Calls returns the calls made to an API method in the order they were made.
+// All calls are returned if no method is provided.
+func (s *Server) Calls(method string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var calls []Call
+	for _, c := range s.calls {
+		if method == "" || c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset forgets all calls made to the fake server along with any errors and
+// rate limits that have been set.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+	s.errors = make(map[string]string)
+	s.rateLimits = make(map[string]time.Duration)
+}
+
+// handle records the call and dispatches it to the API method requested.
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	var body []byte
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = b
+		r.Form = url.Values{}
+		if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" {
+			r.Form.Set("token", token)
+		}
+		fields := map[string]interface{}{}
+		json.Unmarshal(body, &fields)
+		for k, v := range fields {
+			if str, ok := v.(string); ok {
+				r.Form.Set(k, str)
+			}
+		}
+	} else if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	if strings.HasPrefix(r.URL.Path, "/response/") {
+		method = ResponseMethod
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, Call{Method: method, Values: r.Form, Body: body})
+
+	if d, ok := s.rateLimits[method]; ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(d/time.Second)))
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	if e, ok := s.errors[method]; ok {
+		reply(w, failure(e))
+		return
+	}
+
+	if method == ResponseMethod {
+		reply(w, success())
+		return
+	}
+
+	if method != "oauth.access" && r.Form.Get("token") == "" {
+		reply(w, failure("not_authed"))
+		return
+	}
+
+	switch method {
+	case "conversations.list":
+		reply(w, s.conversationsList(r.Form))
+	case "users.info":
+		reply(w, s.usersInfo(r.Form))
+	case "chat.getPermalink":
+		reply(w, s.chatGetPermalink(r.Form))
+	case "chat.postMessage":
+		reply(w, s.chatPostMessage(r.Form))
+	case "chat.postEphemeral":
+		reply(w, s.chatPostEphemeral(r.Form))
+	case "conversations.open":
+		reply(w, s.conversationsOpen(r.Form))
+	case "chat.delete":
+		reply(w, s.chatDelete(r.Form))
+	case "chat.update":
+		reply(w, s.chatUpdate(r.Form))
+	case "views.open":
+		reply(w, s.viewsOpen(body))
+	case "views.update":
+		reply(w, s.viewsUpdate(body))
+	case "oauth.access":
+		reply(w, s.oauthAccess(r))
+	default:
+		reply(w, failure("unknown_method"))
+	}
+}
+
+// response is the body of every reply. Fields are added for each method.
+type response map[string]interface{}
+
+func failure(e string) response {
+	return response{"ok": false, "error": e}
+}
+
+func success() response {
+	return response{"ok": true}
+}
+
+func reply(w http.ResponseWriter, r response) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r)
+}
+
+// conversationsList returns the channels of the types requested. Results are
+// paginated using the limit and cursor provided.
+func (s *Server) conversationsList(v url.Values) response {
+	types := v.Get("types")
+	if types == "" {
+		types = "public_channel"
+	}
+
+	var matches []Channel
+	for _, c := range s.channels {
+		if c.Private && strings.Contains(types, "private_channel") ||
+			!c.Private && strings.Contains(types, "public_channel") {
+			matches = append(matches, c)
+		}
+	}
+
+	start := 0
+	if cursor := v.Get("cursor"); cursor != "" {
+		n, err := strconv.Atoi(cursor)
+		if err != nil || n < 0 || n > len(matches) {
+			return failure("invalid_cursor")
+		}
+		start = n
+	}
+
+	end := len(matches)
+	if limit, err := strconv.Atoi(v.Get("limit")); err == nil && limit > 0 && start+limit < end {
+		end = start + limit
+	}
+
+	next := ""
+	if end < len(matches) {
+		next = strconv.Itoa(end)
+	}
+
+	channels := make([]response, 0, end-start)
+	for _, c := range matches[start:end] {
+		channels = append(channels, response{
+			"id":              c.ID,
+			"name":            c.Name,
+			"name_normalized": strings.ToLower(c.Name),
+			"is_private":      c.Private,
+			"is_member":       true,
+		})
+	}
+
+	r := success()
+	r["channels"] = channels
+	r["response_metadata"] = response{"next_cursor": next}
+	return r
+}
+
+func (s *Server) usersInfo(v url.Values) response {
+	u, ok := s.users[v.Get("user")]
+	if ok == false {
+		return failure("user_not_found")
+	}
+	// Slack only includes the locale if asked to.
+	if v.Get("include_locale") != "true" {
+		u.Locale = ""
+	}
+	r := success()
+	r["user"] = u
+	return r
+}
+
+func (s *Server) chatGetPermalink(v url.Values) response {
+	ch, ts := v.Get("channel"), v.Get("message_ts")
+	if ch == "" || ts == "" {
+		return failure("invalid_arguments")
+	}
+	r := success()
+	r["channel"] = ch
+	r["permalink"] = fmt.Sprintf("https://fake.slack.com/archives/%s/p%s", ch, strings.Replace(ts, ".", "", 1))
+	return r
+}
+
+// conversationsOpen opens a direct message with a single user. The same
+// conversation is returned each time it is opened with the same user.
+func (s *Server) conversationsOpen(v url.Values) response {
+	u := v.Get("users")
+	if u == "" || strings.Contains(u, ",") {
+		return failure("user_not_found")
+	}
+	r := success()
+	r["channel"] = map[string]interface{}{"id": "D" + u}
+	return r
+}
+
+func (s *Server) chatPostMessage(v url.Values) response {
+	ch := v.Get("channel")
+	if ch == "" {
+		return failure("channel_not_found")
+	}
+	r := success()
+	r["channel"] = ch
+	r["ts"] = s.timestamp()
+	return r
+}
+
+func (s *Server) chatPostEphemeral(v url.Values) response {
+	if v.Get("channel") == "" {
+		return failure("channel_not_found")
+	}
+	if v.Get("user") == "" {
+		return failure("user_not_found")
+	}
+	r := success()
+	r["message_ts"] = s.timestamp()
+	return r
+}
+
+func (s *Server) chatUpdate(v url.Values) response {
+	ch, ts := v.Get("channel"), v.Get("ts")
+	if ch == "" {
+		return failure("channel_not_found")
+	}
+	if ts == "" {
+		return failure("message_not_found")
+	}
+	r := success()
+	r["channel"] = ch
+	r["ts"] = ts
+	return r
+}
+
+func (s *Server) chatDelete(v url.Values) response {
+	ch, ts := v.Get("channel"), v.Get("ts")
+	if ch == "" {
+		return failure("channel_not_found")
+	}
+	if ts == "" {
+		return failure("message_not_found")
+	}
+	r := success()
+	r["channel"] = ch
+	r["ts"] = ts
+	return r
+}
+
+// viewsOpen opens a modal. The trigger must be provided but is not checked.
+func (s *Server) viewsOpen(body []byte) response {
+	req := struct {
+		TriggerID string                 `json:"trigger_id"`
+		View      map[string]interface{} `json:"view"`
+	}{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return failure("invalid_json")
+	}
+	if req.TriggerID == "" {
+		return failure("invalid_trigger_id")
+	}
+	if req.View == nil {
+		return failure("invalid_arguments")
+	}
+
+	s.seq++
+	req.View["id"] = fmt.Sprintf("V%08d", s.seq)
+	r := success()
+	r["view"] = req.View
+	return r
+}
+
+// viewsUpdate replaces the contents of a modal opened using views.open.
+func (s *Server) viewsUpdate(body []byte) response {
+	req := struct {
+		ViewID string                 `json:"view_id"`
+		View   map[string]interface{} `json:"view"`
+	}{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return failure("invalid_json")
+	}
+	if req.ViewID == "" || req.View == nil {
+		return failure("invalid_arguments")
+	}
+
+	var n int64
+	if _, err := fmt.Sscanf(req.ViewID, "V%d", &n); err != nil || n < 1 || n > s.seq {
+		return failure("not_found")
+	}
+
+	req.View["id"] = req.ViewID
+	r := success()
+	r["view"] = req.View
+	return r
+}
+
+// oauthAccess exchanges a temporary code for the access tokens set using
+// SetAuth. The client credentials must be provided using basic auth.
+func (s *Server) oauthAccess(req *http.Request) response {
+	if _, _, ok := req.BasicAuth(); ok == false && req.Form.Get("client_id") == "" {
+		return failure("invalid_client_id")
+	}
+	if req.Form.Get("code") == "" {
+		return failure("invalid_code")
+	}
+
+	b, err := json.Marshal(s.auth)
+	if err != nil {
+		return failure("internal_error")
+	}
+	r := response{}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return failure("internal_error")
+	}
+	r["ok"] = true
+	return r
+}
+
+// timestamp returns a unique message timestamp.
+func (s *Server) timestamp() string {
+	s.seq++
+	return fmt.Sprintf("%d.%06d", time.Now().Unix(), s.seq)
+}
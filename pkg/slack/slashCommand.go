@@ -0,0 +1,62 @@
+package slack
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SlashCommand is the message received from the Slack API when a user
+// invokes one of our slash commands.
+type SlashCommand struct {
+	TeamID      string `json:"team_id"`
+	TeamDomain  string `json:"team_domain"`
+	ChannelID   string `json:"channel_id"`
+	ChannelName string `json:"channel_name"`
+	UserID      string `json:"user_id"`
+	UserName    string `json:"user_name"`
+	Command     string `json:"command"`
+	Text        string `json:"text"`
+	ResponseURL string `json:"response_url"`
+	TriggerID   string `json:"trigger_id"`
+}
+
+// ParseSlashCommand parses the payload of a request, a string, and returns a
+// SlashCommand.
+func ParseSlashCommand(b string) (SlashCommand, error) {
+	sc := SlashCommand{}
+
+	form, err := url.ParseQuery(b)
+	if err != nil {
+		return sc, errors.Wrap(err, "failed to parse request body")
+	}
+
+	sc.Command = form.Get("command")
+	if sc.Command == "" {
+		return sc, errors.New("failed to parse request body: no command provided")
+	}
+
+	sc.TeamID = form.Get("team_id")
+	sc.TeamDomain = form.Get("team_domain")
+	sc.ChannelID = form.Get("channel_id")
+	sc.ChannelName = form.Get("channel_name")
+	sc.UserID = form.Get("user_id")
+	sc.UserName = form.Get("user_name")
+	sc.Text = form.Get("text")
+	sc.ResponseURL = form.Get("response_url")
+	sc.TriggerID = form.Get("trigger_id")
+	return sc, nil
+}
+
+// Subcommand returns the first word of the command text, in lower case, and
+// the remainder of the text. For example "/buddybot report some text" returns
+// "report" and "some text".
+func (sc SlashCommand) Subcommand() (string, string) {
+	txt := strings.TrimSpace(sc.Text)
+	i := strings.IndexAny(txt, " \t\n")
+	if i == -1 {
+		return strings.ToLower(txt), ""
+	}
+	return strings.ToLower(txt[:i]), strings.TrimSpace(txt[i:])
+}
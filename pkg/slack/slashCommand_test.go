@@ -0,0 +1,39 @@
+package slack
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseSlashCommand(t *testing.T) {
+	v := url.Values{}
+	v.Set("command", "/buddybot")
+	v.Set("text", "Report  someone was rude")
+	v.Set("team_id", "T1")
+	v.Set("channel_id", "C1")
+	v.Set("user_id", "U1")
+
+	sc, err := ParseSlashCommand(v.Encode())
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if sc.Command != "/buddybot" || sc.TeamID != "T1" || sc.ChannelID != "C1" || sc.UserID != "U1" {
+		t.Errorf("unexpected slash command: %+v", sc)
+	}
+
+	sub, txt := sc.Subcommand()
+	if sub != "report" {
+		t.Error("unexpected subcommand:", sub)
+	}
+	if txt != "someone was rude" {
+		t.Error("unexpected text:", txt)
+	}
+}
+
+func TestParseSlashCommandMissingCommand(t *testing.T) {
+	_, err := ParseSlashCommand("payload=%7B%7D")
+	if err == nil {
+		t.Error("expected an error for a request without a command")
+	}
+}
@@ -0,0 +1,61 @@
+package slack
+
+import (
+	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// Teams provides the Workspace for each team that has installed BuddyBot,
+// using the access tokens held in the data store. Every Workspace it returns
+// is created with the same options, e.g. the base URL of the Slack Web API.
+type Teams struct {
+	tokens  storage.Store
+	options []func(*Workspace) error
+	apiURL  string
+}
+
+// NewTeams takes the store holding team tokens and the options to apply to
+// each Workspace and returns a pointer to Teams. It returns an error if any
+// of the options provided can't be applied.
+func NewTeams(tokens storage.Store, options ...func(*Workspace) error) (*Teams, error) {
+	w := &Workspace{apiURL: DefaultAPIURL}
+	for _, option := range options {
+		if err := option(w); err != nil {
+			return nil, err
+		}
+	}
+	return &Teams{tokens: tokens, options: options, apiURL: w.apiURL}, nil
+}
+
+// APIURL returns the base URL of the Slack Web API used for every team.
+func (t *Teams) APIURL() string {
+	return t.apiURL
+}
+
+// Workspace takes a Slack Team ID and returns the Workspace for the team
+// along with its AuthRecord. It returns an error with a cause of
+// secrets.ErrUnknownTeam if BuddyBot isn't installed for the team.
+func (t *Teams) Workspace(teamID string) (*Workspace, secrets.AuthRecord, error) {
+	ar, err := secrets.GetTeamTokens(t.tokens, teamID)
+	if err != nil {
+		return nil, ar, errors.Wrap(err, "unable to fetch team tokens")
+	}
+
+	ws, err := New(ar.BotAccessToken, ar.AccessToken, ar.BotUserID, t.options...)
+	if err != nil {
+		return nil, ar, errors.Wrap(err, "unable to establish slack workspace")
+	}
+	return ws, ar, nil
+}
+
+// Add stores the access tokens for a team that has installed BuddyBot.
+func (t *Teams) Add(ar secrets.AuthRecord) error {
+	return secrets.SaveTeamTokens(t.tokens, ar)
+}
+
+// Remove deletes the access tokens for a team, e.g. once they have been
+// revoked. Workspaces are no longer available for the team.
+func (t *Teams) Remove(teamID string) error {
+	return secrets.DeleteTeamTokens(t.tokens, teamID)
+}
@@ -0,0 +1,63 @@
+package slack_test
+
+import (
+	"testing"
+
+	"github.com/billglover/bbot/pkg/secrets"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/slack/slacktest"
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+func TestTeams(t *testing.T) {
+	srv := slacktest.NewServer()
+	defer srv.Close()
+	srv.AddUser(slacktest.User{ID: "U1", Name: "someone"})
+
+	teams, err := slack.NewTeams(storage.NewMemory("uid"), slack.APIURL(srv.APIURL()))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if teams.APIURL() != srv.APIURL() {
+		t.Error("unexpected API URL:", teams.APIURL())
+	}
+
+	if _, _, err := teams.Workspace("T1"); errors.Cause(err) != secrets.ErrUnknownTeam {
+		t.Fatal("expected an unknown team:", err)
+	}
+
+	ar := secrets.AuthRecord{UID: "T1", TeamID: "T1", TeamName: "Team One", AccessToken: "xoxp-1", BotAccessToken: "xoxb-1", BotUserID: "B1"}
+	if err := teams.Add(ar); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	ws, got, err := teams.Workspace("T1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if got.TeamName != "Team One" {
+		t.Errorf("unexpected auth record: %+v", got)
+	}
+
+	// The Workspace uses the team's tokens and the API URL of the Teams.
+	if name, err := ws.UserName("U1"); err != nil || name != "someone" {
+		t.Errorf("unexpected user name: %q, %v", name, err)
+	}
+	if calls := srv.Calls("users.info"); len(calls) != 1 || calls[0].Values.Get("token") != "xoxb-1" {
+		t.Errorf("unexpected calls to users.info: %v", calls)
+	}
+
+	if err := teams.Remove("T1"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if _, _, err := teams.Workspace("T1"); errors.Cause(err) != secrets.ErrUnknownTeam {
+		t.Error("expected an unknown team once removed:", err)
+	}
+}
+
+func TestNewTeamsInvalidURL(t *testing.T) {
+	if _, err := slack.NewTeams(storage.NewMemory("uid"), slack.APIURL("not a url")); err == nil {
+		t.Error("expected an error for an invalid URL")
+	}
+}
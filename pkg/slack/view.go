@@ -0,0 +1,134 @@
+package slack
+
+import (
+	"encoding/json"
+	"net/url"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/pkg/errors"
+)
+
+// InteractionViewSubmission is the type of interaction Slack sends when a
+// user submits a modal.
+const InteractionViewSubmission = "view_submission"
+
+// View is a Slack modal. Modals are opened in response to a user interaction
+// and their contents sent back to us as a ViewSubmission. They are built from
+// the same blocks as messages.
+type View struct {
+	ID              string            `json:"id,omitempty"`
+	Type            string            `json:"type"`
+	CallbackID      string            `json:"callback_id,omitempty"`
+	Title           *messaging.Text   `json:"title,omitempty"`
+	Submit          *messaging.Text   `json:"submit,omitempty"`
+	Close           *messaging.Text   `json:"close,omitempty"`
+	PrivateMetadata string            `json:"private_metadata,omitempty"`
+	Blocks          []messaging.Block `json:"blocks,omitempty"`
+	State           *ViewState        `json:"state,omitempty"`
+}
+
+// ViewState holds the values entered by the user, keyed by block ID and then
+// action ID.
+type ViewState struct {
+	Values map[string]map[string]ViewStateValue `json:"values"`
+}
+
+// ViewStateValue is a value entered by the user. Values chosen from a select
+// menu are held in the SelectedOption.
+type ViewStateValue struct {
+	Type           string         `json:"type"`
+	Value          string         `json:"value"`
+	SelectedOption *messaging.Opt `json:"selected_option,omitempty"`
+}
+
+// ViewSubmission is the message received from the Slack API when a user
+// submits a modal.
+type ViewSubmission struct {
+	Type      string `json:"type"`
+	Team      Team   `json:"team"`
+	User      User   `json:"user"`
+	TriggerID string `json:"trigger_id"`
+	View      View   `json:"view"`
+}
+
+// Value takes the ID of a block and returns the value entered in the field
+// with the same ID, or the value of the option chosen if the field is a
+// select menu.
+func (vs ViewSubmission) Value(id string) string {
+	if vs.View.State == nil {
+		return ""
+	}
+	v := vs.View.State.Values[id][id]
+	if v.SelectedOption != nil {
+		return v.SelectedOption.Value
+	}
+	return v.Value
+}
+
+// InteractionType parses the payload of a request, a string, and returns the
+// type of interaction, e.g. "message_action" or "view_submission".
+func InteractionType(b string) (string, error) {
+	form, err := url.ParseQuery(b)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse request body")
+	}
+
+	p := struct {
+		Type string `json:"type"`
+	}{}
+	err = json.Unmarshal([]byte(form.Get("payload")), &p)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse request body")
+	}
+	return p.Type, nil
+}
+
+// ParseViewSubmission parses the payload of a request, a string, and returns a
+// ViewSubmission.
+func ParseViewSubmission(b string) (ViewSubmission, error) {
+	vs := ViewSubmission{}
+
+	form, err := url.ParseQuery(b)
+	if err != nil {
+		return vs, errors.Wrap(err, "failed to parse request body")
+	}
+
+	err = json.Unmarshal([]byte(form.Get("payload")), &vs)
+	if err != nil {
+		return vs, errors.Wrap(err, "failed to parse request body")
+	}
+	return vs, nil
+}
+
+// OpenView opens a modal for the user whose interaction provided the trigger
+// and returns the ID of the modal. Triggers expire a few seconds after the
+// interaction so modals that take longer to build should be opened straight
+// away and then updated.
+func (w *Workspace) OpenView(triggerID string, v View) (string, error) {
+	body := struct {
+		TriggerID string `json:"trigger_id"`
+		View      View   `json:"view"`
+	}{triggerID, v}
+
+	resp := struct {
+		View View `json:"view"`
+	}{}
+	if err := w.call("views.open", body, &resp); err != nil {
+		return "", errors.Wrap(err, "unable to open view")
+	}
+	return resp.View.ID, nil
+}
+
+// UpdateView takes the ID of an open modal and replaces its contents with
+// the view provided.
+func (w *Workspace) UpdateView(viewID string, v View) error {
+	body := struct {
+		ViewID string `json:"view_id"`
+		View   View   `json:"view"`
+	}{viewID, v}
+
+	if err := w.call("views.update", body, nil); err != nil {
+		return errors.Wrap(err, "unable to update view")
+	}
+	return nil
+}
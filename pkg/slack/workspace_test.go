@@ -0,0 +1,151 @@
+package slack_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/billglover/bbot/pkg/messaging"
+	"github.com/billglover/bbot/pkg/slack"
+	"github.com/billglover/bbot/pkg/slack/slacktest"
+)
+
+func TestAdminChannelID(t *testing.T) {
+	srv := slacktest.NewServer()
+	defer srv.Close()
+
+	// Enough channels that the admins channels are only found by paging
+	// through the results.
+	for i := 0; i < 450; i++ {
+		srv.AddChannel(slacktest.Channel{ID: fmt.Sprintf("G%07d", i), Name: fmt.Sprintf("private-%d", i), Private: true})
+	}
+	srv.AddChannel(slacktest.Channel{ID: "C0000001", Name: "mods"})
+	srv.AddChannel(slacktest.Channel{ID: "GADMINS1", Name: "admins", Private: true})
+	srv.AddChannel(slacktest.Channel{ID: "CADMINS2", Name: "public-admins"})
+
+	ws, err := slack.New("xoxb-1", "xoxp-1", "B1", slack.APIURL(srv.APIURL()))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	tcs := []struct {
+		name       string
+		configured string
+		want       string
+		calls      int
+		err        bool
+	}{
+		{name: "discovered", configured: "", want: "GADMINS1", calls: 3},
+		{name: "by name", configured: "mods", want: "C0000001", calls: 3},
+		{name: "by hash name", configured: "#public-admins", want: "CADMINS2", calls: 3},
+		{name: "by mixed case name", configured: "#Public-Admins", want: "CADMINS2", calls: 3},
+		{name: "by id", configured: "G1234567", want: "G1234567", calls: 0},
+		{name: "missing", configured: "nope", err: true, calls: 3},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			srv.Reset()
+
+			got, err := ws.AdminChannelID(tc.configured)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+			} else if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+			if calls := srv.Calls("conversations.list"); len(calls) != tc.calls {
+				t.Errorf("unexpected number of calls to conversations.list: %d", len(calls))
+			}
+		})
+	}
+}
+
+func TestUserLocale(t *testing.T) {
+	srv := slacktest.NewServer()
+	defer srv.Close()
+	srv.AddUser(slacktest.User{ID: "U1", Name: "alice", Locale: "fr-FR"})
+
+	ws, err := slack.New("xoxb-1", "xoxp-1", "B1", slack.APIURL(srv.APIURL()))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	locale, err := ws.UserLocale("U1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if locale != "fr-FR" {
+		t.Error("unexpected locale:", locale)
+	}
+
+	calls := srv.Calls("users.info")
+	if len(calls) != 1 || calls[0].Values.Get("token") != "xoxb-1" {
+		t.Errorf("unexpected calls to users.info: %v", calls)
+	}
+
+	if _, err := ws.UserLocale("U2"); err == nil {
+		t.Error("expected an error for an unknown user")
+	}
+}
+
+func TestSendMessageBlocks(t *testing.T) {
+	srv := slacktest.NewServer()
+	defer srv.Close()
+
+	ws, err := slack.New("xoxb-1", "xoxp-1", "B1", slack.APIURL(srv.APIURL()))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	m := messaging.Message{
+		Text: "Message Flagged",
+		Blocks: []messaging.Block{
+			messaging.Section("*Message Flagged*"),
+			messaging.Divider(),
+			messaging.Actions("report-1", messaging.Button("ack", "Acknowledge", "r1", "primary")),
+		},
+	}
+
+	tcs := []struct {
+		name   string
+		e      messaging.Envelope
+		method string
+	}{
+		{name: "channel", e: messaging.Envelope{Destination: messaging.Address{TeamID: "T1", ChannelID: "C1"}, Message: m}, method: "chat.postMessage"},
+		{name: "ephemeral", e: messaging.Envelope{Destination: messaging.Address{TeamID: "T1", ChannelID: "C1", UserID: "U1"}, Delivery: messaging.DeliveryEphemeral, Message: m}, method: "chat.postEphemeral"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			srv.Reset()
+
+			if _, _, err := ws.SendMessage(tc.e); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			calls := srv.Calls(tc.method)
+			if len(calls) != 1 {
+				t.Fatalf("unexpected calls to %s: %v", tc.method, calls)
+			}
+			if calls[0].Values.Get("channel") != "C1" || calls[0].Values.Get("text") != "Message Flagged" {
+				t.Errorf("unexpected arguments: %v", calls[0].Values)
+			}
+
+			body := struct {
+				Blocks []messaging.Block `json:"blocks"`
+			}{}
+			if err := json.Unmarshal(calls[0].Body, &body); err != nil {
+				t.Fatal("unable to decode request:", err)
+			}
+			if reflect.DeepEqual(body.Blocks, m.Blocks) == false {
+				t.Errorf("unexpected blocks: %s", calls[0].Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Package standing keeps track of the warnings each person has been given by the
+admins of a team. Every warning confirms that a message the person posted did
+not comply with the Code of Conduct, and the more violations a person has the
+sterner the next warning becomes.
+*/
+package standing
+
+import (
+	"time"
+
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// Level is how stern a warning is.
+type Level string
+
+// People are given a first notice for their first violation, a formal warning
+// for their second and a final warning for every violation after that.
+const (
+	LevelNotice Level = "notice"
+	LevelFormal Level = "formal"
+	LevelFinal  Level = "final"
+)
+
+// Ladder lists the levels of warning in the order they are given.
+var Ladder = []Level{LevelNotice, LevelFormal, LevelFinal}
+
+// String describes the level, e.g. "formal warning".
+func (l Level) String() string {
+	switch l {
+	case LevelNotice:
+		return "first notice"
+	case LevelFormal:
+		return "formal warning"
+	case LevelFinal:
+		return "final warning"
+	}
+	return string(l)
+}
+
+// attempts is the number of times we try to update a standing before giving
+// up. Attempts only fail if the standing was changed at the same time, e.g.
+// by two admins warning the same person about different reports.
+const attempts = 5
+
+// Warning records an admin warning someone about a message they posted.
+type Warning struct {
+	ReportID string    `json:"report_id"`
+	Level    Level     `json:"level"`
+	WarnedBy string    `json:"warned_by"`
+	Warned   time.Time `json:"warned"`
+}
+
+// Standing represents the warnings given to someone in a team that we store
+// in the data store. The version is incremented every time the standing is
+// saved so that concurrent warnings are detected rather than lost.
+type Standing struct {
+	UID      string    `json:"uid"`
+	TeamID   string    `json:"team_id"`
+	UserID   string    `json:"user_id"`
+	Warnings []Warning `json:"warnings,omitempty"`
+	Updated  time.Time `json:"updated"`
+	Version  int       `json:"version,omitempty"`
+}
+
+// ID returns the identifier used to store the Standing of a user in a team.
+func ID(teamID, userID string) string {
+	return "standing:" + teamID + ":" + userID
+}
+
+// Get takes a Team ID and User ID and returns the stored Standing of the
+// user. If the user has never been warned an empty Standing is returned. It
+// returns an error if unable to retrieve the standing.
+func Get(db storage.Store, teamID, userID string) (Standing, error) {
+	s := Standing{}
+	err := db.Retrieve("uid", ID(teamID, userID), &s)
+	if errors.Cause(err) == storage.ErrNotFound {
+		return Standing{UID: ID(teamID, userID), TeamID: teamID, UserID: userID}, nil
+	}
+	return s, err
+}
+
+// Save takes a Standing and stores it. The standing is only stored if it
+// hasn't been changed since it was read. It returns an error with a cause of
+// storage.ErrConditionFailed if it has, and an error if unable to store the
+// standing in the database.
+func Save(db storage.Store, s Standing) error {
+	if s.TeamID == "" || s.UserID == "" {
+		return errors.New("standing must have a team ID and user ID")
+	}
+	s.UID = ID(s.TeamID, s.UserID)
+
+	// Standings stored before they were versioned have no version.
+	cond := storage.Absent("version")
+	if s.Version > 0 {
+		cond = storage.Equal("version", s.Version)
+	}
+	s.Version++
+	return db.SaveIf(s, cond)
+}
+
+// Update takes a Team ID, User ID and a function that changes the standing of
+// the user. The function is given the stored standing and returns false if
+// there is nothing to save. If the standing is changed by someone else before
+// it is saved, the function is called again with their changes. Update
+// returns the standing as saved. It returns an error if unable to retrieve or
+// store the standing.
+func Update(db storage.Store, teamID, userID string, change func(*Standing) bool) (Standing, error) {
+	for i := 0; i < attempts; i++ {
+		s, err := Get(db, teamID, userID)
+		if err != nil {
+			return s, errors.Wrap(err, "unable to retrieve standing")
+		}
+
+		if change(&s) == false {
+			return s, nil
+		}
+
+		err = Save(db, s)
+		if errors.Cause(err) == storage.ErrConditionFailed {
+			continue
+		}
+		if err != nil {
+			return s, errors.Wrap(err, "unable to save standing")
+		}
+		s.Version++
+		return s, nil
+	}
+	return Standing{}, errors.Errorf("unable to update standing of %s in team %s after %d attempts", userID, teamID, attempts)
+}
+
+// Violations returns the number of reports the user has been warned about.
+func (s Standing) Violations() int {
+	return len(s.Warnings)
+}
+
+// Next returns the level of the next warning the user will be given.
+func (s Standing) Next() Level {
+	n := s.Violations()
+	if n >= len(Ladder) {
+		n = len(Ladder) - 1
+	}
+	return Ladder[n]
+}
+
+// Warn records a warning given by an admin about the report provided at the
+// time provided and returns its level. Someone is only warned once about
+// each report, so warning them about the same report again returns the level
+// of the earlier warning without recording another.
+func (s *Standing) Warn(reportID, warnedBy string, at time.Time) Level {
+	for _, w := range s.Warnings {
+		if w.ReportID == reportID {
+			return w.Level
+		}
+	}
+
+	l := s.Next()
+	s.Warnings = append(s.Warnings, Warning{ReportID: reportID, Level: l, WarnedBy: warnedBy, Warned: at})
+	s.Updated = at
+	return l
+}
+
+// Withdraw removes the warning about the report provided, if there is one,
+// and reports whether there was. Warnings are withdrawn if the report
+// couldn't be resolved after the warning was recorded.
+func (s *Standing) Withdraw(reportID string) bool {
+	for i, w := range s.Warnings {
+		if w.ReportID == reportID {
+			s.Warnings = append(s.Warnings[:i], s.Warnings[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package standing
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/billglover/bbot/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+func TestWarn(t *testing.T) {
+	s := Standing{TeamID: "T1", UserID: "U1"}
+	if s.Next() != LevelNotice {
+		t.Error("unexpected first level:", s.Next())
+	}
+
+	want := []Level{LevelNotice, LevelFormal, LevelFinal, LevelFinal}
+	for i, l := range want {
+		id := []string{"a", "b", "c", "d"}[i]
+		if got := s.Warn(id, "U2", time.Now()); got != l {
+			t.Errorf("warning %d: unexpected level: %s", i, got)
+		}
+	}
+
+	// Warning about the same report again doesn't escalate.
+	if got := s.Warn("b", "U2", time.Now()); got != LevelFormal {
+		t.Error("unexpected level for a repeat warning:", got)
+	}
+	if s.Violations() != len(want) {
+		t.Error("unexpected number of violations:", s.Violations())
+	}
+}
+
+func TestGetSave(t *testing.T) {
+	db := storage.NewMemory("uid")
+
+	s, err := Get(db, "T1", "U1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s.TeamID != "T1" || s.UserID != "U1" || s.Violations() != 0 {
+		t.Errorf("unexpected standing for a user never warned: %+v", s)
+	}
+
+	s.Warn("a", "U2", time.Now())
+	if err := Save(db, s); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	got, err := Get(db, "T1", "U1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if got.UID != ID("T1", "U1") || got.Violations() != 1 || got.Next() != LevelFormal {
+		t.Errorf("unexpected standing: %+v", got)
+	}
+
+	if err := Save(db, Standing{TeamID: "T1"}); err == nil {
+		t.Error("expected an error saving a standing without a user")
+	}
+}
+
+func TestSaveConflict(t *testing.T) {
+	db := storage.NewMemory("uid")
+
+	s, err := Get(db, "T1", "U1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := Save(db, s); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// The standing read before it was saved is out of date.
+	s.Warn("a", "U2", time.Now())
+	if err := Save(db, s); errors.Cause(err) != storage.ErrConditionFailed {
+		t.Error("expected a condition failure, got:", err)
+	}
+}
+
+func TestUpdateConcurrent(t *testing.T) {
+	db := storage.NewMemory("uid")
+
+	// Admins warning the same person about different reports at the same time
+	// each give a different level of warning.
+	var wg sync.WaitGroup
+	levels := make([]Level, 3)
+	for i := range levels {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := Update(db, "T1", "U1", func(s *Standing) bool {
+				levels[i] = s.Warn(fmt.Sprintf("report-%d", i), "U2", time.Now())
+				return true
+			})
+			if err != nil {
+				t.Error("unexpected error:", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	s, err := Get(db, "T1", "U1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if s.Violations() != 3 {
+		t.Fatal("unexpected number of violations:", s.Violations())
+	}
+	given := map[Level]bool{}
+	for _, l := range levels {
+		given[l] = true
+	}
+	if len(given) != 3 {
+		t.Error("unexpected levels given:", levels)
+	}
+}
+
+func TestWithdraw(t *testing.T) {
+	s := Standing{TeamID: "T1", UserID: "U1"}
+	s.Warn("a", "U2", time.Now())
+	s.Warn("b", "U2", time.Now())
+
+	if s.Withdraw("c") {
+		t.Error("withdrew a warning that was never given")
+	}
+	if s.Withdraw("a") == false || s.Violations() != 1 || s.Warnings[0].ReportID != "b" {
+		t.Errorf("unexpected warnings: %+v", s.Warnings)
+	}
+
+	// The next warning takes account of the withdrawn one.
+	if s.Next() != LevelFormal {
+		t.Error("unexpected next level:", s.Next())
+	}
+}
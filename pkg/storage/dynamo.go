@@ -2,13 +2,14 @@ package storage
 
 import (
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/pkg/errors"
 )
 
-// DynamoDB represents a DynamoDB table.
+// DynamoDB represents a DynamoDB table. It implements the Store interface.
 type DynamoDB struct {
 	Region string
 	Table  string
@@ -17,15 +18,11 @@ type DynamoDB struct {
 // Save stores a record in DynamoDB. It takes an interface and returns an error
 // if unable to save the record.
 func (d *DynamoDB) Save(v interface{}) error {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(d.Region)},
-	)
+	ddb, err := d.client()
 	if err != nil {
-		return errors.Wrap(err, "unable to open session")
+		return err
 	}
 
-	ddb := dynamodb.New(sess)
-
 	value, err := dynamodbattribute.MarshalMap(v)
 	if err != nil {
 		return errors.Wrap(err, "unable to marshal value")
@@ -43,21 +40,61 @@ func (d *DynamoDB) Save(v interface{}) error {
 	return nil
 }
 
-// Retrieve returns a record from DynamoDb. It takes a region, table name, key,
-// an ID, and an interface. It returns an error if unable to retrieve the value.
-func (d *DynamoDB) Retrieve(k, id string, v interface{}) error {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(d.Region)},
-	)
+// SaveIf stores a record in DynamoDB if the existing record meets the
+// condition. It returns ErrConditionFailed if the condition isn't met and an
+// error if unable to save the record.
+func (d *DynamoDB) SaveIf(v interface{}, c Condition) error {
+	ddb, err := d.client()
+	if err != nil {
+		return err
+	}
+
+	value, err := dynamodbattribute.MarshalMap(v)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal value")
+	}
+
+	record := &dynamodb.PutItemInput{
+		Item:                     value,
+		TableName:                aws.String(d.Table),
+		ExpressionAttributeNames: map[string]*string{"#f": aws.String(c.Field)},
+	}
+
+	if c.absent {
+		record.ConditionExpression = aws.String("attribute_not_exists(#f)")
+	} else {
+		cv, err := dynamodbattribute.Marshal(c.Value)
+		if err != nil {
+			return errors.Wrap(err, "unable to marshal condition value")
+		}
+		record.ConditionExpression = aws.String("#f = :v")
+		record.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":v": cv}
+	}
+
+	_, err = ddb.PutItem(record)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrConditionFailed
+	}
 	if err != nil {
-		return errors.Wrap(err, "unable to open session")
+		return errors.Wrap(err, "unable to save record")
 	}
 
-	ddb := dynamodb.New(sess)
+	return nil
+}
+
+// Retrieve returns a record from DynamoDb. It takes a key, an ID, and an
+// interface. Reads are strongly consistent so that records are never older
+// than the last save. It returns an error if unable to retrieve the value.
+func (d *DynamoDB) Retrieve(k, id string, v interface{}) error {
+	ddb, err := d.client()
+	if err != nil {
+		return err
+	}
 
 	request := &dynamodb.GetItemInput{
-		TableName: aws.String(d.Table),
-		Key:       map[string]*dynamodb.AttributeValue{k: {S: aws.String(id)}},
+		TableName:      aws.String(d.Table),
+		Key:            map[string]*dynamodb.AttributeValue{k: {S: aws.String(id)}},
+		ConsistentRead: aws.Bool(true),
 	}
 
 	record, err := ddb.GetItem(request)
@@ -66,7 +103,7 @@ func (d *DynamoDB) Retrieve(k, id string, v interface{}) error {
 	}
 
 	if len(record.Item) == 0 {
-		return errors.New("no auth token exists for team: " + id)
+		return errors.Wrap(ErrNotFound, k+": "+id)
 	}
 
 	if err := dynamodbattribute.UnmarshalMap(record.Item, v); err != nil {
@@ -75,3 +112,77 @@ func (d *DynamoDB) Retrieve(k, id string, v interface{}) error {
 
 	return nil
 }
+
+// Delete removes a record from DynamoDB. It takes a key and an ID. Deleting a
+// record that doesn't exist is not an error. It returns an error if unable to
+// delete the record.
+func (d *DynamoDB) Delete(k, id string) error {
+	ddb, err := d.client()
+	if err != nil {
+		return err
+	}
+
+	request := &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.Table),
+		Key:       map[string]*dynamodb.AttributeValue{k: {S: aws.String(id)}},
+	}
+
+	if _, err := ddb.DeleteItem(request); err != nil {
+		return errors.Wrap(err, "unable to delete record")
+	}
+
+	return nil
+}
+
+// Query returns all records from a DynamoDB index where the attribute has
+// the value provided. It takes the name of the index, an attribute name, a
+// value and a pointer to a slice. It returns an error if unable to query the
+// index.
+func (d *DynamoDB) Query(index, k, id string, v interface{}) error {
+	ddb, err := d.client()
+	if err != nil {
+		return err
+	}
+
+	request := &dynamodb.QueryInput{
+		TableName:                 aws.String(d.Table),
+		IndexName:                 aws.String(index),
+		KeyConditionExpression:    aws.String("#k = :v"),
+		ExpressionAttributeNames:  map[string]*string{"#k": aws.String(k)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":v": {S: aws.String(id)}},
+	}
+
+	// Results are returned a page at a time so we keep querying until there
+	// are no more pages.
+	var items []map[string]*dynamodb.AttributeValue
+	for {
+		page, err := ddb.Query(request)
+		if err != nil {
+			return errors.Wrap(err, "unable to query index")
+		}
+		items = append(items, page.Items...)
+
+		if len(page.LastEvaluatedKey) == 0 {
+			break
+		}
+		request.ExclusiveStartKey = page.LastEvaluatedKey
+	}
+
+	if err := dynamodbattribute.UnmarshalListOfMaps(items, v); err != nil {
+		return errors.Wrap(err, "unable to unmarshal values")
+	}
+
+	return nil
+}
+
+// client opens a session and returns a DynamoDB client for the region.
+func (d *DynamoDB) client() (*dynamodb.DynamoDB, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(d.Region)},
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open session")
+	}
+
+	return dynamodb.New(sess), nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// File is a table of records held in memory and written to a single JSON
+// file after every change. It implements the Store interface and is intended
+// for running BuddyBot locally, where records should survive a restart.
+type File struct {
+	*Memory
+	path string
+}
+
+// NewFile returns a File table stored at the path provided. Existing records
+// are loaded from the file if it exists. Records are identified by the field
+// named by the key, e.g. "uid". It returns an error if unable to read the
+// file.
+func NewFile(path, key string) (*File, error) {
+	f := &File{
+		Memory: NewMemory(key),
+		path:   path,
+	}
+	f.Memory.persist = f.write
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read store")
+	}
+
+	if len(b) == 0 {
+		return f, nil
+	}
+	if err := json.Unmarshal(b, &f.Memory.records); err != nil {
+		return nil, errors.Wrap(err, "unable to parse store")
+	}
+	return f, nil
+}
+
+// write replaces the file with the current records. The records are written
+// to a temporary file first so that a failed write doesn't lose them.
+func (f *File) write() error {
+	b, err := json.MarshalIndent(f.Memory.records, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal store")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path))
+	if err != nil {
+		return errors.Wrap(err, "unable to write store")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "unable to write store")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "unable to write store")
+	}
+
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return errors.Wrap(err, "unable to write store")
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Memory is a table of records held in memory. It implements the Store
+// interface and is safe for concurrent use. Indexes are not maintained, so
+// queries scan every record.
+type Memory struct {
+	mu      sync.Mutex
+	key     string
+	records map[string]map[string]interface{}
+
+	// persist is called with the lock held after every change.
+	persist func() error
+}
+
+// NewMemory returns an empty Memory table. Records are identified by the
+// field named by the key, e.g. "uid".
+func NewMemory(key string) *Memory {
+	return &Memory{
+		key:     key,
+		records: make(map[string]map[string]interface{}),
+	}
+}
+
+// Save stores a record, replacing any existing record with the same key.
+func (m *Memory) Save(v interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, r, err := m.record(v)
+	if err != nil {
+		return err
+	}
+	return m.put(id, r)
+}
+
+// SaveIf stores a record if the existing record meets the condition. It
+// returns ErrConditionFailed if the condition isn't met.
+func (m *Memory) SaveIf(v interface{}, c Condition) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, r, err := m.record(v)
+	if err != nil {
+		return err
+	}
+
+	current, ok := m.records[id][c.Field]
+	if c.absent {
+		if ok {
+			return ErrConditionFailed
+		}
+		return m.put(id, r)
+	}
+
+	want, err := normalise(c.Value)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal condition value")
+	}
+	if ok == false || reflect.DeepEqual(current, want) == false {
+		return ErrConditionFailed
+	}
+	return m.put(id, r)
+}
+
+// Retrieve takes a key, an ID and a pointer to a record. It returns
+// ErrNotFound if no record exists.
+func (m *Memory) Retrieve(k, id string, v interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if k != m.key {
+		return errors.Errorf("unable to retrieve record by %s, records are keyed by %s", k, m.key)
+	}
+
+	r, ok := m.records[id]
+	if ok == false {
+		return errors.Wrap(ErrNotFound, k+": "+id)
+	}
+	return convert(r, v)
+}
+
+// Delete takes a key and an ID and removes the record.
+func (m *Memory) Delete(k, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if k != m.key {
+		return errors.Errorf("unable to delete record by %s, records are keyed by %s", k, m.key)
+	}
+
+	if _, ok := m.records[id]; ok == false {
+		return nil
+	}
+	delete(m.records, id)
+	return m.save()
+}
+
+// Query fills the slice pointed to by v with all records where the attribute
+// k has the value id, ordered by key. The index name is ignored.
+func (m *Memory) Query(index, k, id string, v interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]string, 0, len(m.records))
+	for i, r := range m.records {
+		if r[k] == id {
+			ids = append(ids, i)
+		}
+	}
+	sort.Strings(ids)
+
+	rs := make([]map[string]interface{}, len(ids))
+	for i, id := range ids {
+		rs[i] = m.records[id]
+	}
+	return convert(rs, v)
+}
+
+// record marshals a value into the form in which it is stored and returns
+// its ID.
+func (m *Memory) record(v interface{}) (string, map[string]interface{}, error) {
+	r := make(map[string]interface{})
+	if err := convert(v, &r); err != nil {
+		return "", nil, errors.Wrap(err, "unable to marshal value")
+	}
+
+	id, ok := r[m.key].(string)
+	if ok == false || id == "" {
+		return "", nil, errors.Errorf("record must have a %s", m.key)
+	}
+	return id, r, nil
+}
+
+// put stores the record and persists the change.
+func (m *Memory) put(id string, r map[string]interface{}) error {
+	m.records[id] = r
+	return m.save()
+}
+
+// save persists the records if the table is backed by storage.
+func (m *Memory) save() error {
+	if m.persist == nil {
+		return nil
+	}
+	return m.persist()
+}
+
+// convert copies the value of src into dst, a pointer, via its JSON encoding.
+func convert(src, dst interface{}) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// normalise returns a value in the form in which it would be stored so that
+// it can be compared with stored values.
+func normalise(v interface{}) (interface{}, error) {
+	var n interface{}
+	err := convert(v, &n)
+	return n, err
+}
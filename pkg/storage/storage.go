@@ -0,0 +1,70 @@
+/*
+Package storage provides persistence for the records BuddyBot holds, e.g. the
+access tokens for each team. Records are saved and retrieved through the Store
+interface which is implemented by DynamoDB for use in AWS, and by Memory and
+File for use in tests and when running locally.
+
+Records are marshalled using their JSON field tags.
+*/
+package storage
+
+import "github.com/pkg/errors"
+
+// Store represents a table of records identified by a key.
+type Store interface {
+	// Save stores a record, replacing any existing record with the same key.
+	Save(v interface{}) error
+
+	// SaveIf stores a record only if the existing record meets the
+	// condition. It returns ErrConditionFailed if the condition isn't met.
+	SaveIf(v interface{}, c Condition) error
+
+	// Retrieve takes a key, an ID and a pointer to a record. The record
+	// reflects every save that completed before it was retrieved. It returns
+	// ErrNotFound if no record exists.
+	Retrieve(k, id string, v interface{}) error
+
+	// Delete takes a key and an ID and removes the record. Deleting a
+	// record that doesn't exist is not an error.
+	Delete(k, id string) error
+
+	// Query takes the name of an index, an attribute name and a value, and
+	// a pointer to a slice of records. It fills the slice with all records
+	// where the attribute has the value.
+	Query(index, k, id string, v interface{}) error
+}
+
+// Ensure each of our tables implements the Store interface.
+var (
+	_ Store = (*DynamoDB)(nil)
+	_ Store = (*Memory)(nil)
+	_ Store = (*File)(nil)
+)
+
+// ErrNotFound is returned when there is no record for the requested key.
+var ErrNotFound = errors.New("no record exists")
+
+// ErrConditionFailed is returned when a record is not saved because the
+// existing record does not meet the condition.
+var ErrConditionFailed = errors.New("condition not met")
+
+// Condition is a requirement placed on the existing record when saving a
+// record with SaveIf.
+type Condition struct {
+	Field  string
+	Value  interface{}
+	absent bool
+}
+
+// Absent returns a Condition requiring that the existing record doesn't have
+// the field. When the field is the key, this requires that there is no
+// existing record.
+func Absent(field string) Condition {
+	return Condition{Field: field, absent: true}
+}
+
+// Equal returns a Condition requiring that the existing record has the field
+// and that it is equal to the value provided.
+func Equal(field string, v interface{}) Condition {
+	return Condition{Field: field, Value: v}
+}
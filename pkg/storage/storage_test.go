@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type record struct {
+	UID     string `json:"uid"`
+	TeamID  string `json:"team_id"`
+	Version int    `json:"version"`
+}
+
+// testStore exercises a Store that starts out empty.
+func testStore(t *testing.T, s Store) {
+	a := record{UID: "a", TeamID: "T1", Version: 1}
+	b := record{UID: "b", TeamID: "T1", Version: 1}
+	c := record{UID: "c", TeamID: "T2", Version: 1}
+
+	got := record{}
+	if err := s.Retrieve("uid", "a", &got); errors.Cause(err) != ErrNotFound {
+		t.Fatal("unexpected error retrieving missing record:", err)
+	}
+
+	for _, r := range []record{a, b, c} {
+		if err := s.Save(r); err != nil {
+			t.Fatal("unexpected error saving record:", err)
+		}
+	}
+
+	if err := s.Retrieve("uid", "a", &got); err != nil {
+		t.Fatal("unexpected error retrieving record:", err)
+	}
+	if got != a {
+		t.Errorf("unexpected record: %+v", got)
+	}
+
+	var rs []record
+	if err := s.Query("team_id-index", "team_id", "T1", &rs); err != nil {
+		t.Fatal("unexpected error querying records:", err)
+	}
+	if reflect.DeepEqual(rs, []record{a, b}) == false {
+		t.Errorf("unexpected records: %+v", rs)
+	}
+
+	if err := s.SaveIf(a, Absent("uid")); err != ErrConditionFailed {
+		t.Error("unexpected error saving existing record if absent:", err)
+	}
+	if err := s.SaveIf(record{UID: "d"}, Absent("uid")); err != nil {
+		t.Error("unexpected error saving new record if absent:", err)
+	}
+
+	a2 := record{UID: "a", TeamID: "T1", Version: 2}
+	if err := s.SaveIf(a2, Equal("version", 1)); err != nil {
+		t.Error("unexpected error saving record at expected version:", err)
+	}
+	if err := s.SaveIf(a2, Equal("version", 1)); err != ErrConditionFailed {
+		t.Error("unexpected error saving record at old version:", err)
+	}
+
+	if err := s.Delete("uid", "a"); err != nil {
+		t.Fatal("unexpected error deleting record:", err)
+	}
+	if err := s.Delete("uid", "a"); err != nil {
+		t.Fatal("unexpected error deleting missing record:", err)
+	}
+	if err := s.Retrieve("uid", "a", &got); errors.Cause(err) != ErrNotFound {
+		t.Error("unexpected error retrieving deleted record:", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory("uid"))
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal("unexpected error creating directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "store.json")
+	f, err := NewFile(path, "uid")
+	if err != nil {
+		t.Fatal("unexpected error opening store:", err)
+	}
+	testStore(t, f)
+
+	// Records should be loaded when the store is reopened.
+	f, err = NewFile(path, "uid")
+	if err != nil {
+		t.Fatal("unexpected error reopening store:", err)
+	}
+	got := record{}
+	if err := f.Retrieve("uid", "b", &got); err != nil {
+		t.Fatal("unexpected error retrieving record:", err)
+	}
+	if got.TeamID != "T1" {
+		t.Errorf("unexpected record: %+v", got)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Package templates renders the text of the notifications BuddyBot sends. Each
+notification has a template, a text/template file in the templates directory
+named after the notification, e.g. "reporter.txt". Templates are executed with
+Data which describes the people and message involved:
+
+	{{.Team}}       name of the Slack workspace
+	{{.Reporter}}   name of the person who flagged the message
+	{{.ReporterID}} Slack ID of the reporter, e.g. <@{{.ReporterID}}> mentions them
+	{{.Author}}     name of the person who posted the message
+	{{.AuthorID}}   Slack ID of the author
+	{{.Channel}}    name of the channel the message was posted in
+	{{.ChannelID}}  Slack ID of the channel
+	{{.Permalink}}  link to the message
+	{{.CoCURL}}     link to the team's Code of Conduct, empty if not set
+	{{.Warning}}    how stern a warning to the author is, e.g. "formal warning"
+
+A template may have variants for different locales, named after the Slack
+locale or its language, e.g. "reporter.fr-FR.txt" or "reporter.fr.txt". The
+variant matching the locale of the person being notified is used if there is
+one. Teams may also provide their own wording for a notification, which is
+used in place of the template files for every locale.
+
+Templates are parsed once, when loaded or first used, and then cached.
+*/
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultDir is the directory templates are loaded from, relative to the
+// working directory of each function.
+const DefaultDir = "templates"
+
+// The notifications BuddyBot sends. Each is the name of a template. Authors
+// warned by the admins are sent the Warning the first time, then the
+// WarningFormal and after that the WarningFinal.
+const (
+	Reporter      = "reporter"
+	Author        = "author"
+	Warning       = "warning"
+	WarningFormal = "warning_formal"
+	WarningFinal  = "warning_final"
+	Admins        = "admins"
+)
+
+// Data is made available to templates when they are executed. Anonymous is
+// true if the reporter is hidden from the admins.
+type Data struct {
+	Team       string
+	Reporter   string
+	ReporterID string
+	Author     string
+	AuthorID   string
+	Channel    string
+	ChannelID  string
+	Permalink  string
+	CoCURL     string
+	Warning    string
+	Anonymous  bool
+}
+
+// Set holds the parsed templates along with any team wording that has been
+// parsed. Use Load to create a Set. The zero value is an empty Set for which
+// every Render returns an error.
+type Set struct {
+	files map[string]*template.Template
+
+	mu        sync.Mutex
+	overrides map[string]*template.Template
+}
+
+// Load parses every template in the directory provided and returns a Set
+// containing them. It returns an error if unable to read or parse a
+// template.
+func Load(dir string) (*Set, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list templates")
+	}
+
+	s := &Set{files: make(map[string]*template.Template, len(paths))}
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read template")
+		}
+
+		name := strings.TrimSuffix(filepath.Base(p), ".txt")
+		t, err := template.New(name).Parse(string(b))
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse template %s", name)
+		}
+		s.files[name] = t
+	}
+	return s, nil
+}
+
+// Check takes the wording a team wants to use for a notification and returns
+// an error if it isn't a valid template.
+func Check(txt string) error {
+	t, err := template.New("check").Parse(txt)
+	if err != nil {
+		return err
+	}
+	return t.Execute(ioutil.Discard, Data{})
+}
+
+// Render executes the template with the name provided using the Data given.
+// The variant for the locale is used if there is one. If override is not
+// empty it is used as the template instead, allowing teams to change the
+// wording of a notification. If the override can't be used the template
+// files are used instead. It returns an error if there is no template with
+// the name provided.
+func (s *Set) Render(name, locale, override string, d Data) (string, error) {
+	if override != "" {
+		txt, err := s.renderOverride(override, d)
+		if err == nil {
+			return txt, nil
+		}
+		fmt.Printf("ERROR: unable to render team wording for %s: %v\n", name, err)
+	}
+
+	t := s.lookup(name, locale)
+	if t == nil {
+		return "", errors.Errorf("template not found: %s", name)
+	}
+	return execute(t, d)
+}
+
+// lookup returns the template with the name provided, preferring the variant
+// for the locale, then for its language. It returns nil if there is no
+// template with the name provided.
+func (s *Set) lookup(name, locale string) *template.Template {
+	candidates := []string{name}
+	if locale != "" {
+		lang := strings.SplitN(locale, "-", 2)[0]
+		candidates = []string{name + "." + locale, name + "." + lang, name}
+	}
+
+	for _, c := range candidates {
+		if t, ok := s.files[c]; ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// renderOverride executes the wording provided by a team as a template. The
+// parsed template is cached so that it is only parsed once.
+func (s *Set) renderOverride(txt string, d Data) (string, error) {
+	s.mu.Lock()
+	t, ok := s.overrides[txt]
+	if ok == false {
+		var err error
+		t, err = template.New("override").Parse(txt)
+		if err != nil {
+			s.mu.Unlock()
+			return "", err
+		}
+		if s.overrides == nil {
+			s.overrides = make(map[string]*template.Template)
+		}
+		s.overrides[txt] = t
+	}
+	s.mu.Unlock()
+
+	return execute(t, d)
+}
+
+func execute(t *template.Template, d Data) (string, error) {
+	var txt bytes.Buffer
+	if err := t.Execute(&txt, d); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(txt.String()), nil
+}
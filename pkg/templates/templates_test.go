@@ -0,0 +1,125 @@
+package templates
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dir returns a directory containing the templates provided, keyed by file
+// name. It is removed when the test completes.
+func dir(t *testing.T, files map[string]string) string {
+	d, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(d) })
+
+	for name, txt := range files {
+		if err := ioutil.WriteFile(filepath.Join(d, name), []byte(txt), 0600); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	return d
+}
+
+func TestRender(t *testing.T) {
+	s, err := Load(dir(t, map[string]string{
+		"reporter.txt":       "Thanks {{.Reporter}}\n",
+		"reporter.fr.txt":    "Merci {{.Reporter}}",
+		"reporter.pt-BR.txt": "Obrigado {{.Reporter}}",
+	}))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	tcs := []struct {
+		name     string
+		locale   string
+		override string
+		want     string
+	}{
+		{name: "default", locale: "", want: "Thanks alice"},
+		{name: "unknown locale", locale: "de-DE", want: "Thanks alice"},
+		{name: "language", locale: "fr-FR", want: "Merci alice"},
+		{name: "locale", locale: "pt-BR", want: "Obrigado alice"},
+		{name: "other region", locale: "pt-PT", want: "Thanks alice"},
+		{name: "override", locale: "fr-FR", override: "Cheers {{.Reporter}}", want: "Cheers alice"},
+		{name: "invalid override", locale: "", override: "Cheers {{.Nobody}}", want: "Thanks alice"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := s.Render(Reporter, tc.locale, tc.override, Data{Reporter: "alice"})
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if got != tc.want {
+				t.Errorf("unexpected text: %q", got)
+			}
+		})
+	}
+
+	if _, err := s.Render(Author, "", "", Data{}); err == nil {
+		t.Error("expected an error for a missing template")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	if _, err := Load(dir(t, map[string]string{"reporter.txt": "{{.Reporter"})); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tcs := []struct {
+		txt   string
+		valid bool
+	}{
+		{txt: "Thanks", valid: true},
+		{txt: "Thanks {{.Reporter}}, see {{.CoCURL}}", valid: true},
+		{txt: "Thanks {{.Reporter", valid: false},
+		{txt: "Thanks {{.Nobody}}", valid: false},
+	}
+
+	for _, tc := range tcs {
+		if err := Check(tc.txt); (err == nil) != tc.valid {
+			t.Errorf("unexpected result for %q: %v", tc.txt, err)
+		}
+	}
+}
+
+// TestTemplates checks the templates shipped with BuddyBot can be rendered.
+func TestTemplates(t *testing.T) {
+	s, err := Load(filepath.Join("..", "..", DefaultDir))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(s.files) == 0 {
+		t.Fatal("no templates found")
+	}
+
+	d := Data{ChannelID: "C1", CoCURL: "https://example.com/coc"}
+	for name := range s.files {
+		t.Run(name, func(t *testing.T) {
+			txt, err := execute(s.files[name], d)
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if txt == "" || strings.Contains(txt, d.CoCURL) == false {
+				t.Errorf("link to Code of Conduct missing: %q", txt)
+			}
+		})
+	}
+
+	// Reporters are told when their name is withheld from the admins.
+	for _, name := range []string{Reporter, Reporter + ".fr"} {
+		named, _ := execute(s.files[name], d)
+		anonymous, _ := execute(s.files[name], Data{Anonymous: true})
+		if named == anonymous {
+			t.Errorf("%s doesn't mention anonymity: %q", name, anonymous)
+		}
+	}
+}